@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/epicmet/dekamond-task/internal/authz"
+	"github.com/epicmet/dekamond-task/internal/logging"
+	"github.com/epicmet/dekamond-task/internal/problem"
+	"github.com/epicmet/dekamond-task/internal/users"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	currentUserKey    = "currentUser"
+	currentSessionKey = "currentSession"
+)
+
+// sessionTouchInterval is how stale the last_seen_at of a session may get
+// before a request updates it, so that most requests only read the session.
+const sessionTouchInterval = time.Minute
+
+func jwtSecret() []byte {
+	return []byte(cfg.JWT.Secret)
+}
+
+func parseJWT(tokenString string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (any, error) {
+		return jwtSecret(), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// requireAuth validates the bearer token of the request and loads the user
+// it was issued for, rejecting blocked users and tokens whose session was
+// logged out. Handlers behind it can call currentUser and currentSessionID.
+func requireAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenString, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || tokenString == "" {
+			problem.Abort(c, problem.New(http.StatusUnauthorized, problem.CodeUnauthenticated, "missing bearer token"))
+			return
+		}
+
+		claims, err := parseJWT(tokenString)
+		if err != nil {
+			problem.Abort(c, problem.New(http.StatusUnauthorized, problem.CodeTokenInvalid, "invalid token"))
+			return
+		}
+
+		userID, _ := claims["sub"].(string)
+		phoneNumber, _ := claims["phone_number"].(string)
+		if userID == "" || phoneNumber == "" {
+			problem.Abort(c, problem.New(http.StatusUnauthorized, problem.CodeTokenInvalid, "invalid token"))
+			return
+		}
+
+		// The token names the user by ID: a phone number can belong to
+		// another account once its user was deleted and it signed up again.
+		user, err := usersRepo.FindByID(c.Request.Context(), userID)
+		if err != nil {
+			if errors.Is(err, users.ErrUserNotFound) || errors.Is(err, users.ErrInvalidID) {
+				problem.Abort(c, problem.New(http.StatusUnauthorized, problem.CodeTokenInvalid, "invalid token"))
+				return
+			}
+			respondError(c, err, "fetch user")
+			return
+		}
+		if user.PhoneNumber != phoneNumber {
+			problem.Abort(c, problem.New(http.StatusUnauthorized, problem.CodeTokenInvalid, "invalid token"))
+			return
+		}
+
+		if user.IsBlocked(time.Now()) {
+			respondBlocked(c, user)
+			return
+		}
+
+		// Tokens issued before a role change must not keep granting the old
+		// role until they expire.
+		claimedRole, _ := claims["role"].(string)
+		role := users.Role(claimedRole)
+		if role == "" {
+			role = users.RoleUser
+		}
+		if role != user.EffectiveRole() {
+			problem.Abort(c, problem.New(http.StatusUnauthorized, problem.CodeTokenStale, "role has changed, please log in again"))
+			return
+		}
+
+		sessionID, _ := claims["sid"].(string)
+		if sessionID == "" {
+			problem.Abort(c, problem.New(http.StatusUnauthorized, problem.CodeTokenInvalid, "invalid token"))
+			return
+		}
+		sessions, err := usersRepo.Sessions(c.Request.Context(), user.ID.Hex())
+		if err != nil {
+			respondError(c, err, "fetch sessions")
+			return
+		}
+		i := slices.IndexFunc(sessions, func(s users.Session) bool { return s.ID == sessionID })
+		if i >= 0 && time.Since(sessions[i].LastSeenAt) >= sessionTouchInterval {
+			err = usersRepo.TouchSession(c.Request.Context(), user.ID.Hex(), sessionID)
+			if errors.Is(err, users.ErrConflict) {
+				// Logged out since it was read.
+				i = -1
+			} else if err != nil {
+				respondError(c, err, "update session")
+				return
+			}
+		}
+		if i < 0 {
+			problem.Abort(c, problem.New(http.StatusUnauthorized, problem.CodeSessionRevoked, "session has been logged out, please log in again"))
+			return
+		}
+
+		c.Set(currentUserKey, user)
+		c.Set(currentSessionKey, sessionID)
+		logging.With(c, "user_id", user.ID.Hex())
+		authz.SetPrincipal(c, authz.Principal{UserID: user.ID.Hex(), Role: role})
+		c.Next()
+	}
+}
+
+func currentUser(c *gin.Context) *users.User {
+	return c.MustGet(currentUserKey).(*users.User)
+}
+
+func currentSessionID(c *gin.Context) string {
+	return c.GetString(currentSessionKey)
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/epicmet/dekamond-task/internal/device"
+	"github.com/epicmet/dekamond-task/internal/logging"
+	"github.com/epicmet/dekamond-task/internal/otp"
+	"github.com/epicmet/dekamond-task/internal/problem"
+	"github.com/epicmet/dekamond-task/internal/users"
+	"github.com/gin-gonic/gin"
+)
+
+// deviceChallenges holds the challenges trusted devices sign to log in. The
+// subject of a challenge is the user ID and device ID separated by a colon.
+var deviceChallenges *otp.Challenges
+
+var errDeviceNotTrusted = errors.New("device is not trusted")
+
+// deviceRequest is a device to trust, sent along an OTP. PublicKey is base64
+// in JSON.
+type deviceRequest struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	PublicKey []byte `json:"public_key"`
+}
+
+// validateDevice responds with a validation problem and returns false if d
+// can't be trusted.
+func validateDevice(c *gin.Context, d *deviceRequest) bool {
+	var field, detail string
+	switch {
+	case d.ID == "" || len(d.ID) > 128:
+		field, detail = "device.id", "device id must be 1 to 128 characters"
+	case len(d.Name) > 64:
+		field, detail = "device.name", "device name must be at most 64 characters"
+	case device.ParsePublicKey(d.PublicKey) != nil:
+		field, detail = "device.public_key", "device public key must be a PKIX encoded ECDSA P-256 or Ed25519 key"
+	default:
+		return true
+	}
+	problem.Abort(c, problem.New(http.StatusBadRequest, problem.CodeValidationFailed, detail).With("field", field))
+	return false
+}
+
+// trustDevice trusts d for user for the configured period and returns when
+// the trust ends. Trusting a device again renews it.
+func trustDevice(ctx context.Context, user *users.User, d *deviceRequest) (time.Time, error) {
+	now := time.Now().UTC()
+	trustedUntil := now.Add(cfg.Devices.TrustTTL)
+	err := usersRepo.TrustDevice(ctx, user.ID.Hex(), users.Device{
+		ID:           d.ID,
+		Name:         d.Name,
+		PublicKey:    d.PublicKey,
+		CreatedAt:    now,
+		TrustedUntil: trustedUntil,
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	logging.FromContext(ctx).Info("device trusted", "device_id", d.ID)
+	return trustedUntil, nil
+}
+
+// trustedDevice returns the device of a user, or errDeviceNotTrusted if the
+// user has no such device or its trust has ended.
+func trustedDevice(ctx context.Context, userID, deviceID string) (*users.Device, error) {
+	devices, err := usersRepo.Devices(ctx, userID)
+	if errors.Is(err, users.ErrUserNotFound) {
+		return nil, errDeviceNotTrusted
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, d := range devices {
+		if d.ID == deviceID {
+			if !time.Now().Before(d.TrustedUntil) {
+				return nil, errDeviceNotTrusted
+			}
+			return &d, nil
+		}
+	}
+	return nil, errDeviceNotTrusted
+}
+
+func respondDeviceNotTrusted(c *gin.Context) {
+	problem.Abort(c, problem.New(http.StatusUnauthorized, problem.CodeDeviceNotTrusted, "device is not trusted, log in with an otp"))
+}
+
+// @Summary		Begin device login
+// @Description	Start logging in from a device trusted at /verify-otp. The returned challenge is signed with the device's private key and sent to /devices/login/finish.
+// @Tags			Devices
+// @Accept			json
+// @Produce		json
+// @Param			request	body		object{phone=string,device_id=string}	true	"Phone number and device ID"
+// @Success		200		{object}	object{challenge=string}
+// @Failure		400		{object}	problem.Problem
+// @Failure		401		{object}	problem.Problem
+// @Failure		500		{object}	problem.Problem
+// @Router			/devices/login/begin [post]
+func beginDeviceLogin(c *gin.Context) {
+	var req struct {
+		Phone    string `json:"phone"`
+		DeviceID string `json:"device_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Abort(c, problem.New(http.StatusBadRequest, problem.CodeInvalidRequest, "invalid request body"))
+		return
+	}
+
+	ctx := c.Request.Context()
+	user, err := usersRepo.FindByPhone(ctx, req.Phone)
+	if errors.Is(err, users.ErrUserNotFound) {
+		respondDeviceNotTrusted(c)
+		return
+	}
+	if err != nil {
+		respondError(c, err, "fetch data from db")
+		return
+	}
+	if _, err := trustedDevice(ctx, user.ID.Hex(), req.DeviceID); err != nil {
+		if errors.Is(err, errDeviceNotTrusted) {
+			respondDeviceNotTrusted(c)
+			return
+		}
+		respondError(c, err, "fetch devices")
+		return
+	}
+
+	challenge, err := deviceChallenges.Create(ctx, user.ID.Hex()+":"+req.DeviceID)
+	if err != nil {
+		respondError(c, err, "create device challenge")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"challenge": challenge})
+}
+
+// @Summary		Finish device login
+// @Description	Log in without an OTP with the signature of a challenge from /devices/login/begin: an ASN.1 ECDSA signature of the SHA-256 hash of the challenge string, or its Ed25519 signature, in base64. Users with TOTP enabled get an mfa_token, to be completed with /verify-totp.
+// @Tags			Devices
+// @Accept			json
+// @Produce		json
+// @Param			request	body		object{challenge=string,signature=string}	true	"Challenge and its signature"
+// @Success		200		{object}	object{message=string,token=string,mfa_required=bool,mfa_token=string}
+// @Failure		400		{object}	problem.Problem
+// @Failure		401		{object}	problem.Problem
+// @Failure		403		{object}	problem.Problem
+// @Failure		429		{object}	problem.Problem
+// @Failure		500		{object}	problem.Problem
+// @Router			/devices/login/finish [post]
+func finishDeviceLogin(c *gin.Context) {
+	var req struct {
+		Challenge string `json:"challenge"`
+		Signature []byte `json:"signature"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Challenge == "" {
+		problem.Abort(c, problem.New(http.StatusBadRequest, problem.CodeInvalidRequest, "invalid request body"))
+		return
+	}
+
+	// Only the signature is checked while passing the challenge; the user
+	// is loaded once it's passed.
+	ctx := c.Request.Context()
+	subject, err := deviceChallenges.Pass(ctx, req.Challenge, func(subject string) error {
+		userID, deviceID, _ := strings.Cut(subject, ":")
+		d, err := trustedDevice(ctx, userID, deviceID)
+		if err != nil {
+			return err
+		}
+		if device.Verify(d.PublicKey, []byte(req.Challenge), req.Signature) != nil {
+			return otp.ErrOTPMismatch
+		}
+		return nil
+	})
+	if errors.Is(err, errDeviceNotTrusted) {
+		respondDeviceNotTrusted(c)
+		return
+	}
+	if err != nil {
+		respondOTPError(c, "", err)
+		return
+	}
+
+	userID, deviceID, _ := strings.Cut(subject, ":")
+	user, err := usersRepo.FindByID(ctx, userID)
+	if err != nil {
+		respondError(c, err, "fetch data from db")
+		return
+	}
+	err = usersRepo.UseDevice(ctx, userID, deviceID)
+	if errors.Is(err, users.ErrConflict) {
+		respondDeviceNotTrusted(c)
+		return
+	}
+	if err != nil {
+		respondError(c, err, "update device")
+		return
+	}
+
+	_, body := loginPhone(c, user.PhoneNumber, mfaSubject{DeviceID: deviceID}, "device verified successfully")
+	if body == nil {
+		return
+	}
+	c.JSON(http.StatusOK, body)
+}
+
+// @Summary		List devices
+// @Description	List the devices the authenticated user trusted, oldest first, including those whose trust has ended
+// @Tags			Me
+// @Produce		json
+// @Security		BearerAuth
+// @Success		200	{object}	object{devices=[]users.Device}
+// @Failure		401	{object}	problem.Problem
+// @Failure		500	{object}	problem.Problem
+// @Router			/me/devices [get]
+func listDevices(c *gin.Context) {
+	devices, err := usersRepo.Devices(c.Request.Context(), currentUser(c).ID.Hex())
+	if err != nil {
+		respondError(c, err, "fetch devices")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"devices": devices})
+}
+
+// @Summary		Revoke device
+// @Description	Stop trusting a device of the authenticated user. It needs an OTP to log in again.
+// @Tags			Me
+// @Security		BearerAuth
+// @Param			id	path	string	true	"Device ID"
+// @Success		204
+// @Failure		401	{object}	problem.Problem
+// @Failure		404	{object}	problem.Problem
+// @Failure		500	{object}	problem.Problem
+// @Router			/me/devices/{id} [delete]
+func deleteDevice(c *gin.Context) {
+	err := usersRepo.DeleteDevice(c.Request.Context(), currentUser(c).ID.Hex(), c.Param("id"))
+	if errors.Is(err, users.ErrConflict) {
+		problem.Abort(c, problem.New(http.StatusNotFound, problem.CodeDeviceNotFound, "device not found"))
+		return
+	}
+	if err != nil {
+		respondError(c, err, "revoke device")
+		return
+	}
+	logging.FromContext(c.Request.Context()).Info("device revoked", "device_id", c.Param("id"))
+
+	c.Status(http.StatusNoContent)
+}
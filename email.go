@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+
+	"github.com/epicmet/dekamond-task/internal/logging"
+	"github.com/epicmet/dekamond-task/internal/problem"
+	"github.com/epicmet/dekamond-task/internal/users"
+	"github.com/gin-gonic/gin"
+)
+
+// @Summary		Send email verification link
+// @Description	Email a link confirming the email address of the authenticated user. Login links are only sent to confirmed addresses. A link works once and expires with the OTP TTL.
+// @Tags			Me
+// @Produce		json
+// @Security		BearerAuth
+// @Success		200	{object}	object{message=string,expires_in=int,resend_after=int,channel=string}
+// @Failure		401	{object}	problem.Problem
+// @Failure		409	{object}	problem.Problem
+// @Failure		429	{object}	problem.Problem
+// @Failure		500	{object}	problem.Problem
+// @Router			/me/email/verify [post]
+func sendEmailVerification(c *gin.Context) {
+	user := currentUser(c)
+	if user.Email == "" {
+		problem.Abort(c, problem.New(http.StatusConflict, problem.CodeEmailNotSet, "set an email address to verify it"))
+		return
+	}
+
+	delivery, err := magicLinks.SendVerification(c.Request.Context(), user.ID.Hex(), user.Email)
+	if err != nil {
+		respondSendError(c, err)
+		return
+	}
+	logging.FromContext(c.Request.Context()).Info("email verification sent", "channel", delivery.Channel)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "verification link has been sent",
+		"expires_in":   int(math.Round(delivery.ExpiresIn.Seconds())),
+		"resend_after": int(math.Round(delivery.ResendAfter.Seconds())),
+		"channel":      delivery.Channel,
+	})
+}
+
+// @Summary		Verify email address
+// @Description	Confirm an email address with a link emailed by /me/email/verify. The link fails if the address was changed since it was sent.
+// @Tags			Me
+// @Produce		json
+// @Param			token	query		string	true	"Token of the link"
+// @Success		200		{object}	users.User
+// @Failure		400		{object}	problem.Problem
+// @Failure		404		{object}	problem.Problem
+// @Failure		409		{object}	problem.Problem
+// @Failure		429		{object}	problem.Problem
+// @Failure		500		{object}	problem.Problem
+// @Router			/email/verify [get]
+func verifyEmail(c *gin.Context) {
+	userID, email, err := magicLinks.CheckVerification(c.Request.Context(), c.Query("token"))
+	if err != nil {
+		respondOTPError(c, "", err)
+		return
+	}
+
+	user, err := usersRepo.VerifyEmail(c.Request.Context(), userID, email)
+	if errors.Is(err, users.ErrConflict) {
+		problem.Abort(c, problem.New(http.StatusConflict, problem.CodeConflict, "email address has changed since the link was sent, request a new one"))
+		return
+	}
+	if err != nil {
+		respondError(c, err, "verify email")
+		return
+	}
+	logging.FromContext(c.Request.Context()).Info("email verified", "user_id", userID)
+
+	c.JSON(http.StatusOK, user)
+}
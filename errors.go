@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/epicmet/dekamond-task/internal/logging"
+	"github.com/epicmet/dekamond-task/internal/otp"
+	"github.com/epicmet/dekamond-task/internal/passkey"
+	"github.com/epicmet/dekamond-task/internal/problem"
+	"github.com/epicmet/dekamond-task/internal/users"
+	"github.com/gin-gonic/gin"
+)
+
+// respondError maps an error from the users package to its problem
+// response. Any other error is logged and answered with a 500 saying what
+// failed, e.g. action "fetch user" becomes "failed to fetch user".
+func respondError(c *gin.Context, err error, action string) {
+	var validationErr *users.ValidationError
+
+	switch {
+	case errors.As(err, &validationErr):
+		problem.Abort(c, problem.New(http.StatusBadRequest, problem.CodeValidationFailed, validationErr.Error()).
+			With("field", validationErr.Field))
+	case errors.Is(err, users.ErrInvalidID):
+		problem.Abort(c, problem.New(http.StatusBadRequest, problem.CodeInvalidUserID, "invalid user id"))
+	case errors.Is(err, users.ErrUserNotFound):
+		problem.Abort(c, problem.New(http.StatusNotFound, problem.CodeUserNotFound, "user not found"))
+	case errors.Is(err, users.ErrDuplicatePhone):
+		problem.Abort(c, problem.New(http.StatusConflict, problem.CodePhoneTaken, "phone number is already registered"))
+	case errors.Is(err, users.ErrConflict):
+		problem.Abort(c, problem.New(http.StatusConflict, problem.CodeConflict, "request conflicts with the current state of the user"))
+	default:
+		logging.FromContext(c.Request.Context()).Error("request failed", "action", action, "error", err)
+		problem.Abort(c, problem.New(http.StatusInternalServerError, problem.CodeInternal, "failed to "+action))
+	}
+}
+
+// respondPasskeyError maps an error from the passkey ceremonies to its
+// problem response, falling back to respondError.
+func respondPasskeyError(c *gin.Context, err error, action string) {
+	switch {
+	case errors.Is(err, passkey.ErrCeremonyNotFound):
+		problem.Abort(c, problem.New(http.StatusBadRequest, problem.CodePasskeyChallengeUnknown, "challenge is unknown, expired or already answered, start again"))
+	case errors.Is(err, passkey.ErrInvalid):
+		logging.FromContext(c.Request.Context()).Info("passkey rejected", "action", action, "error", err)
+		problem.Abort(c, problem.New(http.StatusBadRequest, problem.CodePasskeyInvalid, "passkey could not be verified"))
+	case errors.Is(err, users.ErrConflict):
+		problem.Abort(c, problem.New(http.StatusConflict, problem.CodePasskeyRegistered, "passkey is already registered"))
+	default:
+		respondError(c, err, action)
+	}
+}
+
+// respondSendError responds to an error sending an OTP or a magic link.
+func respondSendError(c *gin.Context, err error) {
+	var cooldownErr *otp.CooldownError
+	if errors.As(err, &cooldownErr) {
+		retryAfter := int(math.Ceil(cooldownErr.RetryAfter.Seconds()))
+		c.Header("Retry-After", strconv.Itoa(retryAfter))
+		problem.Abort(c, problem.New(http.StatusTooManyRequests, problem.CodeOTPResendTooSoon, "an otp was sent recently, wait before requesting another").
+			With("resend_after", retryAfter))
+		return
+	}
+	respondError(c, err, "send otp")
+}
+
+// respondOTPError maps an error from OTPProvider.Check to its problem
+// response. Codes that can't be retyped any more tell the client to request
+// a new one.
+func respondOTPError(c *gin.Context, phone string, err error) {
+	var checkErr *otp.CheckError
+
+	switch {
+	case errors.Is(err, otp.ErrLinkInvalid):
+		problem.Abort(c, problem.New(http.StatusBadRequest, problem.CodeMagicLinkInvalid, "magic link is invalid, request a new one"))
+	case errors.Is(err, otp.ErrOTPNotRequested):
+		problem.Abort(c, problem.New(http.StatusBadRequest, problem.CodeOTPNotRequested, "no otp was requested for this phone number, request a new one"))
+	case errors.Is(err, otp.ErrOTPExpired):
+		problem.Abort(c, problem.New(http.StatusBadRequest, problem.CodeOTPExpired, "otp has expired, request a new one"))
+	case errors.Is(err, otp.ErrOTPLocked):
+		logging.FromContext(c.Request.Context()).Warn("otp locked", "phone", phone)
+		problem.Abort(c, problem.New(http.StatusTooManyRequests, problem.CodeOTPLocked, "too many failed attempts, request a new otp"))
+	case errors.Is(err, otp.ErrOTPReused) && errors.As(err, &checkErr):
+		problem.Abort(c, problem.New(http.StatusBadRequest, problem.CodeOTPReused, "code was already used, wait for the next one").
+			With("remaining_attempts", checkErr.RemainingAttempts))
+	case errors.As(err, &checkErr):
+		logging.FromContext(c.Request.Context()).Warn("invalid otp", "phone", phone, "remaining_attempts", checkErr.RemainingAttempts)
+		problem.Abort(c, problem.New(http.StatusBadRequest, problem.CodeOTPInvalid, "invalid otp").
+			With("remaining_attempts", checkErr.RemainingAttempts))
+	default:
+		respondError(c, err, "check otp")
+	}
+}
@@ -0,0 +1,98 @@
+package authz
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/epicmet/dekamond-task/internal/problem"
+	"github.com/epicmet/dekamond-task/internal/users"
+	"github.com/gin-gonic/gin"
+)
+
+type Permission string
+
+const (
+	PermListUsers    Permission = "users:list"
+	PermReadUsers    Permission = "users:read"
+	PermUpdateUsers  Permission = "users:update"
+	PermDeleteUsers  Permission = "users:delete"
+	PermManageStatus Permission = "users:status"
+	PermManageRoles  Permission = "users:roles"
+)
+
+var rolePermissions = map[users.Role][]Permission{
+	users.RoleUser: {},
+	users.RoleSupport: {
+		PermListUsers,
+		PermReadUsers,
+		PermUpdateUsers,
+		PermManageStatus,
+	},
+	users.RoleAdmin: {
+		PermListUsers,
+		PermReadUsers,
+		PermUpdateUsers,
+		PermDeleteUsers,
+		PermManageStatus,
+		PermManageRoles,
+	},
+}
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	UserID string
+	Role   users.Role
+}
+
+func (p Principal) Can(perm Permission) bool {
+	return slices.Contains(rolePermissions[p.Role], perm)
+}
+
+const principalKey = "authz.principal"
+
+// SetPrincipal records the authenticated caller. It must be called by the
+// authentication middleware before any of the middlewares of this package.
+func SetPrincipal(c *gin.Context, p Principal) {
+	c.Set(principalKey, p)
+}
+
+func PrincipalFrom(c *gin.Context) (Principal, bool) {
+	p, ok := c.Get(principalKey)
+	if !ok {
+		return Principal{}, false
+	}
+	return p.(Principal), true
+}
+
+// Require allows the request only if the caller has every given permission.
+func Require(perms ...Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p, ok := PrincipalFrom(c)
+		if !ok {
+			problem.Abort(c, problem.New(http.StatusUnauthorized, problem.CodeUnauthenticated, "authentication required"))
+			return
+		}
+
+		for _, perm := range perms {
+			if !p.Can(perm) {
+				problem.Abort(c, problem.New(http.StatusForbidden, problem.CodePermissionDenied, "permission denied"))
+				return
+			}
+		}
+	}
+}
+
+// RequireOwnerOr allows the request if the caller is the user identified by
+// the given path parameter, or otherwise has every given permission.
+func RequireOwnerOr(param string, perms ...Permission) gin.HandlerFunc {
+	require := Require(perms...)
+
+	return func(c *gin.Context) {
+		p, ok := PrincipalFrom(c)
+		if ok && p.UserID == c.Param(param) {
+			return
+		}
+
+		require(c)
+	}
+}
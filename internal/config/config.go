@@ -0,0 +1,635 @@
+// Package config loads the service configuration. Values are layered, each
+// layer overriding the previous one: built-in defaults, an optional YAML
+// file, environment variables and finally command-line flags.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/epicmet/dekamond-task/internal/logging"
+	"golang.org/x/text/language"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultJWTSecret is the signing secret used when none is configured. It is
+// fine for local development but the service refuses to start with it in
+// release mode.
+const DefaultJWTSecret = "dummy dum key"
+
+const redacted = "REDACTED"
+
+const (
+	ModeDebug   = "debug"
+	ModeRelease = "release"
+	ModeTest    = "test"
+)
+
+type Config struct {
+	Mode              string          `yaml:"mode"`
+	HTTP              HTTPConfig      `yaml:"http"`
+	JWT               JWTConfig       `yaml:"jwt"`
+	DB                DBConfig        `yaml:"db"`
+	OTP               OTPConfig       `yaml:"otp"`
+	TOTP              TOTPConfig      `yaml:"totp"`
+	WebAuthn          WebAuthnConfig  `yaml:"webauthn"`
+	MagicLink         MagicLinkConfig `yaml:"magic_link"`
+	Devices           DevicesConfig   `yaml:"devices"`
+	RateLimit         RateLimitConfig `yaml:"rate_limit"`
+	Purge             PurgeConfig     `yaml:"purge"`
+	Health            HealthConfig    `yaml:"health"`
+	Log               LogConfig       `yaml:"log"`
+	Tracing           TracingConfig   `yaml:"tracing"`
+	AdminPhoneNumbers []string        `yaml:"admin_phone_numbers"`
+}
+
+type HTTPConfig struct {
+	Port            int           `yaml:"port"`
+	ReadTimeout     time.Duration `yaml:"read_timeout"`
+	WriteTimeout    time.Duration `yaml:"write_timeout"`
+	IdleTimeout     time.Duration `yaml:"idle_timeout"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+}
+
+type JWTConfig struct {
+	Secret string        `yaml:"secret"`
+	TTL    time.Duration `yaml:"ttl"`
+}
+
+type DBConfig struct {
+	Driver         string        `yaml:"driver"`
+	MongoURI       string        `yaml:"mongo_uri"`
+	MongoDatabase  string        `yaml:"mongo_database"`
+	PostgresDSN    string        `yaml:"postgres_dsn"`
+	SQLitePath     string        `yaml:"sqlite_path"`
+	ConnectTimeout time.Duration `yaml:"connect_timeout"`
+	OpTimeout      time.Duration `yaml:"op_timeout"`
+}
+
+// OTPConfig configures the codes sent by /send-otp. A code is locked once
+// it has been guessed wrong MaxAttempts times, and a new one can't be sent
+// to the same phone number within ResendCooldown of the last.
+//
+// Codes are sent in a message rendered from the <locale>.tmpl template,
+// built in for en and fa and looked up in TemplatesDir first, best matching
+// the user's languages and falling back to DefaultLocale. AppHash and Domain
+// enable the Android SMS Retriever and iOS one-time-code formats.
+type OTPConfig struct {
+	Length         int           `yaml:"length"`
+	TTL            time.Duration `yaml:"ttl"`
+	MaxAttempts    int           `yaml:"max_attempts"`
+	ResendCooldown time.Duration `yaml:"resend_cooldown"`
+	AppName        string        `yaml:"app_name"`
+	AppHash        string        `yaml:"app_hash"`
+	Domain         string        `yaml:"domain"`
+	DefaultLocale  string        `yaml:"default_locale"`
+	TemplatesDir   string        `yaml:"templates_dir"`
+}
+
+// RateLimitConfig configures the token bucket in front of /send-otp:
+// SendOTPCapacity requests are allowed per SendOTPRefill.
+type RateLimitConfig struct {
+	SendOTPCapacity int64         `yaml:"send_otp_capacity"`
+	SendOTPRefill   time.Duration `yaml:"send_otp_refill"`
+}
+
+// TOTPConfig configures the authenticator app second factor. Codes of Skew
+// periods before and after the current one are accepted too.
+type TOTPConfig struct {
+	Issuer        string `yaml:"issuer"`
+	Skew          int    `yaml:"skew"`
+	RecoveryCodes int    `yaml:"recovery_codes"`
+}
+
+// WebAuthnConfig configures passkeys. They are bound to RPID, a domain, and
+// can only be used from pages at one of RPOrigins.
+type WebAuthnConfig struct {
+	RPID      string   `yaml:"rp_id"`
+	RPName    string   `yaml:"rp_name"`
+	RPOrigins []string `yaml:"rp_origins"`
+}
+
+// MagicLinkConfig configures login links sent by email. Links point to URL,
+// which must serve GET /magic-link. When RedirectURL is set, opening a link
+// redirects there with its token in the fragment instead of showing a page
+// to confirm the login, for example to an app deep link. Links confirming an email address point to
+// VerifyURL, which must serve GET /email/verify.
+type MagicLinkConfig struct {
+	URL         string `yaml:"url"`
+	RedirectURL string `yaml:"redirect_url"`
+	VerifyURL   string `yaml:"verify_url"`
+}
+
+// DevicesConfig configures trusted devices, which log in without an OTP for
+// TrustTTL after the OTP login that trusted them.
+type DevicesConfig struct {
+	TrustTTL time.Duration `yaml:"trust_ttl"`
+}
+
+type HealthConfig struct {
+	// Timeout bounds each dependency check of /readyz.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// LogConfig configures logging. Logs are JSON in release mode and text
+// otherwise.
+type LogConfig struct {
+	Level string `yaml:"level"`
+}
+
+// SlogLevel returns the parsed Level. It must only be called on a validated
+// config.
+func (l LogConfig) SlogLevel() slog.Level {
+	var level slog.Level
+	level.UnmarshalText([]byte(l.Level))
+	return level
+}
+
+// TracingConfig configures OpenTelemetry tracing. The OTLP exporter reads
+// its endpoint from the standard OTEL_EXPORTER_OTLP_* variables.
+type TracingConfig struct {
+	Exporter    string  `yaml:"exporter"`
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+type PurgeConfig struct {
+	Retention time.Duration `yaml:"retention"`
+	Interval  time.Duration `yaml:"interval"`
+}
+
+// Default returns the built-in configuration.
+func Default() *Config {
+	return &Config{
+		Mode: ModeDebug,
+		HTTP: HTTPConfig{
+			Port:            8080,
+			ReadTimeout:     10 * time.Second,
+			WriteTimeout:    10 * time.Second,
+			IdleTimeout:     time.Minute,
+			ShutdownTimeout: 15 * time.Second,
+		},
+		JWT: JWTConfig{
+			Secret: DefaultJWTSecret,
+			TTL:    24 * time.Hour,
+		},
+		DB: DBConfig{
+			Driver:         "mongo",
+			MongoURI:       "mongodb://localhost:27017",
+			MongoDatabase:  "dekamond-task",
+			PostgresDSN:    "postgres://localhost:5432/dekamond-task",
+			SQLitePath:     "dekamond-task.db",
+			ConnectTimeout: 10 * time.Second,
+			OpTimeout:      5 * time.Second,
+		},
+		OTP: OTPConfig{
+			Length:         6,
+			TTL:            2 * time.Minute,
+			MaxAttempts:    5,
+			ResendCooldown: time.Minute,
+			AppName:        "Dekamond",
+			DefaultLocale:  "fa",
+		},
+		TOTP: TOTPConfig{
+			Issuer:        "Dekamond",
+			Skew:          1,
+			RecoveryCodes: 10,
+		},
+		WebAuthn: WebAuthnConfig{
+			RPID:      "localhost",
+			RPName:    "Dekamond",
+			RPOrigins: []string{"http://localhost:8080"},
+		},
+		MagicLink: MagicLinkConfig{
+			URL:       "http://localhost:8080/magic-link",
+			VerifyURL: "http://localhost:8080/email/verify",
+		},
+		Devices: DevicesConfig{
+			TrustTTL: 30 * 24 * time.Hour,
+		},
+		RateLimit: RateLimitConfig{
+			SendOTPCapacity: 3,
+			SendOTPRefill:   10 * time.Minute,
+		},
+		Purge: PurgeConfig{
+			Retention: 30 * 24 * time.Hour,
+			Interval:  time.Hour,
+		},
+		Health: HealthConfig{
+			Timeout: 2 * time.Second,
+		},
+		Log: LogConfig{
+			Level: "info",
+		},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			SampleRatio: 1,
+		},
+	}
+}
+
+// Load builds the configuration from the defaults, the YAML file named by
+// the -config flag or CONFIG_FILE, the environment and args, and validates
+// the result.
+func Load(args []string) (*Config, error) {
+	// The file layer sits below env and flags, so parse the flags once just
+	// to find it and again, onto the real config, once the file and env are
+	// applied.
+	var path string
+	if err := Default().flagSet(&path).Parse(args); err != nil {
+		return nil, err
+	}
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+
+	cfg := Default()
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.loadEnv(); err != nil {
+		return nil, err
+	}
+	if err := cfg.flagSet(&path).Parse(args); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil {
+		return fmt.Errorf("config: parse %s: %w", path, err)
+	}
+	return nil
+}
+
+func (c *Config) loadEnv() error {
+	var errs []error
+	str := func(key string, dst *string) {
+		if v := os.Getenv(key); v != "" {
+			*dst = v
+		}
+	}
+	integer := func(key string, dst *int) {
+		if v := os.Getenv(key); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid integer %q", key, v))
+				return
+			}
+			*dst = n
+		}
+	}
+	integer64 := func(key string, dst *int64) {
+		if v := os.Getenv(key); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid integer %q", key, v))
+				return
+			}
+			*dst = n
+		}
+	}
+	float := func(key string, dst *float64) {
+		if v := os.Getenv(key); v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid number %q", key, v))
+				return
+			}
+			*dst = f
+		}
+	}
+	duration := func(key string, dst *time.Duration) {
+		if v := os.Getenv(key); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid duration %q", key, v))
+				return
+			}
+			*dst = d
+		}
+	}
+
+	str("GIN_MODE", &c.Mode)
+	integer("PORT", &c.HTTP.Port)
+	duration("HTTP_READ_TIMEOUT", &c.HTTP.ReadTimeout)
+	duration("HTTP_WRITE_TIMEOUT", &c.HTTP.WriteTimeout)
+	duration("HTTP_IDLE_TIMEOUT", &c.HTTP.IdleTimeout)
+	duration("SHUTDOWN_TIMEOUT", &c.HTTP.ShutdownTimeout)
+	str("JWT_SECRET_KEY", &c.JWT.Secret)
+	duration("JWT_TTL", &c.JWT.TTL)
+	str("DB_DRIVER", &c.DB.Driver)
+	str("MONGO_URI", &c.DB.MongoURI)
+	str("DB_NAME", &c.DB.MongoDatabase)
+	str("POSTGRES_DSN", &c.DB.PostgresDSN)
+	str("SQLITE_PATH", &c.DB.SQLitePath)
+	duration("DB_CONNECT_TIMEOUT", &c.DB.ConnectTimeout)
+	duration("DB_OP_TIMEOUT", &c.DB.OpTimeout)
+	integer("OTP_LENGTH", &c.OTP.Length)
+	duration("OTP_TTL", &c.OTP.TTL)
+	integer("OTP_MAX_ATTEMPTS", &c.OTP.MaxAttempts)
+	duration("OTP_RESEND_COOLDOWN", &c.OTP.ResendCooldown)
+	str("OTP_APP_NAME", &c.OTP.AppName)
+	str("OTP_APP_HASH", &c.OTP.AppHash)
+	str("OTP_DOMAIN", &c.OTP.Domain)
+	str("OTP_DEFAULT_LOCALE", &c.OTP.DefaultLocale)
+	str("OTP_TEMPLATES_DIR", &c.OTP.TemplatesDir)
+	str("TOTP_ISSUER", &c.TOTP.Issuer)
+	integer("TOTP_SKEW", &c.TOTP.Skew)
+	integer("TOTP_RECOVERY_CODES", &c.TOTP.RecoveryCodes)
+	str("WEBAUTHN_RP_ID", &c.WebAuthn.RPID)
+	str("WEBAUTHN_RP_NAME", &c.WebAuthn.RPName)
+	if v := os.Getenv("WEBAUTHN_RP_ORIGINS"); v != "" {
+		c.WebAuthn.RPOrigins = splitList(v)
+	}
+	str("MAGIC_LINK_URL", &c.MagicLink.URL)
+	str("MAGIC_LINK_REDIRECT_URL", &c.MagicLink.RedirectURL)
+	str("MAGIC_LINK_VERIFY_URL", &c.MagicLink.VerifyURL)
+	duration("DEVICE_TRUST_TTL", &c.Devices.TrustTTL)
+	integer64("SEND_OTP_RATE_CAPACITY", &c.RateLimit.SendOTPCapacity)
+	duration("SEND_OTP_RATE_REFILL", &c.RateLimit.SendOTPRefill)
+	duration("USER_PURGE_RETENTION", &c.Purge.Retention)
+	duration("USER_PURGE_INTERVAL", &c.Purge.Interval)
+	duration("HEALTH_CHECK_TIMEOUT", &c.Health.Timeout)
+	str("LOG_LEVEL", &c.Log.Level)
+	str("TRACING_EXPORTER", &c.Tracing.Exporter)
+	float("TRACING_SAMPLE_RATIO", &c.Tracing.SampleRatio)
+	if v := os.Getenv("ADMIN_PHONE_NUMBERS"); v != "" {
+		c.AdminPhoneNumbers = splitList(v)
+	}
+
+	return errors.Join(errs...)
+}
+
+// flagSet binds a flag to every setting, using the current values as the
+// defaults so that flags left unset keep them.
+func (c *Config) flagSet(path *string) *flag.FlagSet {
+	fs := flag.NewFlagSet("dekamond-task", flag.ContinueOnError)
+	fs.StringVar(path, "config", *path, "path to a YAML config file")
+	fs.StringVar(&c.Mode, "mode", c.Mode, "gin mode (debug, release, test)")
+	fs.IntVar(&c.HTTP.Port, "port", c.HTTP.Port, "HTTP port")
+	fs.DurationVar(&c.HTTP.ReadTimeout, "http-read-timeout", c.HTTP.ReadTimeout, "HTTP read timeout")
+	fs.DurationVar(&c.HTTP.WriteTimeout, "http-write-timeout", c.HTTP.WriteTimeout, "HTTP write timeout")
+	fs.DurationVar(&c.HTTP.IdleTimeout, "http-idle-timeout", c.HTTP.IdleTimeout, "HTTP keep-alive idle timeout")
+	fs.DurationVar(&c.HTTP.ShutdownTimeout, "shutdown-timeout", c.HTTP.ShutdownTimeout, "how long in-flight requests are drained for on shutdown")
+	fs.StringVar(&c.JWT.Secret, "jwt-secret", c.JWT.Secret, "JWT signing secret")
+	fs.DurationVar(&c.JWT.TTL, "jwt-ttl", c.JWT.TTL, "lifetime of issued JWTs")
+	fs.StringVar(&c.DB.Driver, "db-driver", c.DB.Driver, "user store (mongo, postgres, sqlite, memory)")
+	fs.StringVar(&c.DB.MongoURI, "mongo-uri", c.DB.MongoURI, "MongoDB connection string")
+	fs.StringVar(&c.DB.MongoDatabase, "mongo-database", c.DB.MongoDatabase, "MongoDB database name")
+	fs.StringVar(&c.DB.PostgresDSN, "postgres-dsn", c.DB.PostgresDSN, "PostgreSQL connection string")
+	fs.StringVar(&c.DB.SQLitePath, "sqlite-path", c.DB.SQLitePath, "SQLite database file")
+	fs.DurationVar(&c.DB.ConnectTimeout, "db-connect-timeout", c.DB.ConnectTimeout, "how long to wait for the database on startup")
+	fs.DurationVar(&c.DB.OpTimeout, "db-op-timeout", c.DB.OpTimeout, "upper bound for a single database operation")
+	fs.IntVar(&c.OTP.Length, "otp-length", c.OTP.Length, "number of digits in an OTP")
+	fs.DurationVar(&c.OTP.TTL, "otp-ttl", c.OTP.TTL, "how long an OTP stays valid")
+	fs.IntVar(&c.OTP.MaxAttempts, "otp-max-attempts", c.OTP.MaxAttempts, "wrong guesses allowed before an OTP is locked")
+	fs.DurationVar(&c.OTP.ResendCooldown, "otp-resend-cooldown", c.OTP.ResendCooldown, "minimum time between two OTPs sent to a phone number")
+	fs.StringVar(&c.OTP.AppName, "otp-app-name", c.OTP.AppName, "app name shown in OTP messages")
+	fs.StringVar(&c.OTP.AppHash, "otp-app-hash", c.OTP.AppHash, "Android app hash appended to OTP messages for the SMS Retriever API")
+	fs.StringVar(&c.OTP.Domain, "otp-domain", c.OTP.Domain, "domain bound to OTPs in iOS one-time-code messages")
+	fs.StringVar(&c.OTP.DefaultLocale, "otp-default-locale", c.OTP.DefaultLocale, "locale of OTP messages when none of the user's languages has a template")
+	fs.StringVar(&c.OTP.TemplatesDir, "otp-templates-dir", c.OTP.TemplatesDir, "directory of <locale>.tmpl OTP message templates")
+	fs.StringVar(&c.TOTP.Issuer, "totp-issuer", c.TOTP.Issuer, "issuer shown in authenticator apps")
+	fs.IntVar(&c.TOTP.Skew, "totp-skew", c.TOTP.Skew, "TOTP periods accepted before and after the current one")
+	fs.IntVar(&c.TOTP.RecoveryCodes, "totp-recovery-codes", c.TOTP.RecoveryCodes, "recovery codes issued when TOTP is enabled")
+	fs.StringVar(&c.WebAuthn.RPID, "webauthn-rp-id", c.WebAuthn.RPID, "domain passkeys are bound to")
+	fs.StringVar(&c.WebAuthn.RPName, "webauthn-rp-name", c.WebAuthn.RPName, "relying party name shown when creating passkeys")
+	fs.Func("webauthn-rp-origins", "comma separated origins allowed to use passkeys", func(v string) error {
+		c.WebAuthn.RPOrigins = splitList(v)
+		return nil
+	})
+	fs.StringVar(&c.MagicLink.URL, "magic-link-url", c.MagicLink.URL, "URL of GET /magic-link that emailed login links point to")
+	fs.StringVar(&c.MagicLink.RedirectURL, "magic-link-redirect-url", c.MagicLink.RedirectURL, "app URL opened login links redirect to, instead of showing a confirmation page")
+	fs.StringVar(&c.MagicLink.VerifyURL, "magic-link-verify-url", c.MagicLink.VerifyURL, "URL of GET /email/verify that emailed verification links point to")
+	fs.DurationVar(&c.Devices.TrustTTL, "device-trust-ttl", c.Devices.TrustTTL, "how long a device trusted at an OTP login can log in without an OTP")
+	fs.Int64Var(&c.RateLimit.SendOTPCapacity, "send-otp-rate-capacity", c.RateLimit.SendOTPCapacity, "send-otp requests allowed per refill period")
+	fs.DurationVar(&c.RateLimit.SendOTPRefill, "send-otp-rate-refill", c.RateLimit.SendOTPRefill, "send-otp token bucket refill period")
+	fs.DurationVar(&c.Purge.Retention, "user-purge-retention", c.Purge.Retention, "how long deleted users are kept")
+	fs.DurationVar(&c.Purge.Interval, "user-purge-interval", c.Purge.Interval, "how often the purge job runs")
+	fs.DurationVar(&c.Health.Timeout, "health-check-timeout", c.Health.Timeout, "timeout of each readiness check")
+	fs.StringVar(&c.Log.Level, "log-level", c.Log.Level, "minimum log level (debug, info, warn, error)")
+	fs.StringVar(&c.Tracing.Exporter, "tracing-exporter", c.Tracing.Exporter, "trace exporter (none, stdout, otlp)")
+	fs.Float64Var(&c.Tracing.SampleRatio, "tracing-sample-ratio", c.Tracing.SampleRatio, "fraction of traces to sample, 0-1")
+	fs.Func("admin-phone-numbers", "comma separated phone numbers that become admins on login", func(v string) error {
+		c.AdminPhoneNumbers = splitList(v)
+		return nil
+	})
+	return fs
+}
+
+// Validate reports every invalid setting at once.
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+	positive := func(name string, d time.Duration) {
+		if d <= 0 {
+			fail("%s must be positive, got %s", name, d)
+		}
+	}
+
+	switch c.Mode {
+	case ModeDebug, ModeRelease, ModeTest:
+	default:
+		fail("mode must be one of debug, release, test, got %q", c.Mode)
+	}
+
+	if c.HTTP.Port < 1 || c.HTTP.Port > 65535 {
+		fail("http.port must be between 1 and 65535, got %d", c.HTTP.Port)
+	}
+	positive("http.read_timeout", c.HTTP.ReadTimeout)
+	positive("http.write_timeout", c.HTTP.WriteTimeout)
+	positive("http.idle_timeout", c.HTTP.IdleTimeout)
+	positive("http.shutdown_timeout", c.HTTP.ShutdownTimeout)
+
+	if c.JWT.Secret == "" {
+		fail("jwt.secret must not be empty")
+	} else if c.Mode == ModeRelease && c.JWT.Secret == DefaultJWTSecret {
+		fail("jwt.secret must be changed from the default in release mode")
+	}
+	positive("jwt.ttl", c.JWT.TTL)
+
+	switch c.DB.Driver {
+	case "mongo":
+		if c.DB.MongoURI == "" || c.DB.MongoDatabase == "" {
+			fail("db.mongo_uri and db.mongo_database are required for the mongo driver")
+		}
+	case "postgres":
+		if c.DB.PostgresDSN == "" {
+			fail("db.postgres_dsn is required for the postgres driver")
+		}
+	case "sqlite":
+		if c.DB.SQLitePath == "" {
+			fail("db.sqlite_path is required for the sqlite driver")
+		}
+	case "memory":
+	default:
+		fail("db.driver must be one of mongo, postgres, sqlite, memory, got %q", c.DB.Driver)
+	}
+	positive("db.connect_timeout", c.DB.ConnectTimeout)
+	positive("db.op_timeout", c.DB.OpTimeout)
+
+	if c.OTP.Length < 4 || c.OTP.Length > 10 {
+		fail("otp.length must be between 4 and 10, got %d", c.OTP.Length)
+	}
+	positive("otp.ttl", c.OTP.TTL)
+	if c.OTP.MaxAttempts < 1 {
+		fail("otp.max_attempts must be at least 1, got %d", c.OTP.MaxAttempts)
+	}
+	if c.OTP.ResendCooldown < 0 || c.OTP.ResendCooldown > c.OTP.TTL {
+		fail("otp.resend_cooldown must be between 0 and otp.ttl, got %s", c.OTP.ResendCooldown)
+	}
+	if c.OTP.AppHash != "" && len(c.OTP.AppHash) != 11 {
+		fail("otp.app_hash must be 11 characters, got %q", c.OTP.AppHash)
+	}
+	if _, err := language.Parse(c.OTP.DefaultLocale); err != nil {
+		fail("otp.default_locale must be a BCP 47 language tag, got %q", c.OTP.DefaultLocale)
+	}
+
+	if c.TOTP.Issuer == "" {
+		fail("totp.issuer must be set")
+	}
+	if c.TOTP.Skew < 0 || c.TOTP.Skew > 10 {
+		fail("totp.skew must be between 0 and 10, got %d", c.TOTP.Skew)
+	}
+	if c.TOTP.RecoveryCodes < 1 {
+		fail("totp.recovery_codes must be at least 1, got %d", c.TOTP.RecoveryCodes)
+	}
+
+	if c.WebAuthn.RPID == "" || strings.ContainsAny(c.WebAuthn.RPID, ":/") {
+		fail("webauthn.rp_id must be a domain without scheme or port, got %q", c.WebAuthn.RPID)
+	}
+	if c.WebAuthn.RPName == "" {
+		fail("webauthn.rp_name must be set")
+	}
+	if len(c.WebAuthn.RPOrigins) == 0 {
+		fail("webauthn.rp_origins must list at least one origin")
+	}
+	for _, origin := range c.WebAuthn.RPOrigins {
+		if u, err := url.Parse(origin); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			fail("webauthn.rp_origins must be http(s) origins, got %q", origin)
+		}
+	}
+
+	if u, err := url.Parse(c.MagicLink.URL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		fail("magic_link.url must be an http(s) URL, got %q", c.MagicLink.URL)
+	}
+	if u, err := url.Parse(c.MagicLink.VerifyURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		fail("magic_link.verify_url must be an http(s) URL, got %q", c.MagicLink.VerifyURL)
+	}
+	if c.MagicLink.RedirectURL != "" {
+		if u, err := url.Parse(c.MagicLink.RedirectURL); err != nil || u.Scheme == "" {
+			fail("magic_link.redirect_url must be an absolute URL, got %q", c.MagicLink.RedirectURL)
+		}
+	}
+
+	positive("devices.trust_ttl", c.Devices.TrustTTL)
+
+	if c.RateLimit.SendOTPCapacity < 1 {
+		fail("rate_limit.send_otp_capacity must be at least 1, got %d", c.RateLimit.SendOTPCapacity)
+	}
+	positive("rate_limit.send_otp_refill", c.RateLimit.SendOTPRefill)
+
+	positive("purge.retention", c.Purge.Retention)
+	positive("purge.interval", c.Purge.Interval)
+	positive("health.timeout", c.Health.Timeout)
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		fail("log.level must be one of debug, info, warn, error, got %q", c.Log.Level)
+	}
+
+	switch c.Tracing.Exporter {
+	case "none", "stdout", "otlp":
+	default:
+		fail("tracing.exporter must be one of none, stdout, otlp, got %q", c.Tracing.Exporter)
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		fail("tracing.sample_ratio must be between 0 and 1, got %g", c.Tracing.SampleRatio)
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("invalid config:\n%w", err)
+	}
+	return nil
+}
+
+// Redacted returns a copy of the configuration that is safe to log: the JWT
+// secret and any passwords in connection strings are masked, and admin phone
+// numbers are masked like phone numbers in the logs.
+func (c *Config) Redacted() *Config {
+	r := *c
+	r.AdminPhoneNumbers = make([]string, len(c.AdminPhoneNumbers))
+	for i, pn := range c.AdminPhoneNumbers {
+		r.AdminPhoneNumbers[i] = logging.MaskPhone(pn)
+	}
+	r.WebAuthn.RPOrigins = append([]string(nil), c.WebAuthn.RPOrigins...)
+	if r.JWT.Secret != "" {
+		r.JWT.Secret = redacted
+	}
+	r.DB.MongoURI = redactURL(r.DB.MongoURI)
+	r.DB.PostgresDSN = redactURL(r.DB.PostgresDSN)
+	return &r
+}
+
+// String renders the redacted configuration as YAML.
+func (c *Config) String() string {
+	out, err := yaml.Marshal(c.Redacted())
+	if err != nil {
+		return fmt.Sprintf("config: %v", err)
+	}
+	return string(out)
+}
+
+var dsnPassword = regexp.MustCompile(`(password=)('[^']*'|\S*)`)
+
+// redactURL masks the password of a URL, or of a key=value PostgreSQL DSN.
+func redactURL(raw string) string {
+	if !strings.Contains(raw, "://") {
+		return dsnPassword.ReplaceAllString(raw, "${1}"+redacted)
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		// Don't risk echoing a secret we couldn't locate.
+		return redacted
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redacted)
+	}
+	return u.String()
+}
+
+func splitList(v string) []string {
+	var out []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
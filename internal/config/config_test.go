@@ -0,0 +1,91 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadLayers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	file := "otp:\n  length: 8\n  ttl: 5m\nhttp:\n  port: 9000\n"
+	if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("OTP_TTL", "3m")
+	t.Setenv("PORT", "9001")
+
+	cfg, err := Load([]string{"-port", "9002"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.OTP.Length != 8 {
+		t.Errorf("otp length = %d, want 8 from the file", cfg.OTP.Length)
+	}
+	if cfg.OTP.TTL != 3*time.Minute {
+		t.Errorf("otp ttl = %s, want 3m from the env", cfg.OTP.TTL)
+	}
+	if cfg.HTTP.Port != 9002 {
+		t.Errorf("port = %d, want 9002 from the flags", cfg.HTTP.Port)
+	}
+	if cfg.RateLimit.SendOTPCapacity != 3 {
+		t.Errorf("send otp capacity = %d, want the default 3", cfg.RateLimit.SendOTPCapacity)
+	}
+}
+
+func TestLoadRejectsUnknownFileKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("otp:\n  lenght: 8\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Load([]string{"-config", path}); err == nil {
+		t.Fatal("expected an error for a misspelled key")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cfg := Default()
+	cfg.Mode = ModeRelease
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "jwt.secret") {
+		t.Errorf("release mode with the default secret: err = %v", err)
+	}
+
+	cfg.JWT.Secret = "a-real-secret"
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("release mode with a secret: %v", err)
+	}
+
+	cfg.OTP.Length = 0
+	cfg.DB.Driver = "oracle"
+	err := cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "otp.length") || !strings.Contains(err.Error(), "db.driver") {
+		t.Errorf("expected both otp.length and db.driver to be reported, got %v", err)
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	cfg := Default()
+	cfg.JWT.Secret = "s3cret"
+	cfg.DB.MongoURI = "mongodb://app:hunter2@db:27017"
+	cfg.DB.PostgresDSN = "host=db user=app password=hunter2 dbname=app"
+	cfg.AdminPhoneNumbers = []string{"09121234567"}
+
+	out := cfg.String()
+	for _, secret := range []string{"s3cret", "hunter2", "09121234567"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("%q leaked into:\n%s", secret, out)
+		}
+	}
+	if !strings.Contains(out, "0912*****67") {
+		t.Errorf("admin phone number not masked in:\n%s", out)
+	}
+	if cfg.JWT.Secret != "s3cret" || cfg.AdminPhoneNumbers[0] != "09121234567" {
+		t.Error("Redacted modified the original config")
+	}
+}
@@ -0,0 +1,65 @@
+// Package device checks the signatures trusted devices log in with. A device
+// holds a private key and registers its public key, PKIX ASN.1 DER encoded,
+// when it's trusted.
+package device
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+)
+
+var (
+	ErrInvalidKey       = errors.New("public key must be a PKIX encoded ECDSA P-256 or Ed25519 key")
+	ErrInvalidSignature = errors.New("signature doesn't match the public key")
+)
+
+// ParsePublicKey checks that der is a supported public key.
+func ParsePublicKey(der []byte) error {
+	_, err := parse(der)
+	return err
+}
+
+// Verify checks that sig is a signature of message by the private key of
+// der: an ASN.1 DER ECDSA signature of the SHA-256 hash of message, or an
+// Ed25519 signature of message.
+func Verify(der, message, sig []byte) error {
+	key, err := parse(der)
+	if err != nil {
+		return err
+	}
+
+	var ok bool
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		hash := sha256.Sum256(message)
+		ok = ecdsa.VerifyASN1(key, hash[:], sig)
+	case ed25519.PublicKey:
+		ok = ed25519.Verify(key, message, sig)
+	}
+	if !ok {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func parse(der []byte) (any, error) {
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		if key.Curve != elliptic.P256() {
+			return nil, ErrInvalidKey
+		}
+	case ed25519.PublicKey:
+	default:
+		return nil, ErrInvalidKey
+	}
+	return key, nil
+}
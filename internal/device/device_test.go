@@ -0,0 +1,83 @@
+package device
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	message := []byte("challenge")
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := sha256.Sum256(message)
+	ecSig, err := ecdsa.SignASN1(rand.Reader, ecKey, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edSig := ed25519.Sign(edKey, message)
+
+	tests := []struct {
+		name string
+		pub  any
+		sig  []byte
+	}{
+		{"ECDSA", &ecKey.PublicKey, ecSig},
+		{"Ed25519", edPub, edSig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			der, err := x509.MarshalPKIXPublicKey(tt.pub)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := ParsePublicKey(der); err != nil {
+				t.Fatalf("ParsePublicKey: %v", err)
+			}
+			if err := Verify(der, message, tt.sig); err != nil {
+				t.Errorf("Verify: %v", err)
+			}
+			if err := Verify(der, []byte("other challenge"), tt.sig); !errors.Is(err, ErrInvalidSignature) {
+				t.Errorf("Verify of another message returned %v", err)
+			}
+		})
+	}
+}
+
+func TestParsePublicKeyUnsupported(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, pub := range []any{&rsaKey.PublicKey, &p384.PublicKey} {
+		der, err := x509.MarshalPKIXPublicKey(pub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ParsePublicKey(der); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("ParsePublicKey(%T) returned %v", pub, err)
+		}
+	}
+	if err := ParsePublicKey([]byte("not a key")); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("ParsePublicKey of garbage returned %v", err)
+	}
+}
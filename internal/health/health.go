@@ -0,0 +1,102 @@
+// Package health runs readiness checks against the dependencies of the
+// service.
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// HealthChecker is implemented by every component the service can't work
+// without. HealthCheck returns nil if the component is usable.
+type HealthChecker interface {
+	HealthCheck(ctx context.Context) error
+}
+
+type Status string
+
+const (
+	StatusOK          Status = "ok"
+	StatusUnavailable Status = "unavailable"
+)
+
+// Result is the outcome of checking a single dependency.
+type Result struct {
+	Status    Status  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Report is the outcome of checking every dependency. Its status is ok only
+// if every check passed.
+type Report struct {
+	Status Status            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+// Checks is a set of named dependencies to check.
+type Checks struct {
+	timeout  time.Duration
+	checkers map[string]HealthChecker
+}
+
+// NewChecks returns an empty set of checks, each of which is given at most
+// timeout to complete.
+func NewChecks(timeout time.Duration) *Checks {
+	return &Checks{
+		timeout:  timeout,
+		checkers: make(map[string]HealthChecker),
+	}
+}
+
+// Register adds a dependency under name. It must not be called concurrently
+// with Run.
+func (c *Checks) Register(name string, hc HealthChecker) {
+	c.checkers[name] = hc
+}
+
+// Run checks every dependency concurrently.
+func (c *Checks) Run(ctx context.Context) Report {
+	report := Report{Status: StatusOK, Checks: make(map[string]Result, len(c.checkers))}
+
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	for name, hc := range c.checkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			result := c.check(ctx, hc)
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[name] = result
+			if result.Status != StatusOK {
+				report.Status = StatusUnavailable
+			}
+		}()
+	}
+	wg.Wait()
+
+	return report
+}
+
+func (c *Checks) check(ctx context.Context, hc HealthChecker) Result {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := hc.HealthCheck(ctx)
+	result := Result{
+		Status:    StatusOK,
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Status = StatusUnavailable
+		result.Error = err.Error()
+	}
+	return result
+}
@@ -0,0 +1,132 @@
+// Package logging builds the slog loggers of the service and carries a
+// per-request logger, tagged with a request ID, through request contexts.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestIDHeader is read from incoming requests and set on every response.
+const RequestIDHeader = "X-Request-ID"
+
+// phoneKeys are the attribute keys whose values are masked by every logger
+// built by New.
+var phoneKeys = map[string]bool{
+	"phone":        true,
+	"phone_number": true,
+}
+
+// New returns a logger writing to w, as JSON if json is set and as
+// key=value text otherwise. Phone numbers logged under the phone or
+// phone_number keys are masked.
+func New(w io.Writer, json bool, level slog.Level) *slog.Logger {
+	opts := &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
+			if phoneKeys[a.Key] && a.Value.Kind() == slog.KindString {
+				a.Value = slog.StringValue(MaskPhone(a.Value.String()))
+			}
+			return a
+		},
+	}
+	if json {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+// MaskPhone hides all but the first four and last two digits of a phone
+// number, e.g. 09121234567 becomes 0912*****67.
+func MaskPhone(pn string) string {
+	if len(pn) <= 6 {
+		return strings.Repeat("*", len(pn))
+	}
+	return pn[:4] + strings.Repeat("*", len(pn)-6) + pn[len(pn)-2:]
+}
+
+type loggerKey struct{}
+
+// NewContext returns a copy of ctx carrying logger.
+func NewContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext returns the logger carried by ctx, or slog.Default if there is
+// none.
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// With adds attributes to the logger of the request, so that they appear on
+// every later log line of the request, including its access log.
+func With(c *gin.Context, args ...any) {
+	ctx := c.Request.Context()
+	c.Request = c.Request.WithContext(NewContext(ctx, FromContext(ctx).With(args...)))
+}
+
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// Middleware assigns every request an ID, honoring a well-formed incoming
+// X-Request-ID, echoes it in the response and attaches a logger tagged with
+// it, the method, the route and, if the request is traced, the trace ID to
+// the request context. Once the request is handled it writes an access log
+// line.
+func Middleware(base *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		requestID := c.GetHeader(RequestIDHeader)
+		if !validRequestID.MatchString(requestID) {
+			requestID = newRequestID()
+		}
+		c.Header(RequestIDHeader, requestID)
+
+		logger := base.With(
+			"request_id", requestID,
+			"method", c.Request.Method,
+			"route", c.FullPath(),
+		)
+		if sc := trace.SpanContextFromContext(c.Request.Context()); sc.IsValid() {
+			logger = logger.With("trace_id", sc.TraceID().String())
+		}
+		c.Request = c.Request.WithContext(NewContext(c.Request.Context(), logger))
+
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		}
+
+		FromContext(c.Request.Context()).LogAttrs(c.Request.Context(), level, "request handled",
+			slog.String("path", c.Request.URL.Path),
+			slog.Int("status", status),
+			slog.Duration("latency", time.Since(start)),
+			slog.Int("size", c.Writer.Size()),
+			slog.String("client_ip", c.ClientIP()),
+		)
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,73 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMaskPhone(t *testing.T) {
+	for pn, want := range map[string]string{
+		"09121234567":   "0912*****67",
+		"+989121234567": "+989*******67",
+		"12345":         "*****",
+		"":              "",
+	} {
+		if got := MaskPhone(pn); got != want {
+			t.Errorf("MaskPhone(%q) = %q, want %q", pn, got, want)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var buf bytes.Buffer
+	r := gin.New()
+	r.Use(Middleware(New(&buf, true, 0)))
+	r.GET("/users/:id", func(c *gin.Context) {
+		With(c, "user_id", "42")
+		FromContext(c.Request.Context()).Info("handled", "phone", "09121234567")
+	})
+
+	for _, tt := range []struct {
+		incoming string
+		keep     bool
+	}{
+		{"abc-123", true},
+		{"", false},
+		{"not a valid id", false},
+	} {
+		buf.Reset()
+
+		req := httptest.NewRequest(http.MethodGet, "/users/42", nil)
+		if tt.incoming != "" {
+			req.Header.Set(RequestIDHeader, tt.incoming)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		requestID := w.Header().Get(RequestIDHeader)
+		if tt.keep != (requestID == tt.incoming) || requestID == "" {
+			t.Errorf("incoming request ID %q answered with %q", tt.incoming, requestID)
+		}
+
+		dec := json.NewDecoder(&buf)
+		for _, msg := range []string{"handled", "request handled"} {
+			var line map[string]any
+			if err := dec.Decode(&line); err != nil {
+				t.Fatal(err)
+			}
+			if line["msg"] != msg || line["request_id"] != requestID || line["route"] != "/users/:id" || line["user_id"] != "42" {
+				t.Errorf("unexpected log line %v", line)
+			}
+			if phone, ok := line["phone"]; ok && phone != "0912*****67" {
+				t.Errorf("phone logged as %v", phone)
+			}
+		}
+	}
+}
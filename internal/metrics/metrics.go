@@ -0,0 +1,118 @@
+// Package metrics defines the Prometheus metrics of the service and serves
+// them from its own registry.
+package metrics
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.mongodb.org/mongo-driver/v2/event"
+)
+
+// Registry holds every metric of the service, along with the Go runtime and
+// process metrics.
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+var (
+	httpRequestDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Duration of HTTP requests by route.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	otpSent = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "otp_sent_total",
+		Help: "OTPs sent, by provider.",
+	}, []string{"provider"})
+
+	otpVerified = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "otp_verified_total",
+		Help: "OTPs verified successfully, by provider.",
+	}, []string{"provider"})
+
+	otpFailed = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "otp_failed_total",
+		Help: "OTPs that failed to be sent or verified, by provider and operation.",
+	}, []string{"provider", "operation"})
+
+	rateLimitDecisions = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "rate_limit_decisions_total",
+		Help: "Rate limiter decisions, by limiter key prefix.",
+	}, []string{"limiter", "decision"})
+
+	mongoCommandDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "mongo_command_duration_seconds",
+		Help:    "Duration of MongoDB commands.",
+		Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"command", "outcome"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func Handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.HandlerFor(Registry, promhttp.HandlerOpts{}))
+}
+
+// Middleware records the duration of every request under its route
+// template, so that path parameters don't multiply the series.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		httpRequestDuration.
+			WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).
+			Observe(time.Since(start).Seconds())
+	}
+}
+
+// RateLimitDecision counts a decision of the limiter with the given key
+// prefix.
+func RateLimitDecision(limiter string, allowed bool) {
+	decision := "denied"
+	if allowed {
+		decision = "allowed"
+	}
+	rateLimitDecisions.WithLabelValues(limiter, decision).Inc()
+}
+
+// RegisterStoreSize exposes the number of entries of a state store as
+// state_store_entries{store=name}.
+func RegisterStoreSize(name string, size func() int) error {
+	return Registry.Register(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name:        "state_store_entries",
+		Help:        "Entries held by an in-memory state store.",
+		ConstLabels: prometheus.Labels{"store": name},
+	}, func() float64 { return float64(size()) }))
+}
+
+// MongoMonitor returns a command monitor recording the duration of every
+// MongoDB command.
+func MongoMonitor() *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Succeeded: func(_ context.Context, e *event.CommandSucceededEvent) {
+			mongoCommandDuration.WithLabelValues(e.CommandName, "success").Observe(e.Duration.Seconds())
+		},
+		Failed: func(_ context.Context, e *event.CommandFailedEvent) {
+			mongoCommandDuration.WithLabelValues(e.CommandName, "failure").Observe(e.Duration.Seconds())
+		},
+	}
+}
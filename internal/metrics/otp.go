@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+
+	"github.com/epicmet/dekamond-task/internal/otp"
+)
+
+type instrumentedOTP struct {
+	otp.OTPProvider
+	name string
+}
+
+// InstrumentOTPProvider wraps p so that the codes it sends and checks are
+// counted under provider name.
+func InstrumentOTPProvider(name string, p otp.OTPProvider) otp.OTPProvider {
+	return &instrumentedOTP{OTPProvider: p, name: name}
+}
+
+// Send doesn't count requests refused by the resend cooldown.
+func (p *instrumentedOTP) Send(ctx context.Context, to otp.Recipient) (*otp.Delivery, error) {
+	delivery, err := p.OTPProvider.Send(ctx, to)
+	switch {
+	case errors.Is(err, otp.ErrResendTooSoon):
+	case err != nil:
+		otpFailed.WithLabelValues(p.name, "send").Inc()
+	default:
+		otpSent.WithLabelValues(p.name).Inc()
+	}
+	return delivery, err
+}
+
+func (p *instrumentedOTP) Check(ctx context.Context, pn string, code string) error {
+	err := p.OTPProvider.Check(ctx, pn, code)
+	if err == nil {
+		otpVerified.WithLabelValues(p.name).Inc()
+	} else {
+		otpFailed.WithLabelValues(p.name, "verify").Inc()
+	}
+	return err
+}
@@ -0,0 +1,81 @@
+package otp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+)
+
+// Challenges tracks challenges of one kind, e.g. second factor challenges:
+// a challenge is created for a subject once their first factor is verified
+// and passed with a second factor checked by the caller. Like sent codes,
+// challenges expire with the TTL of the state manager, are locked after
+// maxAttempts failed attempts and can only be passed once. Challenges of
+// other kinds can't be passed.
+type Challenges struct {
+	stateManager OTPStateManager
+	prefix       string
+	maxAttempts  int
+}
+
+func NewChallenges(sm OTPStateManager, kind string, maxAttempts int) *Challenges {
+	return &Challenges{stateManager: sm, prefix: "challenge:" + kind + ":", maxAttempts: maxAttempts}
+}
+
+// Create returns the ID of a new challenge for subject.
+func (c *Challenges) Create(ctx context.Context, subject string) (string, error) {
+	b := make([]byte, 16)
+	rand.Read(b)
+	id := hex.EncodeToString(b)
+
+	if err := c.stateManager.SetX(ctx, c.prefix+id, subject); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// Pass calls check with the subject of the challenge and returns the
+// subject if check succeeds. check returns ErrOTPMismatch or ErrOTPReused for
+// a wrong second factor, which Pass returns as a *CheckError. Like Check, it
+// returns ErrOTPNotRequested and ErrOTPExpired for unknown and expired
+// challenges.
+//
+// check runs without holding the state manager, so it may look up the
+// subject. The attempt is counted before check runs, so concurrent passes
+// can't get past maxAttempts, and only one of them consumes the challenge.
+func (c *Challenges) Pass(ctx context.Context, id string, check func(subject string) error) (string, error) {
+	key := c.prefix + id
+	var subject string
+	locked := false
+	attempts, err := c.stateManager.Update(ctx, key, func(val string, attempts int) Action {
+		if attempts >= c.maxAttempts {
+			locked = true
+			return ActionKeep
+		}
+		subject = val
+		return ActionFail
+	})
+	if err := stateError(err); err != nil {
+		return "", err
+	}
+	if locked {
+		return "", &CheckError{Err: ErrOTPLocked}
+	}
+
+	if err := check(subject); err != nil {
+		if !errors.Is(err, ErrOTPMismatch) && !errors.Is(err, ErrOTPReused) {
+			return "", err
+		}
+		if attempts >= c.maxAttempts {
+			return "", &CheckError{Err: ErrOTPLocked}
+		}
+		return "", &CheckError{Err: err, RemainingAttempts: c.maxAttempts - attempts}
+	}
+
+	_, err = c.stateManager.Update(ctx, key, func(string, int) Action { return ActionDelete })
+	if err := stateError(err); err != nil {
+		return "", err
+	}
+	return subject, nil
+}
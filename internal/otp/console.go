@@ -1,6 +1,7 @@
 package otp
 
 import (
+	"context"
 	"fmt"
 	"io"
 )
@@ -8,36 +9,35 @@ import (
 type ConsoleOTP struct {
 	base   *BaseOTPProvider
 	output io.Writer
-	len    int
 }
 
-func NewConsoleOTP(sm OTPStateManager, output io.Writer, otpLen int) *ConsoleOTP {
+// NewConsoleOTP returns a provider writing codes to output instead of
+// sending them.
+func NewConsoleOTP(sm OTPStateManager, output io.Writer, opts Options) *ConsoleOTP {
 	return &ConsoleOTP{
-		base:   &BaseOTPProvider{stateManager: sm},
-		len:    otpLen,
+		base:   &BaseOTPProvider{stateManager: sm, opts: opts},
 		output: output,
 	}
 }
 
-func (c *ConsoleOTP) Send(pn string) error {
-	otp := c.base.createRandomInt(c.len)
-	err := c.base.stateManager.SetX(pn, otp)
-
+func (c *ConsoleOTP) Send(ctx context.Context, to Recipient) (*Delivery, error) {
+	otp, msg, delivery, err := c.base.store(ctx, to, "console")
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	line := fmt.Sprintf("Sending OTP :: { PhoneNumber = %s, OTP = %s }\n", pn, otp)
-	_, err = c.output.Write([]byte(line))
+	line := fmt.Sprintf("Sending OTP :: { PhoneNumber = %s, OTP = %s, Locale = %s }\n%s\n", to.Phone, otp, delivery.Locale, msg)
+	if _, err := c.output.Write([]byte(line)); err != nil {
+		return nil, err
+	}
 
-	return err
+	return delivery, nil
 }
 
-func (c *ConsoleOTP) Check(pn string, otp string) bool {
-	storedOtp, err := c.base.stateManager.Get(pn)
-	if err != nil || storedOtp != otp {
-		return false
-	}
+func (c *ConsoleOTP) HealthCheck(ctx context.Context) error {
+	return c.base.stateManager.HealthCheck(ctx)
+}
 
-	return true
+func (c *ConsoleOTP) Check(ctx context.Context, pn string, otp string) error {
+	return c.base.check(ctx, pn, otp)
 }
@@ -0,0 +1,201 @@
+package otp
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// linkKind separates login links from email verification links: each kind
+// has its own keys in the state manager and its own token audience, so a
+// link of one kind can't be used as the other.
+type linkKind struct {
+	prefix   string
+	audience string
+}
+
+var (
+	loginLink  = linkKind{prefix: "link:", audience: "magic-link"}
+	verifyLink = linkKind{prefix: "verify:", audience: "email-verification"}
+)
+
+// linkClaims are the claims of a link token. Email is only set for email
+// verification links.
+type linkClaims struct {
+	jwt.RegisteredClaims
+	Email string `json:"email,omitempty"`
+}
+
+// ErrLinkInvalid is returned for a link token that is malformed or wasn't
+// signed with the key.
+var ErrLinkInvalid = errors.New("magic link is invalid")
+
+// Mailer delivers emails.
+type Mailer interface {
+	Send(ctx context.Context, to, subject, body string) error
+}
+
+// ConsoleMailer writes emails to output instead of sending them.
+type ConsoleMailer struct {
+	output io.Writer
+}
+
+func NewConsoleMailer(output io.Writer) *ConsoleMailer {
+	return &ConsoleMailer{output: output}
+}
+
+func (m *ConsoleMailer) Send(ctx context.Context, to, subject, body string) error {
+	_, err := fmt.Fprintf(m.output, "Sending email :: { To = %s, Subject = %s }\n%s\n", to, subject, body)
+	return err
+}
+
+// MagicLinkOptions configures the login links.
+type MagicLinkOptions struct {
+	// URL is where login links point to, with the token added as the token
+	// query parameter.
+	URL string
+	// VerifyURL is where email verification links point to, likewise.
+	VerifyURL string
+	// Key signs the tokens.
+	Key     []byte
+	AppName string
+	// MaxAttempts and ResendCooldown work as for codes.
+	MaxAttempts    int
+	ResendCooldown time.Duration
+}
+
+// MagicLinks emails single-use login links as an alternative to codes. A
+// link carries a signed token naming the ID of the user it logs in, which
+// unlike the phone number is fine in access logs; the ID of the token is
+// kept in the OTP state store until the link is used, so a link expires
+// with the TTL of the store and the last one sent replaces the previous
+// ones. Links confirming an email address work the same way.
+type MagicLinks struct {
+	stateManager OTPStateManager
+	mailer       Mailer
+	opts         MagicLinkOptions
+}
+
+func NewMagicLinks(sm OTPStateManager, mailer Mailer, opts MagicLinkOptions) *MagicLinks {
+	return &MagicLinks{stateManager: sm, mailer: mailer, opts: opts}
+}
+
+// Send emails a link logging in the user with the given ID to email, or
+// returns a *CooldownError if the previous link was sent too recently.
+func (m *MagicLinks) Send(ctx context.Context, userID, email string) (*Delivery, error) {
+	return m.send(ctx, loginLink, m.opts.URL, linkClaims{RegisteredClaims: jwt.RegisteredClaims{Subject: userID}}, email,
+		func(link string, minutes int) (string, string) {
+			return "Your " + m.opts.AppName + " login link", fmt.Sprintf(
+				"Open this link to log in to %s:\n\n%s\n\nIt expires in %d minutes and works once. If you didn't ask for it, ignore this email.",
+				m.opts.AppName, link, minutes)
+		})
+}
+
+// SendVerification emails a link confirming that email belongs to the user
+// with the given ID, or returns a *CooldownError if the previous link was
+// sent too recently.
+func (m *MagicLinks) SendVerification(ctx context.Context, userID, email string) (*Delivery, error) {
+	return m.send(ctx, verifyLink, m.opts.VerifyURL, linkClaims{RegisteredClaims: jwt.RegisteredClaims{Subject: userID}, Email: email}, email,
+		func(link string, minutes int) (string, string) {
+			return "Confirm your " + m.opts.AppName + " email address", fmt.Sprintf(
+				"Open this link to confirm your email address on %s:\n\n%s\n\nIt expires in %d minutes and works once. If you didn't ask for it, ignore this email.",
+				m.opts.AppName, link, minutes)
+		})
+}
+
+// Check verifies the token of a login link and returns the ID of the user it
+// logs in, consuming the link. Besides ErrLinkInvalid it returns the errors
+// of OTPProvider.Check: a link that was replaced by a newer one doesn't
+// match.
+func (m *MagicLinks) Check(ctx context.Context, token string) (string, error) {
+	claims, err := m.check(ctx, loginLink, token)
+	if err != nil {
+		return "", err
+	}
+	return claims.Subject, nil
+}
+
+// CheckVerification verifies the token of an email verification link and
+// returns the ID of the user and the address it confirms, consuming the
+// link. It returns the same errors as Check.
+func (m *MagicLinks) CheckVerification(ctx context.Context, token string) (userID, email string, err error) {
+	claims, err := m.check(ctx, verifyLink, token)
+	if err != nil {
+		return "", "", err
+	}
+	return claims.Subject, claims.Email, nil
+}
+
+// send stores a new token ID for the subject of claims and emails a link of
+// the given kind, pointing to target, to email. message writes the email
+// given the link and the minutes until it expires.
+func (m *MagicLinks) send(ctx context.Context, kind linkKind, target string, claims linkClaims, email string, message func(link string, minutes int) (string, string)) (*Delivery, error) {
+	b := make([]byte, 16)
+	rand.Read(b)
+	id := hex.EncodeToString(b)
+	expiry, wait, err := m.stateManager.SetXCooldown(ctx, kind.prefix+claims.Subject, id, m.opts.ResendCooldown)
+	if err != nil {
+		return nil, err
+	}
+	if wait > 0 {
+		return nil, &CooldownError{RetryAfter: wait}
+	}
+
+	claims.Audience = jwt.ClaimStrings{kind.audience}
+	claims.ID = id
+	claims.ExpiresAt = jwt.NewNumericDate(expiry)
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(m.opts.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	link, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	expiresIn := time.Until(expiry)
+	subject, body := message(link.String(), int(math.Ceil(expiresIn.Minutes())))
+	if err := m.mailer.Send(ctx, email, subject, body); err != nil {
+		return nil, err
+	}
+
+	return &Delivery{Channel: "email", ExpiresIn: expiresIn, ResendAfter: m.opts.ResendCooldown}, nil
+}
+
+// check verifies a token of a link of the given kind and consumes the link.
+func (m *MagicLinks) check(ctx context.Context, kind linkKind, token string) (*linkClaims, error) {
+	var claims linkClaims
+	_, err := jwt.ParseWithClaims(token, &claims, func(*jwt.Token) (any, error) {
+		return m.opts.Key, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithAudience(kind.audience), jwt.WithExpirationRequired())
+	switch {
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return nil, ErrOTPExpired
+	case err != nil:
+		return nil, fmt.Errorf("%w: %w", ErrLinkInvalid, err)
+	}
+
+	_, err = verify(ctx, m.stateManager, kind.prefix+claims.Subject, m.opts.MaxAttempts, func(stored string) error {
+		if subtle.ConstantTimeCompare([]byte(stored), []byte(claims.ID)) != 1 {
+			return ErrOTPMismatch
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
@@ -0,0 +1,49 @@
+package otp
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+)
+
+var linkToken = regexp.MustCompile(`\?token=(\S+)`)
+
+func TestMagicLinksKinds(t *testing.T) {
+	sm := NewMemStateManager(time.Minute)
+	defer sm.Close()
+	var out bytes.Buffer
+	m := NewMagicLinks(sm, NewConsoleMailer(&out), MagicLinkOptions{
+		URL:         "https://example.com/magic-link",
+		VerifyURL:   "https://example.com/email/verify",
+		Key:         []byte("key"),
+		AppName:     "Dekamond",
+		MaxAttempts: 5,
+	})
+
+	if _, err := m.Send(t.Context(), "user-id", "user@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	login := linkToken.FindStringSubmatch(out.String())[1]
+	out.Reset()
+	if _, err := m.SendVerification(t.Context(), "user-id", "user@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	verify := linkToken.FindStringSubmatch(out.String())[1]
+
+	if _, _, err := m.CheckVerification(t.Context(), login); !errors.Is(err, ErrLinkInvalid) {
+		t.Errorf("CheckVerification of a login link = %v, want ErrLinkInvalid", err)
+	}
+	if _, err := m.Check(t.Context(), verify); !errors.Is(err, ErrLinkInvalid) {
+		t.Errorf("Check of a verification link = %v, want ErrLinkInvalid", err)
+	}
+
+	userID, email, err := m.CheckVerification(t.Context(), verify)
+	if err != nil || userID != "user-id" || email != "user@example.com" {
+		t.Errorf("CheckVerification = %q, %q, %v", userID, email, err)
+	}
+	if userID, err := m.Check(t.Context(), login); err != nil || userID != "user-id" {
+		t.Errorf("Check = %q, %v", userID, err)
+	}
+}
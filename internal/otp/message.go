@@ -0,0 +1,158 @@
+package otp
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+
+	"golang.org/x/text/language"
+)
+
+//go:embed templates/*.tmpl
+var builtinTemplates embed.FS
+
+// Format is the layout a message is wrapped in so that the recipient's
+// platform can autofill the code.
+type Format string
+
+const (
+	FormatPlain Format = ""
+	// FormatAndroid starts the message with <#> and ends it with the app
+	// hash, as the SMS Retriever API expects.
+	FormatAndroid Format = "android"
+	// FormatIOS ends the message with an "@domain #code" line, binding the
+	// code to the domain for one-time-code autofill.
+	FormatIOS Format = "ios"
+)
+
+// ParseFormat returns the format named s, which is "", "android" or "ios".
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case FormatPlain, FormatAndroid, FormatIOS:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown message format %q", s)
+	}
+}
+
+// MessageData is what a message template is executed with.
+type MessageData struct {
+	Code          string
+	AppName       string
+	ExpiryMinutes int
+}
+
+// MessageOptions configures the messages a code is sent in.
+type MessageOptions struct {
+	AppName string
+	// AppHash is the 11 character hash of the Android app, appended in
+	// FormatAndroid. Without it FormatAndroid falls back to FormatPlain.
+	AppHash string
+	// Domain is bound to the code in FormatIOS. Without it FormatIOS falls
+	// back to FormatPlain.
+	Domain string
+	// DefaultLocale is used when none of the requested languages has a
+	// template.
+	DefaultLocale string
+	// TemplatesDir, if set, holds <locale>.tmpl files that replace or add to
+	// the built-in templates.
+	TemplatesDir string
+}
+
+// Messages renders codes into localized messages.
+type Messages struct {
+	opts      MessageOptions
+	tags      []language.Tag
+	templates []*template.Template
+	matcher   language.Matcher
+}
+
+// NewMessages loads the built-in English and Persian templates and those in
+// opts.TemplatesDir.
+func NewMessages(opts MessageOptions) (*Messages, error) {
+	byLocale := make(map[string]*template.Template)
+	if err := loadTemplates(byLocale, builtinTemplates, "templates"); err != nil {
+		return nil, err
+	}
+	if opts.TemplatesDir != "" {
+		if err := loadTemplates(byLocale, os.DirFS(opts.TemplatesDir), "."); err != nil {
+			return nil, err
+		}
+	}
+
+	def, err := language.Parse(opts.DefaultLocale)
+	if err != nil {
+		return nil, fmt.Errorf("default locale: %w", err)
+	}
+	if byLocale[def.String()] == nil {
+		return nil, fmt.Errorf("no template for the default locale %q", def)
+	}
+
+	// The matcher falls back to the first tag, so the default goes first.
+	m := &Messages{opts: opts}
+	m.tags = append(m.tags, def)
+	m.templates = append(m.templates, byLocale[def.String()])
+	for locale, tmpl := range byLocale {
+		if locale != def.String() {
+			m.tags = append(m.tags, language.MustParse(locale))
+			m.templates = append(m.templates, tmpl)
+		}
+	}
+	m.matcher = language.NewMatcher(m.tags)
+	return m, nil
+}
+
+func loadTemplates(dst map[string]*template.Template, fsys fs.FS, dir string) error {
+	files, err := fs.Glob(fsys, path.Join(dir, "*.tmpl"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		name := strings.TrimSuffix(path.Base(file), ".tmpl")
+		tag, err := language.Parse(name)
+		if err != nil {
+			return fmt.Errorf("template %s: %w", file, err)
+		}
+		text, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return err
+		}
+		tmpl, err := template.New(name).Option("missingkey=error").Parse(string(text))
+		if err != nil {
+			return err
+		}
+		dst[tag.String()] = tmpl
+	}
+	return nil
+}
+
+// Render returns the message for code, expiring in expiresIn, in the best
+// match for the preferred languages, and the locale it was written in.
+func (m *Messages) Render(code string, expiresIn time.Duration, preferred []language.Tag, format Format) (string, string, error) {
+	_, i, _ := m.matcher.Match(preferred...)
+
+	var b strings.Builder
+	err := m.templates[i].Execute(&b, MessageData{
+		Code:          code,
+		AppName:       m.opts.AppName,
+		ExpiryMinutes: int(math.Ceil(expiresIn.Minutes())),
+	})
+	if err != nil {
+		return "", "", err
+	}
+	msg := strings.TrimSpace(b.String())
+
+	switch {
+	case format == FormatAndroid && m.opts.AppHash != "":
+		msg = "<#> " + msg + "\n" + m.opts.AppHash
+	case format == FormatIOS && m.opts.Domain != "":
+		msg = msg + "\n\n@" + m.opts.Domain + " #" + code
+	}
+	return msg, m.tags[i].String(), nil
+}
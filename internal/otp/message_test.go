@@ -0,0 +1,71 @@
+package otp
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/text/language"
+)
+
+func testMessages(t *testing.T) *Messages {
+	t.Helper()
+
+	m, err := NewMessages(MessageOptions{AppName: "Dekamond", DefaultLocale: "fa", AppHash: "FA+9qCX9VSu", Domain: "example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestMessagesRender(t *testing.T) {
+	m := testMessages(t)
+	accept, _, _ := language.ParseAcceptLanguage("de-DE, en-US;q=0.8")
+
+	tests := []struct {
+		name       string
+		languages  []language.Tag
+		format     Format
+		wantLocale string
+		wantPrefix string
+		wantSuffix string
+	}{
+		{"default", nil, FormatPlain, "fa", "کد تأیید Dekamond", "دقیقه معتبر است. آن را در اختیار کسی قرار ندهید."},
+		{"accept-language", accept, FormatPlain, "en", "Your Dekamond verification code is 123456. It expires in 2 minutes.", "anyone."},
+		{"android", accept, FormatAndroid, "en", "<#> Your Dekamond", "anyone.\nFA+9qCX9VSu"},
+		{"ios", accept, FormatIOS, "en", "Your Dekamond", "anyone.\n\n@example.com #123456"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, locale, err := m.Render("123456", 2*time.Minute, tt.languages, tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if locale != tt.wantLocale || !strings.HasPrefix(msg, tt.wantPrefix) || !strings.HasSuffix(msg, tt.wantSuffix) {
+				t.Errorf("Render = %q in %s", msg, locale)
+			}
+		})
+	}
+}
+
+func TestMessagesTemplatesDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "de.tmpl"), []byte("{{.AppName}}-Code: {{.Code}}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewMessages(MessageOptions{AppName: "Dekamond", DefaultLocale: "en", TemplatesDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, locale, err := m.Render("123456", time.Minute, []language.Tag{language.German}, FormatAndroid)
+	if err != nil || msg != "Dekamond-Code: 123456" || locale != "de" {
+		t.Errorf("Render = %q in %s, %v; want the German template without an app hash", msg, locale, err)
+	}
+
+	if _, err := NewMessages(MessageOptions{DefaultLocale: "it"}); err == nil {
+		t.Error("NewMessages accepted a default locale without a template")
+	}
+}
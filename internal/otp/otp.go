@@ -1,18 +1,113 @@
 package otp
 
 import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
 	"math/rand/v2"
 	"strconv"
 	"strings"
+	"time"
+
+	"golang.org/x/text/language"
 )
 
+// codePrefix keeps codes apart from the other keys of the state manager,
+// e.g. magic links and challenges.
+const codePrefix = "otp:"
+
+var (
+	ErrOTPNotRequested = errors.New("no otp was requested for this phone number")
+	ErrOTPExpired      = errors.New("otp has expired")
+	ErrOTPMismatch     = errors.New("otp does not match")
+	ErrOTPLocked       = errors.New("otp is locked after too many failed attempts")
+	// ErrOTPReused is returned for a time-based code that was already used.
+	ErrOTPReused     = errors.New("otp has already been used")
+	ErrResendTooSoon = errors.New("otp was sent too recently")
+)
+
+// CooldownError is returned by Send when the last code was sent less than
+// the resend cooldown ago. It matches ErrResendTooSoon with errors.Is.
+type CooldownError struct {
+	RetryAfter time.Duration
+}
+
+func (e *CooldownError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", ErrResendTooSoon, e.RetryAfter)
+}
+
+func (e *CooldownError) Is(target error) bool {
+	return target == ErrResendTooSoon
+}
+
+// Recipient is who a code is sent to and how they'd like to read it.
+type Recipient struct {
+	Phone string
+	// Languages are the preferred languages of the message, most preferred
+	// first.
+	Languages []language.Tag
+	Format    Format
+}
+
+// Delivery describes a code that has been sent.
+type Delivery struct {
+	// Channel is how the code was delivered, e.g. "console".
+	Channel    string
+	CodeLength int
+	// Locale is the language the message was written in.
+	Locale string
+	// ExpiresIn is how long the code stays valid.
+	ExpiresIn time.Duration
+	// ResendAfter is how long to wait before another code may be sent.
+	ResendAfter time.Duration
+}
+
+// Options configures a provider.
+type Options struct {
+	// Length is the number of digits in a code.
+	Length int
+	// MaxAttempts is the number of wrong guesses after which a code is
+	// locked.
+	MaxAttempts int
+	// ResendCooldown is the minimum time between two codes sent to the
+	// same phone number.
+	ResendCooldown time.Duration
+	// Messages renders the code into the message sent.
+	Messages *Messages
+}
+
+// CheckError is returned for a wrong, reused or locked code. It matches
+// ErrOTPMismatch, ErrOTPReused or ErrOTPLocked with errors.Is.
+type CheckError struct {
+	Err               error
+	RemainingAttempts int
+}
+
+func (e *CheckError) Error() string {
+	return fmt.Sprintf("%s, %d attempts remaining", e.Err, e.RemainingAttempts)
+}
+
+func (e *CheckError) Unwrap() error {
+	return e.Err
+}
+
 type OTPProvider interface {
-	Send(pn string) error
-	Check(pn string, otp string) bool
+	// Send delivers a new code to the recipient, replacing the previous
+	// one, or returns a *CooldownError if the previous one was sent too
+	// recently.
+	Send(ctx context.Context, to Recipient) (*Delivery, error)
+	// Check returns nil if otp is the code last sent to pn, consuming it.
+	// Otherwise it returns ErrOTPNotRequested, ErrOTPExpired or a
+	// *CheckError.
+	Check(ctx context.Context, pn string, otp string) error
+	// HealthCheck reports whether codes can currently be sent and checked.
+	HealthCheck(ctx context.Context) error
 }
 
 type BaseOTPProvider struct {
 	stateManager OTPStateManager
+	opts         Options
 }
 
 // TODO: No repeat int?
@@ -26,3 +121,96 @@ func (b *BaseOTPProvider) createRandomInt(len int) string {
 
 	return strings.Join(res[:], "")
 }
+
+// check compares otp to the code stored for pn. A code guessed wrong
+// maxAttempts times is locked until it expires or a new one is sent.
+func (b *BaseOTPProvider) check(ctx context.Context, pn string, otp string) error {
+	_, err := verify(ctx, b.stateManager, codePrefix+pn, b.opts.MaxAttempts, func(stored string) error {
+		if subtle.ConstantTimeCompare([]byte(stored), []byte(otp)) != 1 {
+			return ErrOTPMismatch
+		}
+		return nil
+	})
+	return err
+}
+
+// stateError maps the state manager's errors for a missing or expired key to
+// those of a code that wasn't sent or has expired.
+func stateError(err error) error {
+	switch {
+	case errors.Is(err, ErrKeyNotFound):
+		return ErrOTPNotRequested
+	case errors.Is(err, ErrKeyExpired):
+		return ErrOTPExpired
+	}
+	return err
+}
+
+// verify passes the value stored under key to match and deletes it once
+// matched. match returning ErrOTPMismatch or ErrOTPReused counts as a failed
+// attempt, and key is locked after maxAttempts of them. Concurrent calls for
+// the same key are serialized, so a value is only matched once and the
+// attempt limit holds.
+func verify(ctx context.Context, sm OTPStateManager, key string, maxAttempts int, match func(stored string) error) (string, error) {
+	var stored string
+	var matchErr error
+	attempts, err := sm.Update(ctx, key, func(val string, attempts int) Action {
+		if attempts >= maxAttempts {
+			matchErr = ErrOTPLocked
+			return ActionKeep
+		}
+		matchErr = match(val)
+		switch {
+		case matchErr == nil:
+			stored = val
+			return ActionDelete
+		case errors.Is(matchErr, ErrOTPMismatch), errors.Is(matchErr, ErrOTPReused):
+			return ActionFail
+		}
+		return ActionKeep
+	})
+	if err := stateError(err); err != nil {
+		return "", err
+	}
+
+	switch {
+	case matchErr == nil:
+		return stored, nil
+	case errors.Is(matchErr, ErrOTPLocked):
+		return "", &CheckError{Err: ErrOTPLocked}
+	case !errors.Is(matchErr, ErrOTPMismatch) && !errors.Is(matchErr, ErrOTPReused):
+		return "", matchErr
+	}
+	if attempts >= maxAttempts {
+		return "", &CheckError{Err: ErrOTPLocked}
+	}
+	return "", &CheckError{Err: matchErr, RemainingAttempts: maxAttempts - attempts}
+}
+
+// store creates a code for the recipient, stores it and returns it with the
+// message carrying it, unless the previous code was sent less than the resend
+// cooldown ago.
+func (b *BaseOTPProvider) store(ctx context.Context, to Recipient, channel string) (string, string, *Delivery, error) {
+	otp := b.createRandomInt(b.opts.Length)
+	expiry, wait, err := b.stateManager.SetXCooldown(ctx, codePrefix+to.Phone, otp, b.opts.ResendCooldown)
+	if err != nil {
+		return "", "", nil, err
+	}
+	if wait > 0 {
+		return "", "", nil, &CooldownError{RetryAfter: wait}
+	}
+
+	expiresIn := time.Until(expiry)
+	msg, locale, err := b.opts.Messages.Render(otp, expiresIn, to.Languages, to.Format)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	return otp, msg, &Delivery{
+		Channel:     channel,
+		CodeLength:  b.opts.Length,
+		Locale:      locale,
+		ExpiresIn:   expiresIn,
+		ResendAfter: b.opts.ResendCooldown,
+	}, nil
+}
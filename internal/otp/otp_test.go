@@ -0,0 +1,224 @@
+package otp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestConsoleOTPCheck(t *testing.T) {
+	sm := NewMemStateManager(time.Minute)
+	defer sm.Close()
+	var out bytes.Buffer
+	p := NewConsoleOTP(sm, &out, Options{Messages: testMessages(t), Length: 6, MaxAttempts: 2})
+	ctx := t.Context()
+	const pn = "09120000000"
+
+	if err := p.Check(ctx, pn, "000000"); !errors.Is(err, ErrOTPNotRequested) {
+		t.Errorf("Check before Send = %v, want ErrOTPNotRequested", err)
+	}
+
+	if _, err := p.Send(ctx, Recipient{Phone: pn}); err != nil {
+		t.Fatal(err)
+	}
+	code := regexp.MustCompile(`OTP = (\d+)`).FindStringSubmatch(out.String())[1]
+
+	var checkErr *CheckError
+	err := p.Check(ctx, pn, "wrong")
+	if !errors.Is(err, ErrOTPMismatch) || !errors.As(err, &checkErr) || checkErr.RemainingAttempts != 1 {
+		t.Errorf("first wrong Check = %v, want a mismatch with 1 attempt remaining", err)
+	}
+	if err := p.Check(ctx, pn, "wrong"); !errors.Is(err, ErrOTPLocked) {
+		t.Errorf("second wrong Check = %v, want ErrOTPLocked", err)
+	}
+	if err := p.Check(ctx, pn, code); !errors.Is(err, ErrOTPLocked) {
+		t.Errorf("Check of the right code once locked = %v, want ErrOTPLocked", err)
+	}
+
+	out.Reset()
+	if _, err := p.Send(ctx, Recipient{Phone: pn}); err != nil {
+		t.Fatal(err)
+	}
+	code = regexp.MustCompile(`OTP = (\d+)`).FindStringSubmatch(out.String())[1]
+	if err := p.Check(ctx, pn, code); err != nil {
+		t.Errorf("Check of a new code = %v", err)
+	}
+	if err := p.Check(ctx, pn, code); !errors.Is(err, ErrOTPNotRequested) {
+		t.Errorf("Check of a used code = %v, want ErrOTPNotRequested", err)
+	}
+}
+
+func TestConsoleOTPSendConcurrent(t *testing.T) {
+	sm := NewMemStateManager(time.Minute)
+	defer sm.Close()
+	p := NewConsoleOTP(sm, io.Discard, Options{Messages: testMessages(t), Length: 6, MaxAttempts: 5, ResendCooldown: time.Minute})
+
+	var wg sync.WaitGroup
+	var sent, cooldown atomic.Int32
+	start := make(chan struct{})
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			_, err := p.Send(t.Context(), Recipient{Phone: "09120000000"})
+			var cooldownErr *CooldownError
+			switch {
+			case err == nil:
+				sent.Add(1)
+			case errors.As(err, &cooldownErr):
+				cooldown.Add(1)
+			default:
+				t.Error(err)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	if sent.Load() != 1 || cooldown.Load() != 19 {
+		t.Errorf("%d concurrent sends went out and %d hit the cooldown, want 1 and 19", sent.Load(), cooldown.Load())
+	}
+}
+
+// TestChallengesPassConcurrent checks with a slow second factor, so that
+// concurrent guesses overlap.
+func TestChallengesPassConcurrent(t *testing.T) {
+	sm := NewMemStateManager(time.Minute)
+	defer sm.Close()
+	c := NewChallenges(sm, "test", 3)
+
+	guess := func(id string, err error) (passed, checked int32) {
+		var wg sync.WaitGroup
+		var p, n atomic.Int32
+		for range 20 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, passErr := c.Pass(t.Context(), id, func(string) error {
+					n.Add(1)
+					time.Sleep(time.Millisecond)
+					return err
+				})
+				if passErr == nil {
+					p.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+		return p.Load(), n.Load()
+	}
+
+	id, err := c.Create(t.Context(), "subject")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if passed, _ := guess(id, nil); passed != 1 {
+		t.Errorf("%d concurrent right guesses passed, want 1", passed)
+	}
+
+	id, err = c.Create(t.Context(), "subject")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, checked := guess(id, ErrOTPMismatch); checked != 3 {
+		t.Errorf("%d concurrent wrong guesses were checked, want 3", checked)
+	}
+	if _, err := c.Pass(t.Context(), id, func(string) error { return nil }); !errors.Is(err, ErrOTPLocked) {
+		t.Errorf("Pass once locked = %v, want ErrOTPLocked", err)
+	}
+}
+
+func TestChallengesPassUnlocked(t *testing.T) {
+	sm := NewMemStateManager(time.Minute)
+	defer sm.Close()
+	c := NewChallenges(sm, "test", 3)
+	id, err := c.Create(t.Context(), "subject")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Checks that look up state of their own don't hold up other keys.
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.Pass(t.Context(), id, func(string) error {
+			_, err := sm.Get(t.Context(), "other")
+			if !errors.Is(err, ErrKeyNotFound) {
+				return err
+			}
+			return nil
+		})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Pass = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Pass held the state manager while checking")
+	}
+}
+
+func TestConsoleOTPKeepsToItsKeys(t *testing.T) {
+	sm := NewMemStateManager(time.Minute)
+	defer sm.Close()
+	p := NewConsoleOTP(sm, &bytes.Buffer{}, Options{Messages: testMessages(t), Length: 6, MaxAttempts: 5})
+	c := NewChallenges(sm, "test", 5)
+
+	id, err := c.Create(t.Context(), "subject")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A phone number spelling out the key of the challenge mustn't replace it.
+	if _, err := p.Send(t.Context(), Recipient{Phone: "challenge:test:" + id}); err != nil {
+		t.Fatal(err)
+	}
+	subject, err := c.Pass(t.Context(), id, func(string) error { return nil })
+	if err != nil || subject != "subject" {
+		t.Errorf("Pass = %q, %v, want the subject of the challenge", subject, err)
+	}
+}
+
+func TestConsoleOTPCheckExpired(t *testing.T) {
+	sm := NewMemStateManager(time.Millisecond)
+	defer sm.Close()
+	p := NewConsoleOTP(sm, &bytes.Buffer{}, Options{Messages: testMessages(t), Length: 6, MaxAttempts: 5})
+
+	if _, err := p.Send(t.Context(), Recipient{Phone: "09120000000"}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	if err := p.Check(t.Context(), "09120000000", "000000"); !errors.Is(err, ErrOTPExpired) {
+		t.Errorf("Check of an expired code = %v, want ErrOTPExpired", err)
+	}
+}
+
+func TestConsoleOTPResendCooldown(t *testing.T) {
+	sm := NewMemStateManager(time.Minute)
+	defer sm.Close()
+	p := NewConsoleOTP(sm, &bytes.Buffer{}, Options{Messages: testMessages(t), Length: 6, MaxAttempts: 5, ResendCooldown: 30 * time.Second})
+
+	delivery, err := p.Send(t.Context(), Recipient{Phone: "09120000000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delivery.Channel != "console" || delivery.CodeLength != 6 || delivery.ResendAfter != 30*time.Second ||
+		delivery.ExpiresIn <= 59*time.Second || delivery.ExpiresIn > time.Minute {
+		t.Errorf("Send returned %+v", delivery)
+	}
+
+	var cooldownErr *CooldownError
+	_, err = p.Send(t.Context(), Recipient{Phone: "09120000000"})
+	if !errors.Is(err, ErrResendTooSoon) || !errors.As(err, &cooldownErr) || cooldownErr.RetryAfter <= 29*time.Second {
+		t.Errorf("second Send = %v, want a cooldown of about 30s", err)
+	}
+	if _, err := p.Send(t.Context(), Recipient{Phone: "09120000001"}); err != nil {
+		t.Errorf("Send to another phone number = %v", err)
+	}
+}
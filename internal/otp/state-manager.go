@@ -1,31 +1,73 @@
 package otp
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"sync"
 	"time"
 )
 
+var (
+	ErrKeyNotFound = errors.New("key not found")
+	ErrKeyExpired  = errors.New("key expired")
+)
+
 type OTPStateManager interface {
-	SetX(key string, val string) error
-	Get(key string) (string, error)
+	// SetX stores val under key until the TTL passes, resetting its failed
+	// attempts.
+	SetX(ctx context.Context, key string, val string) error
+	// SetXCooldown is SetX unless key was set less than cooldown ago, even
+	// if it has expired since: then it leaves key as it is and returns how
+	// long until it can be set. Otherwise it returns when val expires.
+	// Concurrent calls for the same key set it once per cooldown.
+	SetXCooldown(ctx context.Context, key string, val string, cooldown time.Duration) (expiry time.Time, wait time.Duration, err error)
+	// Get returns the value of key, ErrKeyNotFound or ErrKeyExpired.
+	Get(ctx context.Context, key string) (string, error)
+	Del(ctx context.Context, key string) error
+	// Update calls fn with the value of key and the failed attempts
+	// recorded against it and applies the Action fn returns, all without
+	// another call seeing key in between. It returns the failed attempts
+	// after the action, or ErrKeyNotFound or ErrKeyExpired. fn must not
+	// call the state manager.
+	Update(ctx context.Context, key string, fn func(val string, attempts int) Action) (int, error)
+	HealthCheck(ctx context.Context) error
 }
 
+// Action is what Update does with a key once it has been looked at.
+type Action int
+
+const (
+	// ActionKeep leaves the key as it is.
+	ActionKeep Action = iota
+	// ActionDelete removes the key.
+	ActionDelete
+	// ActionFail records a failed attempt against the key.
+	ActionFail
+)
+
 type otpEntry struct {
-	value  string
-	expiry time.Time
+	value    string
+	sentAt   time.Time
+	expiry   time.Time
+	attempts int
 }
 
 type MemStateManager struct {
 	TTL   time.Duration
 	store map[string]otpEntry
 	mu    sync.RWMutex
+
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
+// NewMemStateManager starts a goroutine that evicts expired entries until
+// Close is called.
 func NewMemStateManager(ttl time.Duration) *MemStateManager {
 	sm := &MemStateManager{
 		TTL:   ttl,
 		store: make(map[string]otpEntry),
+		done:  make(chan struct{}),
 	}
 
 	go sm.cleanup()
@@ -33,39 +75,124 @@ func NewMemStateManager(ttl time.Duration) *MemStateManager {
 	return sm
 }
 
-func (ms *MemStateManager) SetX(key string, val string) error {
+func (ms *MemStateManager) SetX(_ context.Context, key string, val string) error {
 	ms.mu.Lock()
 	defer ms.mu.Unlock()
 
+	now := time.Now()
 	ms.store[key] = otpEntry{
 		value:  val,
-		expiry: time.Now().Add(ms.TTL),
+		sentAt: now,
+		expiry: now.Add(ms.TTL),
 	}
 	return nil
 }
 
-func (ms *MemStateManager) Get(key string) (string, error) {
+func (ms *MemStateManager) SetXCooldown(_ context.Context, key string, val string, cooldown time.Duration) (time.Time, time.Duration, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	now := time.Now()
+	if entry, exists := ms.store[key]; exists {
+		if wait := cooldown - now.Sub(entry.sentAt); wait > 0 {
+			return time.Time{}, wait, nil
+		}
+	}
+	entry := otpEntry{
+		value:  val,
+		sentAt: now,
+		expiry: now.Add(ms.TTL),
+	}
+	ms.store[key] = entry
+	return entry.expiry, 0, nil
+}
+
+// Get reports expired entries as ErrKeyExpired until they are evicted.
+func (ms *MemStateManager) Get(_ context.Context, key string) (string, error) {
 	ms.mu.RLock()
 	defer ms.mu.RUnlock()
 
+	entry, err := ms.entry(key)
+	if err != nil {
+		return "", err
+	}
+	return entry.value, nil
+}
+
+func (ms *MemStateManager) Del(_ context.Context, key string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	delete(ms.store, key)
+	return nil
+}
+
+func (ms *MemStateManager) Update(_ context.Context, key string, fn func(val string, attempts int) Action) (int, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	entry, err := ms.entry(key)
+	if err != nil {
+		return 0, err
+	}
+	switch fn(entry.value, entry.attempts) {
+	case ActionDelete:
+		delete(ms.store, key)
+	case ActionFail:
+		entry.attempts++
+		ms.store[key] = entry
+	}
+	return entry.attempts, nil
+}
+
+// entry must be called with ms.mu held.
+func (ms *MemStateManager) entry(key string) (otpEntry, error) {
 	entry, exists := ms.store[key]
 	if !exists {
-		return "", fmt.Errorf("key not found")
+		return otpEntry{}, ErrKeyNotFound
 	}
-
 	if time.Now().After(entry.expiry) {
-		delete(ms.store, key)
-		return "", fmt.Errorf("key expired")
+		return otpEntry{}, ErrKeyExpired
+	}
+	return entry, nil
+}
+
+// Len returns the number of entries held, including expired ones not yet
+// evicted.
+func (ms *MemStateManager) Len() int {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	return len(ms.store)
+}
+
+// HealthCheck fails once the manager has been closed.
+func (ms *MemStateManager) HealthCheck(context.Context) error {
+	select {
+	case <-ms.done:
+		return errors.New("state manager is closed")
+	default:
+		return nil
 	}
+}
 
-	return entry.value, nil
+// Close stops the eviction goroutine. It is safe to call more than once.
+func (ms *MemStateManager) Close() error {
+	ms.closeOnce.Do(func() { close(ms.done) })
+	return nil
 }
 
 func (ms *MemStateManager) cleanup() {
 	ticker := time.NewTicker(1 * time.Minute)
 	defer ticker.Stop()
 
-	for range ticker.C {
+	for {
+		select {
+		case <-ms.done:
+			return
+		case <-ticker.C:
+		}
+
 		ms.mu.Lock()
 		now := time.Now()
 		for key, entry := range ms.store {
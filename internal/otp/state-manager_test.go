@@ -0,0 +1,23 @@
+package otp
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/goleak"
+)
+
+func TestMemStateManagerClose(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	sm := NewMemStateManager(time.Minute)
+	if err := sm.SetX(t.Context(), "09120000000", "123456"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := sm.Get(t.Context(), "09120000000"); err != nil || got != "123456" {
+		t.Fatalf("Get = %q, %v", got, err)
+	}
+
+	sm.Close()
+	sm.Close()
+}
@@ -0,0 +1,137 @@
+package otp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"rsc.io/qr"
+)
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTPOptions configures RFC 6238 time-based codes, computed with
+// HMAC-SHA1 as authenticator apps expect.
+type TOTPOptions struct {
+	// Issuer names the service in authenticator apps.
+	Issuer string
+	Digits int
+	Period time.Duration
+	// Skew is the number of periods before and after the current one whose
+	// codes are accepted too, allowing for clock drift.
+	Skew int
+}
+
+// NewTOTPSecret returns a random 160 bit secret, base32 encoded.
+func NewTOTPSecret() string {
+	b := make([]byte, 20)
+	rand.Read(b)
+	return base32NoPadding.EncodeToString(b)
+}
+
+// URI returns the otpauth:// URI authenticator apps enroll secret from.
+func (o TOTPOptions) URI(account, secret string) string {
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", o.Issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(o.Digits))
+	params.Set("period", fmt.Sprint(int(o.Period.Seconds())))
+
+	label := url.PathEscape(o.Issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// Step returns the time step t falls in.
+func (o TOTPOptions) Step(t time.Time) int64 {
+	return t.Unix() / int64(o.Period.Seconds())
+}
+
+// Code returns the code of secret for the given time step.
+func (o TOTPOptions) Code(secret string, step int64) (string, error) {
+	key, err := base32NoPadding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", fmt.Errorf("invalid TOTP secret: %w", err)
+	}
+
+	mac := hmac.New(sha1.New, key)
+	binary.Write(mac, binary.BigEndian, step)
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation, RFC 4226 section 5.3.
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+
+	mod := uint32(1)
+	for range o.Digits {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", o.Digits, value%mod), nil
+}
+
+// Validate returns the time step of code if it is valid within the skew
+// window around now, and ErrOTPMismatch otherwise. Codes of lastStep or an
+// earlier step are rejected with ErrOTPReused.
+func (o TOTPOptions) Validate(secret, code string, now time.Time, lastStep int64) (int64, error) {
+	current := o.Step(now)
+	reused := false
+	for step := current - int64(o.Skew); step <= current+int64(o.Skew); step++ {
+		want, err := o.Code(secret, step)
+		if err != nil {
+			return 0, err
+		}
+		if subtle.ConstantTimeCompare([]byte(want), []byte(code)) != 1 {
+			continue
+		}
+		if step <= lastStep {
+			reused = true
+			continue
+		}
+		return step, nil
+	}
+
+	if reused {
+		return 0, ErrOTPReused
+	}
+	return 0, ErrOTPMismatch
+}
+
+// QRCodePNG renders uri as a QR code PNG image for authenticator apps to
+// scan.
+func QRCodePNG(uri string) ([]byte, error) {
+	code, err := qr.Encode(uri, qr.M)
+	if err != nil {
+		return nil, err
+	}
+	return code.PNG(), nil
+}
+
+// NewRecoveryCodes returns n random single-use codes of the form
+// xxxxx-xxxxx.
+func NewRecoveryCodes(n int) []string {
+	codes := make([]string, n)
+	for i := range codes {
+		b := make([]byte, 7)
+		rand.Read(b)
+		s := strings.ToLower(base32NoPadding.EncodeToString(b))[:10]
+		codes[i] = s[:5] + "-" + s[5:]
+	}
+	return codes
+}
+
+// HashRecoveryCode returns the hash a recovery code is stored as. Case and
+// dashes are ignored, so that codes can be typed loosely.
+func HashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
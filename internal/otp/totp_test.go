@@ -0,0 +1,88 @@
+package otp
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 secret of the RFC 6238 test vectors,
+// "12345678901234567890", base32 encoded.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	opts := TOTPOptions{Digits: 8, Period: 30 * time.Second}
+
+	for unix, want := range map[int64]string{
+		59:         "94287082",
+		1111111109: "07081804",
+		1234567890: "89005924",
+		2000000000: "69279037",
+	} {
+		got, err := opts.Code(rfc6238Secret, opts.Step(time.Unix(unix, 0)))
+		if err != nil || got != want {
+			t.Errorf("code at %d = %q, %v, want %q", unix, got, err, want)
+		}
+	}
+}
+
+func TestTOTPValidate(t *testing.T) {
+	opts := TOTPOptions{Digits: 6, Period: 30 * time.Second, Skew: 1}
+	now := time.Unix(1700000000, 0)
+	current := opts.Step(now)
+
+	previous, _ := opts.Code(rfc6238Secret, current-1)
+	step, err := opts.Validate(rfc6238Secret, previous, now, 0)
+	if err != nil || step != current-1 {
+		t.Errorf("Validate of the previous code = %d, %v", step, err)
+	}
+
+	if _, err := opts.Validate(rfc6238Secret, previous, now, current-1); !errors.Is(err, ErrOTPReused) {
+		t.Errorf("Validate of a used code = %v, want ErrOTPReused", err)
+	}
+
+	old, _ := opts.Code(rfc6238Secret, current-2)
+	if _, err := opts.Validate(rfc6238Secret, old, now, 0); !errors.Is(err, ErrOTPMismatch) {
+		t.Errorf("Validate of a code outside the skew window = %v, want ErrOTPMismatch", err)
+	}
+}
+
+func TestChallenges(t *testing.T) {
+	sm := NewMemStateManager(time.Minute)
+	defer sm.Close()
+	c := NewChallenges(sm, "mfa", 2)
+
+	id, err := c.Create(t.Context(), "user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var checkErr *CheckError
+	_, err = c.Pass(t.Context(), id, func(string) error { return ErrOTPMismatch })
+	if !errors.As(err, &checkErr) || checkErr.RemainingAttempts != 1 {
+		t.Fatalf("Pass with a wrong code = %v, want 1 attempt remaining", err)
+	}
+
+	subject, err := c.Pass(t.Context(), id, func(subject string) error { return nil })
+	if err != nil || subject != "user-1" {
+		t.Fatalf("Pass = %q, %v", subject, err)
+	}
+	if _, err := c.Pass(t.Context(), id, func(string) error { return nil }); !errors.Is(err, ErrOTPNotRequested) {
+		t.Errorf("Pass of a passed challenge = %v, want ErrOTPNotRequested", err)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes := NewRecoveryCodes(10)
+	seen := make(map[string]bool)
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' || seen[code] {
+			t.Fatalf("bad or repeated recovery code %q", code)
+		}
+		seen[code] = true
+	}
+
+	if HashRecoveryCode("ABCDE-fghij") != HashRecoveryCode("abcdefghij") {
+		t.Error("HashRecoveryCode depends on case or dashes")
+	}
+}
@@ -0,0 +1,255 @@
+// Package passkey registers WebAuthn passkeys and logs users in with them.
+package passkey
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/epicmet/dekamond-task/internal/otp"
+	"github.com/epicmet/dekamond-task/internal/users"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+const sessionPrefix = "webauthn:"
+
+var (
+	// ErrCeremonyNotFound means the response answers a challenge that
+	// wasn't issued, has expired or was answered already.
+	ErrCeremonyNotFound = errors.New("passkey: unknown or expired challenge")
+	// ErrInvalid means the response couldn't be verified. The error
+	// wrapping it describes why.
+	ErrInvalid = errors.New("passkey: invalid response")
+)
+
+type Options struct {
+	// RPID is the domain passkeys are bound to.
+	RPID          string
+	RPDisplayName string
+	// RPOrigins are the origins of the pages allowed to use the passkeys.
+	RPOrigins []string
+	// Timeout bounds how long the user has to answer a challenge.
+	Timeout time.Duration
+}
+
+// Passkeys runs registration and login ceremonies. The session data of a
+// ceremony is kept in an OTP state store under its challenge between its two
+// steps, so it expires with the TTL of the store and can only be finished
+// once.
+type Passkeys struct {
+	webAuthn     *webauthn.WebAuthn
+	stateManager otp.OTPStateManager
+	users        users.UserRepository
+}
+
+func New(repo users.UserRepository, sm otp.OTPStateManager, opts Options) (*Passkeys, error) {
+	timeout := webauthn.TimeoutConfig{Enforce: true, Timeout: opts.Timeout, TimeoutUVD: opts.Timeout}
+	webAuthn, err := webauthn.New(&webauthn.Config{
+		RPID:          opts.RPID,
+		RPDisplayName: opts.RPDisplayName,
+		RPOrigins:     opts.RPOrigins,
+		Timeouts:      webauthn.TimeoutsConfig{Login: timeout, Registration: timeout},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Passkeys{webAuthn: webAuthn, stateManager: sm, users: repo}, nil
+}
+
+// BeginRegistration starts registering a passkey for user. The returned
+// options are passed to navigator.credentials.create(). Passkeys are
+// discoverable, so that logging in doesn't need the phone number.
+func (p *Passkeys) BeginRegistration(ctx context.Context, user *users.User) (*protocol.CredentialCreation, error) {
+	owner, err := p.owner(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	creation, session, err := p.webAuthn.BeginRegistration(owner,
+		webauthn.WithExclusions(webauthn.Credentials(owner.WebAuthnCredentials()).CredentialDescriptors()),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.saveSession(ctx, session); err != nil {
+		return nil, err
+	}
+
+	return creation, nil
+}
+
+// FinishRegistration verifies the response of navigator.credentials.create()
+// read from body and stores the new passkey of user.
+func (p *Passkeys) FinishRegistration(ctx context.Context, user *users.User, body io.Reader) (*users.Passkey, error) {
+	response, err := protocol.ParseCredentialCreationResponseBody(body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+	session, err := p.takeSession(ctx, response.Response.CollectedClientData.Challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	owner, err := p.owner(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	credential, err := p.webAuthn.CreateCredential(owner, *session, response)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+
+	passkey := users.Passkey{
+		ID:              credential.ID,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		AAGUID:          credential.Authenticator.AAGUID,
+		SignCount:       credential.Authenticator.SignCount,
+		BackupEligible:  credential.Flags.BackupEligible,
+		CreatedAt:       time.Now().UTC(),
+	}
+	for _, transport := range credential.Transport {
+		passkey.Transports = append(passkey.Transports, string(transport))
+	}
+	if err := p.users.AddPasskey(ctx, user.ID.Hex(), passkey); err != nil {
+		return nil, err
+	}
+
+	return &passkey, nil
+}
+
+// BeginLogin starts a login. The returned options are passed to
+// navigator.credentials.get().
+func (p *Passkeys) BeginLogin(ctx context.Context) (*protocol.CredentialAssertion, error) {
+	assertion, session, err := p.webAuthn.BeginDiscoverableLogin()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.saveSession(ctx, session); err != nil {
+		return nil, err
+	}
+
+	return assertion, nil
+}
+
+// FinishLogin verifies the response of navigator.credentials.get() read from
+// body and returns the user it logs in. Responses of an authenticator whose
+// signature counter went backwards are rejected, as it may have been cloned.
+func (p *Passkeys) FinishLogin(ctx context.Context, body io.Reader) (*users.User, error) {
+	response, err := protocol.ParseCredentialRequestResponseBody(body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+	session, err := p.takeSession(ctx, response.Response.CollectedClientData.Challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	var user *users.User
+	_, credential, err := p.webAuthn.ValidatePasskeyLogin(func(_, userHandle []byte) (webauthn.User, error) {
+		var err error
+		user, err = p.users.FindByID(ctx, string(userHandle))
+		if err != nil {
+			return nil, err
+		}
+		return p.owner(ctx, user)
+	}, *session, response)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+	if credential.Authenticator.CloneWarning {
+		return nil, fmt.Errorf("%w: signature counter didn't increase", ErrInvalid)
+	}
+
+	if err := p.users.UsePasskey(ctx, user.ID.Hex(), credential.ID, credential.Authenticator.SignCount); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (p *Passkeys) saveSession(ctx context.Context, session *webauthn.SessionData) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	return p.stateManager.SetX(ctx, sessionPrefix+session.Challenge, string(data))
+}
+
+// takeSession returns and removes the session data of the ceremony with the
+// given challenge.
+func (p *Passkeys) takeSession(ctx context.Context, challenge string) (*webauthn.SessionData, error) {
+	// Taking the session in one update answers each challenge once, even
+	// if it is raced.
+	var data string
+	_, err := p.stateManager.Update(ctx, sessionPrefix+challenge, func(val string, _ int) otp.Action {
+		data = val
+		return otp.ActionDelete
+	})
+	if errors.Is(err, otp.ErrKeyNotFound) || errors.Is(err, otp.ErrKeyExpired) {
+		return nil, ErrCeremonyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var session webauthn.SessionData
+	if err := json.Unmarshal([]byte(data), &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (p *Passkeys) owner(ctx context.Context, user *users.User) (*owner, error) {
+	passkeys, err := p.users.Passkeys(ctx, user.ID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	return &owner{user: user, passkeys: passkeys}, nil
+}
+
+// owner adapts a user and their passkeys to webauthn.User. The user handle
+// is the hex user ID, so that discoverable logins can look the user up.
+type owner struct {
+	user     *users.User
+	passkeys []users.Passkey
+}
+
+func (o *owner) WebAuthnID() []byte {
+	return []byte(o.user.ID.Hex())
+}
+
+func (o *owner) WebAuthnName() string {
+	return o.user.PhoneNumber
+}
+
+func (o *owner) WebAuthnDisplayName() string {
+	if name := strings.TrimSpace(o.user.FirstName + " " + o.user.LastName); name != "" {
+		return name
+	}
+	return o.user.PhoneNumber
+}
+
+func (o *owner) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, len(o.passkeys))
+	for i, passkey := range o.passkeys {
+		credentials[i] = webauthn.Credential{
+			ID:              passkey.ID,
+			PublicKey:       passkey.PublicKey,
+			AttestationType: passkey.AttestationType,
+			Flags:           webauthn.CredentialFlags{BackupEligible: passkey.BackupEligible},
+			Authenticator:   webauthn.Authenticator{AAGUID: passkey.AAGUID, SignCount: passkey.SignCount},
+		}
+		for _, transport := range passkey.Transports {
+			credentials[i].Transport = append(credentials[i].Transport, protocol.AuthenticatorTransport(transport))
+		}
+	}
+	return credentials
+}
@@ -0,0 +1,128 @@
+package passkey
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/epicmet/dekamond-task/internal/otp"
+	"github.com/epicmet/dekamond-task/internal/passkey/passkeytest"
+	"github.com/epicmet/dekamond-task/internal/users"
+)
+
+func newPasskeys(t *testing.T) (*Passkeys, users.UserRepository) {
+	t.Helper()
+
+	sm := otp.NewMemStateManager(time.Minute)
+	t.Cleanup(func() { sm.Close() })
+	repo := users.NewMemoryUserRepository()
+	p, err := New(repo, sm, Options{
+		RPID:          "localhost",
+		RPDisplayName: "Dekamond",
+		RPOrigins:     []string{"http://localhost:8080"},
+		Timeout:       time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p, repo
+}
+
+func mustJSON(t *testing.T, v any) []byte {
+	t.Helper()
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestPasskeys(t *testing.T) {
+	p, repo := newPasskeys(t)
+	authenticator := passkeytest.New("http://localhost:8080")
+	user, err := repo.Create(t.Context(), "09120000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	creation, err := p.BeginRegistration(t.Context(), user)
+	if err != nil {
+		t.Fatalf("BeginRegistration: %v", err)
+	}
+	response, err := authenticator.Create(mustJSON(t, creation))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.FinishRegistration(t.Context(), user, bytes.NewReader(response)); err != nil {
+		t.Fatalf("FinishRegistration: %v", err)
+	}
+	if _, err := p.FinishRegistration(t.Context(), user, bytes.NewReader(response)); !errors.Is(err, ErrCeremonyNotFound) {
+		t.Errorf("finishing a registration twice returned %v, want ErrCeremonyNotFound", err)
+	}
+
+	// The registered passkey is excluded from further registrations.
+	creation, err = p.BeginRegistration(t.Context(), user)
+	if err != nil {
+		t.Fatalf("BeginRegistration: %v", err)
+	}
+	if _, err := authenticator.Create(mustJSON(t, creation)); err == nil {
+		t.Error("the authenticator registered an excluded passkey")
+	}
+
+	login := func() ([]byte, error) {
+		assertion, err := p.BeginLogin(t.Context())
+		if err != nil {
+			return nil, err
+		}
+		return authenticator.Get(mustJSON(t, assertion))
+	}
+
+	response, err = login()
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	loggedIn, err := p.FinishLogin(t.Context(), bytes.NewReader(response))
+	if err != nil {
+		t.Fatalf("FinishLogin: %v", err)
+	}
+	if loggedIn.ID != user.ID {
+		t.Errorf("FinishLogin logged in %s, want %s", loggedIn.ID.Hex(), user.ID.Hex())
+	}
+	if _, err := p.FinishLogin(t.Context(), bytes.NewReader(response)); !errors.Is(err, ErrCeremonyNotFound) {
+		t.Errorf("replaying a login returned %v, want ErrCeremonyNotFound", err)
+	}
+
+	authenticator.Reset()
+	response, err = login()
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	if _, err := p.FinishLogin(t.Context(), bytes.NewReader(response)); !errors.Is(err, ErrInvalid) {
+		t.Errorf("login with a rolled back signature counter returned %v, want ErrInvalid", err)
+	}
+}
+
+func TestFinishLoginWrongOrigin(t *testing.T) {
+	p, repo := newPasskeys(t)
+	user, err := repo.Create(t.Context(), "09120000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A phishing page can't use the passkey, as the origin is signed.
+	authenticator := passkeytest.New("https://evil.example")
+	creation, err := p.BeginRegistration(t.Context(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := authenticator.Create(mustJSON(t, creation))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.FinishRegistration(t.Context(), user, bytes.NewReader(response)); !errors.Is(err, ErrInvalid) {
+		t.Errorf("FinishRegistration from another origin returned %v, want ErrInvalid", err)
+	}
+}
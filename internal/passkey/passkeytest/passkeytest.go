@@ -0,0 +1,192 @@
+// Package passkeytest provides a software WebAuthn authenticator, standing
+// in for the browser and a platform authenticator in tests.
+package passkeytest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"slices"
+
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+)
+
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttestedData = 0x40
+)
+
+var b64 = base64.RawURLEncoding
+
+// Authenticator creates discoverable ES256 credentials with "none"
+// attestation and signs assertions with them, as the page at Origin.
+type Authenticator struct {
+	Origin      string
+	credentials []*credential
+}
+
+type credential struct {
+	id         []byte
+	key        *ecdsa.PrivateKey
+	rpID       string
+	userHandle []byte
+	signCount  uint32
+}
+
+func New(origin string) *Authenticator {
+	return &Authenticator{Origin: origin}
+}
+
+// Create answers the JSON options of navigator.credentials.create() with
+// the JSON of a new credential. Like a browser, it refuses to create a
+// credential if one it holds is excluded.
+func (a *Authenticator) Create(options []byte) ([]byte, error) {
+	var opts struct {
+		PublicKey struct {
+			Challenge string `json:"challenge"`
+			RP        struct {
+				ID string `json:"id"`
+			} `json:"rp"`
+			User struct {
+				ID string `json:"id"`
+			} `json:"user"`
+			ExcludeCredentials []struct {
+				ID string `json:"id"`
+			} `json:"excludeCredentials"`
+		} `json:"publicKey"`
+	}
+	if err := json.Unmarshal(options, &opts); err != nil {
+		return nil, err
+	}
+	for _, excluded := range opts.PublicKey.ExcludeCredentials {
+		if slices.ContainsFunc(a.credentials, func(c *credential) bool { return b64.EncodeToString(c.id) == excluded.ID }) {
+			return nil, errors.New("passkeytest: credential already registered")
+		}
+	}
+
+	userHandle, err := b64.DecodeString(opts.PublicKey.User.ID)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	c := &credential{id: make([]byte, 16), key: key, rpID: opts.PublicKey.RP.ID, userHandle: userHandle}
+	rand.Read(c.id)
+
+	publicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  1, // P-256
+		XCoord: key.X.FillBytes(make([]byte, 32)),
+		YCoord: key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	authData := c.authenticatorData(flagUserPresent | flagUserVerified | flagAttestedData)
+	authData = append(authData, make([]byte, 16)...) // AAGUID
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(c.id)))
+	authData = append(authData, c.id...)
+	authData = append(authData, publicKey...)
+
+	attestation, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": authData,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	a.credentials = append(a.credentials, c)
+	return json.Marshal(map[string]any{
+		"id":    b64.EncodeToString(c.id),
+		"rawId": b64.EncodeToString(c.id),
+		"type":  "public-key",
+		"response": map[string]any{
+			"clientDataJSON":    b64.EncodeToString(a.clientData("webauthn.create", opts.PublicKey.Challenge)),
+			"attestationObject": b64.EncodeToString(attestation),
+			"transports":        []string{"internal"},
+		},
+		"clientExtensionResults": map[string]any{},
+	})
+}
+
+// Get answers the JSON options of navigator.credentials.get() with the JSON
+// of an assertion by the credential for the relying party created last.
+func (a *Authenticator) Get(options []byte) ([]byte, error) {
+	var opts struct {
+		PublicKey struct {
+			Challenge string `json:"challenge"`
+			RPID      string `json:"rpId"`
+		} `json:"publicKey"`
+	}
+	if err := json.Unmarshal(options, &opts); err != nil {
+		return nil, err
+	}
+
+	var c *credential
+	for _, candidate := range slices.Backward(a.credentials) {
+		if candidate.rpID == opts.PublicKey.RPID {
+			c = candidate
+			break
+		}
+	}
+	if c == nil {
+		return nil, errors.New("passkeytest: no credential for the relying party")
+	}
+	c.signCount++
+
+	authData := c.authenticatorData(flagUserPresent | flagUserVerified)
+	clientData := a.clientData("webauthn.get", opts.PublicKey.Challenge)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(slices.Clone(authData), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, c.key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(map[string]any{
+		"id":    b64.EncodeToString(c.id),
+		"rawId": b64.EncodeToString(c.id),
+		"type":  "public-key",
+		"response": map[string]any{
+			"clientDataJSON":    b64.EncodeToString(clientData),
+			"authenticatorData": b64.EncodeToString(authData),
+			"signature":         b64.EncodeToString(signature),
+			"userHandle":        b64.EncodeToString(c.userHandle),
+		},
+		"clientExtensionResults": map[string]any{},
+	})
+}
+
+// Reset rolls back the signature counters of every credential, as a cloned
+// authenticator would.
+func (a *Authenticator) Reset() {
+	for _, c := range a.credentials {
+		c.signCount = 0
+	}
+}
+
+func (a *Authenticator) clientData(ceremony, challenge string) []byte {
+	data, _ := json.Marshal(map[string]any{"type": ceremony, "challenge": challenge, "origin": a.Origin, "crossOrigin": false})
+	return data
+}
+
+func (c *credential) authenticatorData(flags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(c.rpID))
+	data := append(rpIDHash[:], flags)
+	return binary.BigEndian.AppendUint32(data, c.signCount)
+}
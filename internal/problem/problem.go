@@ -0,0 +1,180 @@
+// Package problem writes error responses as RFC 7807 problem details
+// (application/problem+json). Every problem carries a stable, machine
+// readable code that clients can branch on instead of the English detail.
+package problem
+
+import (
+	"encoding/json"
+	"maps"
+
+	"github.com/epicmet/dekamond-task/internal/logging"
+	"github.com/gin-gonic/gin"
+)
+
+// ContentType is the media type of problem responses.
+const ContentType = "application/problem+json"
+
+// typePrefix turns a code into the problem type URI.
+const typePrefix = "urn:dekamond-task:problem:"
+
+type Code string
+
+const (
+	// CodeInvalidRequest means the body or a parameter couldn't be parsed.
+	CodeInvalidRequest Code = "invalid_request"
+	// CodeValidationFailed means a field has an invalid value. The field
+	// extension names it.
+	CodeValidationFailed Code = "validation_failed"
+	// CodeOTPInvalid means the OTP is wrong. The remaining_attempts
+	// extension says how many more guesses are allowed.
+	CodeOTPInvalid Code = "otp_invalid"
+	// CodeOTPNotRequested, CodeOTPExpired and CodeOTPLocked mean the OTP
+	// can't be verified any more and a new one must be requested.
+	CodeOTPNotRequested Code = "otp_not_requested"
+	CodeOTPExpired      Code = "otp_expired"
+	CodeOTPLocked       Code = "otp_locked"
+	// CodeOTPResendTooSoon means the last OTP was sent too recently. The
+	// resend_after extension and the Retry-After header give the seconds
+	// to wait.
+	CodeOTPResendTooSoon Code = "otp_resend_too_soon"
+	// CodeOTPReused means the time-based code was already used; wait for
+	// the next one.
+	CodeOTPReused          Code = "otp_reused"
+	CodeTOTPAlreadyEnabled Code = "totp_already_enabled"
+	CodeTOTPNotEnrolled    Code = "totp_not_enrolled"
+	// CodePasskeyChallengeUnknown means a passkey response answers a
+	// challenge that wasn't issued, has expired or was answered already.
+	CodePasskeyChallengeUnknown Code = "passkey_challenge_unknown"
+	CodePasskeyInvalid          Code = "passkey_invalid"
+	CodePasskeyRegistered       Code = "passkey_already_registered"
+	// CodeMagicLinkInvalid means the login or email verification link was
+	// tampered with or cut short.
+	CodeMagicLinkInvalid Code = "magic_link_invalid"
+	// CodeEmailNotSet means a login or email verification link was asked
+	// for a user without an email address.
+	CodeEmailNotSet Code = "email_not_set"
+	// CodeEmailNotVerified means a login link was asked for a user who
+	// hasn't confirmed their email address.
+	CodeEmailNotVerified Code = "email_not_verified"
+	// CodeDeviceNotTrusted means the device isn't trusted by the user, or
+	// no longer; log in with an OTP to trust it again.
+	CodeDeviceNotTrusted Code = "device_not_trusted"
+	CodeDeviceNotFound   Code = "device_not_found"
+	CodeRateLimited      Code = "rate_limited"
+	// CodeSessionRevoked means the token's session was logged out.
+	CodeSessionRevoked  Code = "session_revoked"
+	CodeSessionNotFound Code = "session_not_found"
+	// CodeUnauthenticated means the request carries no bearer token.
+	CodeUnauthenticated Code = "unauthenticated"
+	// CodeTokenInvalid means the bearer token is malformed, expired or
+	// wasn't issued by this service.
+	CodeTokenInvalid Code = "token_invalid"
+	// CodeTokenStale means the token is valid but describes a role the user
+	// no longer has; logging in again issues a fresh one.
+	CodeTokenStale       Code = "token_stale"
+	CodePermissionDenied Code = "permission_denied"
+	// CodeAccountBlocked means the account is suspended or banned. The
+	// account_status, reason and expires_at extensions describe the block.
+	CodeAccountBlocked Code = "account_blocked"
+	CodeInvalidUserID  Code = "invalid_user_id"
+	CodeUserNotFound   Code = "user_not_found"
+	CodePhoneTaken     Code = "phone_already_registered"
+	CodeConflict       Code = "conflict"
+	CodeInternal       Code = "internal_error"
+)
+
+var titles = map[Code]string{
+	CodeInvalidRequest:          "Invalid request",
+	CodeValidationFailed:        "Validation failed",
+	CodeOTPInvalid:              "Invalid OTP",
+	CodeOTPNotRequested:         "OTP not requested",
+	CodeOTPExpired:              "OTP expired",
+	CodeOTPLocked:               "OTP locked",
+	CodeOTPResendTooSoon:        "OTP resent too soon",
+	CodeOTPReused:               "OTP already used",
+	CodeTOTPAlreadyEnabled:      "TOTP already enabled",
+	CodeTOTPNotEnrolled:         "TOTP not enrolled",
+	CodePasskeyChallengeUnknown: "Passkey challenge unknown",
+	CodePasskeyInvalid:          "Invalid passkey",
+	CodePasskeyRegistered:       "Passkey already registered",
+	CodeMagicLinkInvalid:        "Invalid magic link",
+	CodeEmailNotSet:             "Email not set",
+	CodeEmailNotVerified:        "Email not verified",
+	CodeDeviceNotTrusted:        "Device not trusted",
+	CodeDeviceNotFound:          "Device not found",
+	CodeRateLimited:             "Too many requests",
+	CodeSessionRevoked:          "Session revoked",
+	CodeSessionNotFound:         "Session not found",
+	CodeUnauthenticated:         "Authentication required",
+	CodeTokenInvalid:            "Invalid token",
+	CodeTokenStale:              "Token is stale",
+	CodePermissionDenied:        "Permission denied",
+	CodeAccountBlocked:          "Account is blocked",
+	CodeInvalidUserID:           "Invalid user ID",
+	CodeUserNotFound:            "User not found",
+	CodePhoneTaken:              "Phone number already registered",
+	CodeConflict:                "Conflict",
+	CodeInternal:                "Internal error",
+}
+
+// Problem is an RFC 7807 problem details object. Extensions are serialized
+// as additional top-level members.
+type Problem struct {
+	Type      string `json:"type" example:"urn:dekamond-task:problem:user_not_found"`
+	Title     string `json:"title" example:"User not found"`
+	Status    int    `json:"status" example:"404"`
+	Detail    string `json:"detail,omitempty" example:"user not found"`
+	Instance  string `json:"instance,omitempty" example:"/users/6650c7a2f1e4b2a9c8d7e6f5"`
+	Code      Code   `json:"code" example:"user_not_found"`
+	RequestID string `json:"request_id,omitempty" example:"40d366a788f95ac4ee72a61cbb971416"`
+
+	Extensions map[string]any `json:"-" swaggerignore:"true"`
+}
+
+// New returns a problem with the given HTTP status and code. detail is a
+// human readable explanation of this occurrence.
+func New(status int, code Code, detail string) *Problem {
+	return &Problem{
+		Type:   typePrefix + string(code),
+		Title:  titles[code],
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// With sets an extension member and returns p.
+func (p *Problem) With(key string, value any) *Problem {
+	if p.Extensions == nil {
+		p.Extensions = make(map[string]any)
+	}
+	p.Extensions[key] = value
+	return p
+}
+
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	// The alias drops this method, avoiding the recursion.
+	type problem Problem
+	base, err := json.Marshal((*problem)(p))
+	if err != nil || len(p.Extensions) == 0 {
+		return base, err
+	}
+
+	members := make(map[string]any, len(p.Extensions)+7)
+	maps.Copy(members, p.Extensions)
+	// The standard members win over extensions of the same name.
+	if err := json.Unmarshal(base, &members); err != nil {
+		return nil, err
+	}
+	return json.Marshal(members)
+}
+
+// Abort writes p as the response of c, tagged with the request path and ID,
+// and aborts the handler chain.
+func Abort(c *gin.Context, p *Problem) {
+	p.Instance = c.Request.URL.Path
+	p.RequestID = c.Writer.Header().Get(logging.RequestIDHeader)
+
+	c.Header("Content-Type", ContentType)
+	c.AbortWithStatusJSON(p.Status, p)
+}
@@ -0,0 +1,30 @@
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestMarshalJSON(t *testing.T) {
+	p := New(http.StatusBadRequest, CodeValidationFailed, "bad name").
+		With("field", "first_name").
+		With("status", "ignored")
+
+	b, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if got["field"] != "first_name" || got["code"] != "validation_failed" ||
+		got["type"] != "urn:dekamond-task:problem:validation_failed" {
+		t.Errorf("marshalled %s", b)
+	}
+	if got["status"] != float64(http.StatusBadRequest) {
+		t.Errorf("an extension overrode the status: %s", b)
+	}
+}
@@ -1,11 +1,19 @@
 package ratelimit
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"sync"
 	"time"
 
+	"github.com/epicmet/dekamond-task/internal/logging"
+	"github.com/epicmet/dekamond-task/internal/metrics"
+	"github.com/epicmet/dekamond-task/internal/problem"
+	"github.com/epicmet/dekamond-task/internal/tracing"
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type TokenBucket struct {
@@ -13,22 +21,31 @@ type TokenBucket struct {
 	RefillRate time.Duration
 	Key        string
 	sm         RateLimitStateManager
+	keyPrefix  string
+
+	done      chan struct{}
+	closeOnce *sync.Once
 }
 
+// NewTokenBucket starts a goroutine that refills the bucket every
+// refillRate until Close is called. Closing the bucket doesn't close sm.
 func NewTokenBucket(keyPrefix string, capacity int64, refillRate time.Duration, sm RateLimitStateManager) *TokenBucket {
 	key := fmt.Sprintf("%s::rate-limiter::token-bucket::bucket", keyPrefix)
 
 	ticker := time.NewTicker(refillRate)
+	done := make(chan struct{})
 
-	sm.Set(key, capacity, 0)
+	sm.Set(context.Background(), key, capacity, 0)
 
 	go func() {
+		defer ticker.Stop()
+
 		for {
 			select {
-			case _ = <-ticker.C:
-				{
-					sm.Set(key, capacity, 0)
-				}
+			case <-done:
+				return
+			case <-ticker.C:
+				sm.Set(context.Background(), key, capacity, 0)
 			}
 		}
 	}()
@@ -38,16 +55,40 @@ func NewTokenBucket(keyPrefix string, capacity int64, refillRate time.Duration,
 		RefillRate: refillRate,
 		Key:        key,
 		sm:         sm,
+		keyPrefix:  keyPrefix,
+		done:       done,
+		closeOnce:  &sync.Once{},
 	}
 }
 
-func (tb TokenBucket) Allow() bool {
-	bucketCounter, err := tb.sm.Get(tb.Key)
+// Close stops the refill goroutine. It is safe to call more than once.
+func (tb TokenBucket) Close() error {
+	tb.closeOnce.Do(func() { close(tb.done) })
+	return nil
+}
+
+// HealthCheck reports whether the state manager of the bucket is usable.
+func (tb TokenBucket) HealthCheck(ctx context.Context) error {
+	return tb.sm.HealthCheck(ctx)
+}
+
+func (tb TokenBucket) Allow(ctx context.Context) bool {
+	ctx, span := tracing.Tracer().Start(ctx, "ratelimit.allow", trace.WithAttributes(attribute.String("ratelimit.limiter", tb.keyPrefix)))
+	defer span.End()
+
+	allowed := tb.take(ctx)
+	span.SetAttributes(attribute.Bool("ratelimit.allowed", allowed))
+	metrics.RateLimitDecision(tb.keyPrefix, allowed)
+	return allowed
+}
+
+func (tb TokenBucket) take(ctx context.Context) bool {
+	bucketCounter, err := tb.sm.Get(ctx, tb.Key)
 	if err != nil || bucketCounter <= 0 {
 		return false
 	}
-	if _, err := tb.sm.Decr(tb.Key); err != nil {
-		fmt.Println("Couldn't Decr the bucket counter. Error: ", err.Error())
+	if _, err := tb.sm.Decr(ctx, tb.Key); err != nil {
+		logging.FromContext(ctx).Error("failed to take a token", "bucket", tb.Key, "error", err)
 		return false
 	}
 
@@ -56,12 +97,8 @@ func (tb TokenBucket) Allow() bool {
 
 func (tb TokenBucket) GinMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if !tb.Allow() {
-			c.JSON(
-				http.StatusTooManyRequests,
-				gin.H{},
-			)
-			c.Abort()
+		if !tb.Allow(c.Request.Context()) {
+			problem.Abort(c, problem.New(http.StatusTooManyRequests, problem.CodeRateLimited, "too many requests, try again later"))
 		}
 	}
 }
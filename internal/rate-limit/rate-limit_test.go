@@ -0,0 +1,31 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/goleak"
+)
+
+func TestTokenBucketClose(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	sm := NewInMemoryStateManager()
+	tb := NewTokenBucket("test", 2, time.Millisecond*10, sm)
+
+	if !tb.Allow(t.Context()) || !tb.Allow(t.Context()) {
+		t.Fatal("expected a full bucket to allow two requests")
+	}
+	if tb.Allow(t.Context()) {
+		t.Fatal("expected an empty bucket to deny")
+	}
+
+	time.Sleep(time.Millisecond * 30)
+	if !tb.Allow(t.Context()) {
+		t.Fatal("expected the bucket to be refilled")
+	}
+
+	tb.Close()
+	tb.Close()
+	sm.Close()
+}
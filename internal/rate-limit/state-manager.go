@@ -1,16 +1,19 @@
 package ratelimit
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
 )
 
 type RateLimitStateManager interface {
-	Get(key string) (int64, error)
-	Set(key string, value int64, expireTime time.Duration) (string, error)
-	Decr(key string) (int64, error)
-	Incr(key string) (int64, error)
+	Get(ctx context.Context, key string) (int64, error)
+	Set(ctx context.Context, key string, value int64, expireTime time.Duration) (string, error)
+	Decr(ctx context.Context, key string) (int64, error)
+	Incr(ctx context.Context, key string) (int64, error)
+	HealthCheck(ctx context.Context) error
 }
 
 type entry struct {
@@ -21,11 +24,17 @@ type entry struct {
 type InMemoryStateManager struct {
 	store map[string]entry
 	mu    sync.RWMutex
+
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
+// NewInMemoryStateManager starts a goroutine that evicts expired entries
+// until Close is called.
 func NewInMemoryStateManager() *InMemoryStateManager {
 	sm := &InMemoryStateManager{
 		store: make(map[string]entry),
+		done:  make(chan struct{}),
 	}
 
 	go sm.cleanup()
@@ -33,7 +42,7 @@ func NewInMemoryStateManager() *InMemoryStateManager {
 	return sm
 }
 
-func (sm *InMemoryStateManager) Get(key string) (int64, error) {
+func (sm *InMemoryStateManager) Get(_ context.Context, key string) (int64, error) {
 	sm.mu.RLock()
 	defer sm.mu.RUnlock()
 
@@ -49,7 +58,7 @@ func (sm *InMemoryStateManager) Get(key string) (int64, error) {
 	return ent.value, nil
 }
 
-func (sm *InMemoryStateManager) Set(key string, value int64, expireTime time.Duration) (string, error) {
+func (sm *InMemoryStateManager) Set(_ context.Context, key string, value int64, expireTime time.Duration) (string, error) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
@@ -66,7 +75,7 @@ func (sm *InMemoryStateManager) Set(key string, value int64, expireTime time.Dur
 	return key, nil
 }
 
-func (sm *InMemoryStateManager) Decr(key string) (int64, error) {
+func (sm *InMemoryStateManager) Decr(_ context.Context, key string) (int64, error) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
@@ -85,7 +94,7 @@ func (sm *InMemoryStateManager) Decr(key string) (int64, error) {
 	return newValue, nil
 }
 
-func (sm *InMemoryStateManager) Incr(key string) (int64, error) {
+func (sm *InMemoryStateManager) Incr(_ context.Context, key string) (int64, error) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
@@ -106,11 +115,42 @@ func (sm *InMemoryStateManager) Incr(key string) (int64, error) {
 	return newValue, nil
 }
 
+// Len returns the number of entries held, including expired ones not yet
+// evicted.
+func (sm *InMemoryStateManager) Len() int {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	return len(sm.store)
+}
+
+// HealthCheck fails once the manager has been closed.
+func (sm *InMemoryStateManager) HealthCheck(context.Context) error {
+	select {
+	case <-sm.done:
+		return errors.New("state manager is closed")
+	default:
+		return nil
+	}
+}
+
+// Close stops the eviction goroutine. It is safe to call more than once.
+func (sm *InMemoryStateManager) Close() error {
+	sm.closeOnce.Do(func() { close(sm.done) })
+	return nil
+}
+
 func (sm *InMemoryStateManager) cleanup() {
 	ticker := time.NewTicker(1 * time.Minute)
 	defer ticker.Stop()
 
-	for range ticker.C {
+	for {
+		select {
+		case <-sm.done:
+			return
+		case <-ticker.C:
+		}
+
 		sm.mu.Lock()
 		now := time.Now()
 		for key, entry := range sm.store {
@@ -0,0 +1,56 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/v2/event"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type mongoCommandKey struct {
+	connectionID string
+	requestID    int64
+}
+
+// MongoMonitor returns a command monitor that records a client span for
+// every MongoDB command, as a child of the span in the context of the
+// operation that issued it.
+func MongoMonitor() *event.CommandMonitor {
+	var spans sync.Map // mongoCommandKey -> trace.Span
+
+	finish := func(e *event.CommandFinishedEvent, err error) {
+		v, ok := spans.LoadAndDelete(mongoCommandKey{e.ConnectionID, e.RequestID})
+		if !ok {
+			return
+		}
+		span := v.(trace.Span)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, e *event.CommandStartedEvent) {
+			_, span := Tracer().Start(ctx, "mongo."+e.CommandName,
+				trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithAttributes(
+					semconv.DBSystemNameMongoDB,
+					semconv.DBNamespace(e.DatabaseName),
+					semconv.DBOperationName(e.CommandName),
+				),
+			)
+			spans.Store(mongoCommandKey{e.ConnectionID, e.RequestID}, span)
+		},
+		Succeeded: func(_ context.Context, e *event.CommandSucceededEvent) {
+			finish(&e.CommandFinishedEvent, nil)
+		},
+		Failed: func(_ context.Context, e *event.CommandFailedEvent) {
+			finish(&e.CommandFinishedEvent, e.Failure)
+		},
+	}
+}
@@ -0,0 +1,51 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+
+	"github.com/epicmet/dekamond-task/internal/otp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type tracedOTP struct {
+	otp.OTPProvider
+	name string
+}
+
+// InstrumentOTPProvider wraps p so that sending and checking codes are
+// traced. Phone numbers and codes are never recorded.
+func InstrumentOTPProvider(name string, p otp.OTPProvider) otp.OTPProvider {
+	return &tracedOTP{OTPProvider: p, name: name}
+}
+
+func (p *tracedOTP) Send(ctx context.Context, to otp.Recipient) (*otp.Delivery, error) {
+	ctx, span := Tracer().Start(ctx, "otp.send", trace.WithAttributes(attribute.String("otp.provider", p.name)))
+	defer span.End()
+
+	delivery, err := p.OTPProvider.Send(ctx, to)
+	switch {
+	case errors.Is(err, otp.ErrResendTooSoon):
+		span.SetAttributes(attribute.Bool("otp.cooldown", true))
+	case err != nil:
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	default:
+		span.SetAttributes(attribute.String("otp.locale", delivery.Locale))
+	}
+	return delivery, err
+}
+
+func (p *tracedOTP) Check(ctx context.Context, pn string, code string) error {
+	ctx, span := Tracer().Start(ctx, "otp.check", trace.WithAttributes(attribute.String("otp.provider", p.name)))
+	defer span.End()
+
+	err := p.OTPProvider.Check(ctx, pn, code)
+	span.SetAttributes(attribute.Bool("otp.valid", err == nil))
+	if err != nil {
+		span.SetAttributes(attribute.String("otp.error", err.Error()))
+	}
+	return err
+}
@@ -0,0 +1,84 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/epicmet/dekamond-task/internal/otp"
+	"go.mongodb.org/mongo-driver/v2/event"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+	return recorder
+}
+
+func TestMongoMonitor(t *testing.T) {
+	recorder := recordSpans(t)
+	monitor := MongoMonitor()
+
+	ctx, parent := Tracer().Start(t.Context(), "users.FindByPhone")
+	for i, failure := range []error{nil, errors.New("boom")} {
+		finished := event.CommandFinishedEvent{CommandName: "find", ConnectionID: "conn", RequestID: int64(i)}
+		monitor.Started(ctx, &event.CommandStartedEvent{CommandName: "find", DatabaseName: "db", ConnectionID: "conn", RequestID: int64(i)})
+		if failure == nil {
+			monitor.Succeeded(ctx, &event.CommandSucceededEvent{CommandFinishedEvent: finished})
+		} else {
+			monitor.Failed(ctx, &event.CommandFailedEvent{CommandFinishedEvent: finished, Failure: failure})
+		}
+	}
+	parent.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("recorded %d spans, want 3", len(spans))
+	}
+	for i, wantStatus := range []codes.Code{codes.Unset, codes.Error} {
+		span := spans[i]
+		if span.Name() != "mongo.find" || span.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("span %d is %q with parent %s", i, span.Name(), span.Parent().SpanID())
+		}
+		if span.Status().Code != wantStatus {
+			t.Errorf("span %d has status %v, want %v", i, span.Status().Code, wantStatus)
+		}
+	}
+}
+
+type fakeOTP struct{}
+
+func (fakeOTP) Send(context.Context, otp.Recipient) (*otp.Delivery, error) {
+	return &otp.Delivery{}, nil
+}
+func (fakeOTP) HealthCheck(context.Context) error { return nil }
+func (fakeOTP) Check(_ context.Context, _, code string) error {
+	if code != "123456" {
+		return otp.ErrOTPMismatch
+	}
+	return nil
+}
+
+func TestInstrumentOTPProvider(t *testing.T) {
+	recorder := recordSpans(t)
+	p := InstrumentOTPProvider("fake", fakeOTP{})
+
+	p.Send(t.Context(), otp.Recipient{Phone: "09120000000"})
+	p.Check(t.Context(), "09120000000", "000000")
+
+	spans := recorder.Ended()
+	if len(spans) != 2 || spans[0].Name() != "otp.send" || spans[1].Name() != "otp.check" {
+		t.Fatalf("recorded %v", spans)
+	}
+	for _, attr := range spans[1].Attributes() {
+		if attr.Key == "otp.valid" && attr.Value.AsBool() {
+			t.Error("a wrong code was recorded as valid")
+		}
+	}
+}
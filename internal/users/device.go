@@ -0,0 +1,16 @@
+package users
+
+import "time"
+
+// Device is a client a user trusted when logging in with an OTP. It proves
+// to be the same client by signing challenges with the private key of
+// PublicKey, which lets it log in without an OTP until TrustedUntil. IDs are
+// chosen by the client and unique per user.
+type Device struct {
+	ID           string     `json:"id" bson:"id"`
+	Name         string     `json:"name,omitempty" bson:"name,omitempty"`
+	PublicKey    []byte     `json:"-" bson:"public_key"`
+	CreatedAt    time.Time  `json:"created_at" bson:"created_at"`
+	LastUsedAt   *time.Time `json:"last_used_at,omitempty" bson:"last_used_at,omitempty"`
+	TrustedUntil time.Time  `json:"trusted_until" bson:"trusted_until"`
+}
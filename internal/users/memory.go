@@ -0,0 +1,546 @@
+package users
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// MemoryUserRepository keeps users in memory. It is meant for tests and
+// local development; nothing survives a restart. Operations never block, so
+// their contexts are ignored.
+type MemoryUserRepository struct {
+	users         map[bson.ObjectID]User
+	recoveryCodes map[bson.ObjectID][]string
+	passkeys      map[bson.ObjectID][]Passkey
+	devices       map[bson.ObjectID][]Device
+	sessions      map[bson.ObjectID][]Session
+	mu            sync.RWMutex
+}
+
+func NewMemoryUserRepository() *MemoryUserRepository {
+	return &MemoryUserRepository{
+		users:         make(map[bson.ObjectID]User),
+		recoveryCodes: make(map[bson.ObjectID][]string),
+		passkeys:      make(map[bson.ObjectID][]Passkey),
+		devices:       make(map[bson.ObjectID][]Device),
+		sessions:      make(map[bson.ObjectID][]Session),
+	}
+}
+
+func (r *MemoryUserRepository) Close(context.Context) error {
+	return nil
+}
+
+func (r *MemoryUserRepository) HealthCheck(context.Context) error {
+	return nil
+}
+
+func (r *MemoryUserRepository) Create(ctx context.Context, phoneNumber string) (*User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.findByPhone(phoneNumber); ok {
+		return nil, ErrDuplicatePhone
+	}
+
+	now := time.Now().UTC()
+	user := User{
+		ID:           bson.NewObjectID(),
+		PhoneNumber:  phoneNumber,
+		Role:         RoleUser,
+		Status:       StatusActive,
+		RegisteredAt: now,
+		UpdatedAt:    now,
+	}
+	r.users[user.ID] = user
+
+	return &user, nil
+}
+
+func (r *MemoryUserRepository) FindByID(ctx context.Context, id string) (*User, error) {
+	objectID, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrInvalidID
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	user, ok := r.users[objectID]
+	if !ok || user.DeletedAt != nil {
+		return nil, ErrUserNotFound
+	}
+
+	return &user, nil
+}
+
+func (r *MemoryUserRepository) FindByPhone(ctx context.Context, phoneNumber string) (*User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	user, ok := r.findByPhone(phoneNumber)
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+
+	return &user, nil
+}
+
+// findByPhone returns the user that isn't deleted with the given phone
+// number. The caller must hold the lock.
+func (r *MemoryUserRepository) findByPhone(phoneNumber string) (User, bool) {
+	for _, user := range r.users {
+		if user.PhoneNumber == phoneNumber && user.DeletedAt == nil {
+			return user, true
+		}
+	}
+	return User{}, false
+}
+
+func (r *MemoryUserRepository) Upsert(ctx context.Context, phoneNumber string) (*User, error) {
+	possibleUser, err := r.FindByPhone(ctx, phoneNumber)
+	if err != nil {
+		if !errors.Is(err, ErrUserNotFound) {
+			return nil, err
+		}
+
+		createdUser, err := r.Create(ctx, phoneNumber)
+		if errors.Is(err, ErrDuplicatePhone) {
+			// Lost a race with a concurrent registration of the same number.
+			return r.FindByPhone(ctx, phoneNumber)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return createdUser, nil
+	}
+
+	return possibleUser, nil
+}
+
+func (r *MemoryUserRepository) UpdateProfile(ctx context.Context, id string, patch ProfilePatch) (*User, error) {
+	return r.update(id, false, func(user *User) error {
+		for field, value := range patch {
+			var v string
+			if value != nil {
+				v = *value
+			}
+
+			switch field {
+			case "first_name":
+				user.FirstName = v
+			case "last_name":
+				user.LastName = v
+			case "email":
+				user.Email = v
+				user.EmailVerifiedAt = nil
+			case "avatar_url":
+				user.AvatarURL = v
+			case "locale":
+				user.Locale = v
+			case "timezone":
+				user.Timezone = v
+			default:
+				return &ValidationError{Field: field, Message: "unknown or read-only field"}
+			}
+		}
+		return nil
+	})
+}
+
+func (r *MemoryUserRepository) VerifyEmail(ctx context.Context, id string, email string) (*User, error) {
+	return r.update(id, false, func(user *User) error {
+		if user.Email == "" || user.Email != email {
+			return ErrConflict
+		}
+		now := time.Now().UTC()
+		user.EmailVerifiedAt = &now
+		return nil
+	})
+}
+
+func (r *MemoryUserRepository) Delete(ctx context.Context, id string) error {
+	_, err := r.update(id, false, func(user *User) error {
+		now := time.Now().UTC()
+		user.DeletedAt = &now
+		return nil
+	})
+	return err
+}
+
+func (r *MemoryUserRepository) Restore(ctx context.Context, id string) (*User, error) {
+	user, err := r.update(id, true, func(user *User) error {
+		if _, ok := r.findByPhone(user.PhoneNumber); ok {
+			return ErrDuplicatePhone
+		}
+		user.DeletedAt = nil
+		return nil
+	})
+	if errors.Is(err, ErrUserNotFound) {
+		if _, findErr := r.FindByID(ctx, id); findErr == nil {
+			return nil, ErrConflict
+		}
+	}
+
+	return user, err
+}
+
+func (r *MemoryUserRepository) SetStatus(ctx context.Context, id string, change StatusChange) (*User, error) {
+	return r.update(id, false, func(user *User) error {
+		user.Status = change.Status
+		user.StatusReason = change.Reason
+		user.StatusExpiresAt = nil
+		if change.ExpiresAt != nil {
+			t := change.ExpiresAt.UTC()
+			user.StatusExpiresAt = &t
+		}
+		return nil
+	})
+}
+
+func (r *MemoryUserRepository) SetRole(ctx context.Context, id string, role Role) (*User, error) {
+	return r.update(id, false, func(user *User) error {
+		user.Role = role
+		return nil
+	})
+}
+
+func (r *MemoryUserRepository) SetTOTP(ctx context.Context, id string, totp *TOTP, recoveryCodeHashes []string) (*User, error) {
+	return r.update(id, false, func(user *User) error {
+		user.TOTP = nil
+		delete(r.recoveryCodes, user.ID)
+		if totp != nil {
+			t := *totp
+			user.TOTP = &t
+			r.recoveryCodes[user.ID] = slices.Clone(recoveryCodeHashes)
+		}
+		return nil
+	})
+}
+
+func (r *MemoryUserRepository) UseTOTPStep(ctx context.Context, id string, step int64) error {
+	return r.use(id, func(user *User) error {
+		if user.TOTP == nil || user.TOTP.LastStep >= step {
+			return ErrConflict
+		}
+		t := *user.TOTP
+		t.LastStep = step
+		user.TOTP = &t
+		return nil
+	})
+}
+
+func (r *MemoryUserRepository) UseRecoveryCode(ctx context.Context, id string, hash string) error {
+	return r.use(id, func(user *User) error {
+		codes := r.recoveryCodes[user.ID]
+		i := slices.Index(codes, hash)
+		if i < 0 {
+			return ErrConflict
+		}
+		r.recoveryCodes[user.ID] = slices.Delete(slices.Clone(codes), i, i+1)
+		return nil
+	})
+}
+
+func (r *MemoryUserRepository) Passkeys(ctx context.Context, id string) ([]Passkey, error) {
+	user, err := r.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return slices.Clone(r.passkeys[user.ID]), nil
+}
+
+func (r *MemoryUserRepository) AddPasskey(ctx context.Context, id string, passkey Passkey) error {
+	return r.use(id, func(user *User) error {
+		for _, passkeys := range r.passkeys {
+			if slices.ContainsFunc(passkeys, func(p Passkey) bool { return bytes.Equal(p.ID, passkey.ID) }) {
+				return ErrConflict
+			}
+		}
+		r.passkeys[user.ID] = append(slices.Clone(r.passkeys[user.ID]), passkey)
+		return nil
+	})
+}
+
+func (r *MemoryUserRepository) UsePasskey(ctx context.Context, id string, passkeyID []byte, signCount uint32) error {
+	return r.use(id, func(user *User) error {
+		passkeys := slices.Clone(r.passkeys[user.ID])
+		i := slices.IndexFunc(passkeys, func(p Passkey) bool { return bytes.Equal(p.ID, passkeyID) })
+		if i < 0 {
+			return ErrConflict
+		}
+		now := time.Now().UTC()
+		passkeys[i].SignCount = signCount
+		passkeys[i].LastUsedAt = &now
+		r.passkeys[user.ID] = passkeys
+		return nil
+	})
+}
+
+func (r *MemoryUserRepository) Devices(ctx context.Context, id string) ([]Device, error) {
+	user, err := r.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return slices.Clone(r.devices[user.ID]), nil
+}
+
+func (r *MemoryUserRepository) TrustDevice(ctx context.Context, id string, device Device) error {
+	return r.use(id, func(user *User) error {
+		devices := slices.Clone(r.devices[user.ID])
+		i := slices.IndexFunc(devices, func(d Device) bool { return d.ID == device.ID })
+		if i < 0 {
+			r.devices[user.ID] = append(devices, device)
+			return nil
+		}
+		devices[i].Name = device.Name
+		devices[i].PublicKey = device.PublicKey
+		devices[i].TrustedUntil = device.TrustedUntil
+		r.devices[user.ID] = devices
+		return nil
+	})
+}
+
+func (r *MemoryUserRepository) UseDevice(ctx context.Context, id string, deviceID string) error {
+	return r.use(id, func(user *User) error {
+		devices := slices.Clone(r.devices[user.ID])
+		i := slices.IndexFunc(devices, func(d Device) bool { return d.ID == deviceID })
+		if i < 0 {
+			return ErrConflict
+		}
+		now := time.Now().UTC()
+		devices[i].LastUsedAt = &now
+		r.devices[user.ID] = devices
+		return nil
+	})
+}
+
+func (r *MemoryUserRepository) DeleteDevice(ctx context.Context, id string, deviceID string) error {
+	return r.use(id, func(user *User) error {
+		devices := r.devices[user.ID]
+		i := slices.IndexFunc(devices, func(d Device) bool { return d.ID == deviceID })
+		if i < 0 {
+			return ErrConflict
+		}
+		r.devices[user.ID] = slices.Delete(slices.Clone(devices), i, i+1)
+		return nil
+	})
+}
+
+func (r *MemoryUserRepository) Sessions(ctx context.Context, id string) ([]Session, error) {
+	user, err := r.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	now := time.Now()
+	sessions := []Session{}
+	for _, s := range r.sessions[user.ID] {
+		if s.ExpiresAt.After(now) {
+			sessions = append(sessions, s)
+		}
+	}
+	return sessions, nil
+}
+
+func (r *MemoryUserRepository) CreateSession(ctx context.Context, id string, session Session) error {
+	return r.use(id, func(user *User) error {
+		now := time.Now()
+		sessions := slices.DeleteFunc(slices.Clone(r.sessions[user.ID]), func(s Session) bool { return !s.ExpiresAt.After(now) })
+		r.sessions[user.ID] = append(sessions, session)
+		return nil
+	})
+}
+
+func (r *MemoryUserRepository) TouchSession(ctx context.Context, id string, sessionID string) error {
+	return r.use(id, func(user *User) error {
+		now := time.Now().UTC()
+		sessions := slices.Clone(r.sessions[user.ID])
+		i := slices.IndexFunc(sessions, func(s Session) bool { return s.ID == sessionID && s.ExpiresAt.After(now) })
+		if i < 0 {
+			return ErrConflict
+		}
+		sessions[i].LastSeenAt = now
+		r.sessions[user.ID] = sessions
+		return nil
+	})
+}
+
+func (r *MemoryUserRepository) DeleteSession(ctx context.Context, id string, sessionID string) error {
+	return r.use(id, func(user *User) error {
+		sessions := r.sessions[user.ID]
+		i := slices.IndexFunc(sessions, func(s Session) bool { return s.ID == sessionID })
+		if i < 0 {
+			return ErrConflict
+		}
+		r.sessions[user.ID] = slices.Delete(slices.Clone(sessions), i, i+1)
+		return nil
+	})
+}
+
+func (r *MemoryUserRepository) DeleteSessions(ctx context.Context, id string) error {
+	return r.use(id, func(user *User) error {
+		delete(r.sessions, user.ID)
+		return nil
+	})
+}
+
+// use applies fn to the user with the given ID, which must not be deleted,
+// without bumping its updated_at as signing in isn't a change of the user.
+func (r *MemoryUserRepository) use(id string, fn func(user *User) error) error {
+	objectID, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrInvalidID
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[objectID]
+	if !ok || user.DeletedAt != nil {
+		return ErrUserNotFound
+	}
+
+	if err := fn(&user); err != nil {
+		return err
+	}
+	r.users[objectID] = user
+
+	return nil
+}
+
+// update applies fn to the user with the given ID, which must be deleted or
+// not as requested, and bumps its updated_at.
+func (r *MemoryUserRepository) update(id string, deleted bool, fn func(user *User) error) (*User, error) {
+	objectID, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrInvalidID
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[objectID]
+	if !ok || (user.DeletedAt != nil) != deleted {
+		return nil, ErrUserNotFound
+	}
+
+	if err := fn(&user); err != nil {
+		return nil, err
+	}
+	user.UpdatedAt = time.Now().UTC()
+	r.users[objectID] = user
+
+	return &user, nil
+}
+
+func (r *MemoryUserRepository) Purge(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var purged int64
+	for id, user := range r.users {
+		if user.DeletedAt != nil && user.DeletedAt.Before(deletedBefore) {
+			delete(r.users, id)
+			delete(r.recoveryCodes, id)
+			delete(r.passkeys, id)
+			delete(r.devices, id)
+			delete(r.sessions, id)
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+func (r *MemoryUserRepository) SearchByPhone(ctx context.Context, phonePrefix string, query UserQuery) (*PaginatedUsers, error) {
+	return r.findPaginated(phonePrefix, query), nil
+}
+
+func (r *MemoryUserRepository) GetAll(ctx context.Context, query UserQuery) (*PaginatedUsers, error) {
+	return r.findPaginated("", query), nil
+}
+
+func (r *MemoryUserRepository) findPaginated(phonePrefix string, query UserQuery) *PaginatedUsers {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	now := time.Now()
+	matched := []User{}
+	for _, user := range r.users {
+		if user.DeletedAt == nil && strings.HasPrefix(user.PhoneNumber, phonePrefix) && matchesQuery(&user, query, now) {
+			matched = append(matched, user)
+		}
+	}
+
+	sortFields := query.Sort
+	if len(sortFields) == 0 {
+		sortFields = DefaultSort
+	}
+	slices.SortFunc(matched, func(a, b User) int {
+		for _, f := range sortFields {
+			var c int
+			switch f.Field {
+			case "registered_at":
+				c = a.RegisteredAt.Compare(b.RegisteredAt)
+			case "phone_number":
+				c = cmp.Compare(a.PhoneNumber, b.PhoneNumber)
+			}
+			if f.Desc {
+				c = -c
+			}
+			if c != 0 {
+				return c
+			}
+		}
+		return cmp.Compare(a.ID.Hex(), b.ID.Hex())
+	})
+
+	totalCount := int64(len(matched))
+	start := min((query.Page-1)*query.PageSize, len(matched))
+	end := min(start+query.PageSize, len(matched))
+
+	return &PaginatedUsers{
+		Users:      slices.Clone(matched[start:end]),
+		Page:       query.Page,
+		PageSize:   query.PageSize,
+		TotalCount: totalCount,
+		TotalPages: totalPages(totalCount, query.PageSize),
+	}
+}
+
+func matchesQuery(user *User, query UserQuery, now time.Time) bool {
+	if query.RegisteredAfter != nil && !user.RegisteredAt.After(*query.RegisteredAfter) {
+		return false
+	}
+	if query.RegisteredBefore != nil && !user.RegisteredAt.Before(*query.RegisteredBefore) {
+		return false
+	}
+	if query.Status != "" && user.EffectiveStatus(now) != query.Status {
+		return false
+	}
+	if query.Role != "" && user.EffectiveRole() != query.Role {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,20 @@
+package users_test
+
+import (
+	"testing"
+
+	"github.com/epicmet/dekamond-task/internal/users"
+	"github.com/epicmet/dekamond-task/internal/users/userstest"
+)
+
+func TestMemoryUserRepository(t *testing.T) {
+	userstest.Run(t, func(t *testing.T) users.UserRepository {
+		return users.NewMemoryUserRepository()
+	})
+}
+
+func TestTracedUserRepository(t *testing.T) {
+	userstest.Run(t, func(t *testing.T) users.UserRepository {
+		return users.WithTracing(users.NewMemoryUserRepository())
+	})
+}
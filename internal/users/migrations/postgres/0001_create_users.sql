@@ -0,0 +1,25 @@
+CREATE TABLE users (
+    id                CHAR(24) PRIMARY KEY,
+    phone_number      TEXT NOT NULL,
+    first_name        TEXT,
+    last_name         TEXT,
+    email             TEXT,
+    avatar_url        TEXT,
+    locale            TEXT,
+    timezone          TEXT,
+    role              TEXT NOT NULL DEFAULT 'user',
+    status            TEXT NOT NULL DEFAULT 'active',
+    status_reason     TEXT,
+    status_expires_at TIMESTAMPTZ,
+    registered_at     TIMESTAMPTZ NOT NULL,
+    updated_at        TIMESTAMPTZ NOT NULL,
+    deleted_at        TIMESTAMPTZ
+);
+
+-- Phone numbers are unique among users that are not deleted, so a deleted
+-- number can register again.
+CREATE UNIQUE INDEX users_phone_number_key ON users (phone_number) WHERE deleted_at IS NULL;
+
+CREATE INDEX users_phone_number_prefix_idx ON users (phone_number text_pattern_ops);
+CREATE INDEX users_registered_at_idx ON users (registered_at);
+CREATE INDEX users_deleted_at_idx ON users (deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,11 @@
+ALTER TABLE users
+    ADD COLUMN totp_secret       TEXT,
+    ADD COLUMN totp_confirmed_at TIMESTAMPTZ,
+    ADD COLUMN totp_last_step    BIGINT;
+
+-- Recovery codes are stored as SHA-256 hashes and deleted once used.
+CREATE TABLE user_recovery_codes (
+    user_id   CHAR(24) NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    code_hash TEXT NOT NULL,
+    PRIMARY KEY (user_id, code_hash)
+);
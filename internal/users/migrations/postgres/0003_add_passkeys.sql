@@ -0,0 +1,14 @@
+CREATE TABLE user_passkeys (
+    id               BYTEA PRIMARY KEY,
+    user_id          CHAR(24) NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    public_key       BYTEA NOT NULL,
+    attestation_type TEXT NOT NULL,
+    transports       TEXT NOT NULL,
+    aaguid           BYTEA NOT NULL,
+    sign_count       BIGINT NOT NULL,
+    backup_eligible  BOOLEAN NOT NULL,
+    created_at       TIMESTAMPTZ NOT NULL,
+    last_used_at     TIMESTAMPTZ
+);
+
+CREATE INDEX user_passkeys_user_id ON user_passkeys (user_id);
@@ -0,0 +1,10 @@
+CREATE TABLE user_devices (
+    user_id       CHAR(24) NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    id            TEXT NOT NULL,
+    name          TEXT NOT NULL,
+    public_key    BYTEA NOT NULL,
+    created_at    TIMESTAMPTZ NOT NULL,
+    last_used_at  TIMESTAMPTZ,
+    trusted_until TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (user_id, id)
+);
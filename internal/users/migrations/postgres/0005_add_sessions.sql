@@ -0,0 +1,12 @@
+CREATE TABLE user_sessions (
+    id           TEXT PRIMARY KEY,
+    user_id      CHAR(24) NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    user_agent   TEXT NOT NULL,
+    ip           TEXT NOT NULL,
+    device_id    TEXT NOT NULL,
+    created_at   TIMESTAMPTZ NOT NULL,
+    last_seen_at TIMESTAMPTZ NOT NULL,
+    expires_at   TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX user_sessions_user_id ON user_sessions (user_id);
@@ -0,0 +1,26 @@
+-- Times are stored as fixed width UTC text (see sqliteTime) so that they
+-- compare correctly as strings.
+CREATE TABLE users (
+    id                TEXT PRIMARY KEY,
+    phone_number      TEXT NOT NULL,
+    first_name        TEXT,
+    last_name         TEXT,
+    email             TEXT,
+    avatar_url        TEXT,
+    locale            TEXT,
+    timezone          TEXT,
+    role              TEXT NOT NULL DEFAULT 'user',
+    status            TEXT NOT NULL DEFAULT 'active',
+    status_reason     TEXT,
+    status_expires_at DATETIME,
+    registered_at     DATETIME NOT NULL,
+    updated_at        DATETIME NOT NULL,
+    deleted_at        DATETIME
+);
+
+-- Phone numbers are unique among users that are not deleted, so a deleted
+-- number can register again.
+CREATE UNIQUE INDEX users_phone_number_key ON users (phone_number) WHERE deleted_at IS NULL;
+
+CREATE INDEX users_registered_at_idx ON users (registered_at);
+CREATE INDEX users_deleted_at_idx ON users (deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,10 @@
+ALTER TABLE users ADD COLUMN totp_secret TEXT;
+ALTER TABLE users ADD COLUMN totp_confirmed_at DATETIME;
+ALTER TABLE users ADD COLUMN totp_last_step INTEGER;
+
+-- Recovery codes are stored as SHA-256 hashes and deleted once used.
+CREATE TABLE user_recovery_codes (
+    user_id   TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    code_hash TEXT NOT NULL,
+    PRIMARY KEY (user_id, code_hash)
+);
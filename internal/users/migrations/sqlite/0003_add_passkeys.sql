@@ -0,0 +1,14 @@
+CREATE TABLE user_passkeys (
+    id               BLOB PRIMARY KEY,
+    user_id          TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    public_key       BLOB NOT NULL,
+    attestation_type TEXT NOT NULL,
+    transports       TEXT NOT NULL,
+    aaguid           BLOB NOT NULL,
+    sign_count       INTEGER NOT NULL,
+    backup_eligible  BOOLEAN NOT NULL,
+    created_at       DATETIME NOT NULL,
+    last_used_at     DATETIME
+);
+
+CREATE INDEX user_passkeys_user_id ON user_passkeys (user_id);
@@ -0,0 +1,10 @@
+CREATE TABLE user_devices (
+    user_id       TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    id            TEXT NOT NULL,
+    name          TEXT NOT NULL,
+    public_key    BLOB NOT NULL,
+    created_at    DATETIME NOT NULL,
+    last_used_at  DATETIME,
+    trusted_until DATETIME NOT NULL,
+    PRIMARY KEY (user_id, id)
+);
@@ -0,0 +1,12 @@
+CREATE TABLE user_sessions (
+    id           TEXT PRIMARY KEY,
+    user_id      TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    user_agent   TEXT NOT NULL,
+    ip           TEXT NOT NULL,
+    device_id    TEXT NOT NULL,
+    created_at   DATETIME NOT NULL,
+    last_seen_at DATETIME NOT NULL,
+    expires_at   DATETIME NOT NULL
+);
+
+CREATE INDEX user_sessions_user_id ON user_sessions (user_id);
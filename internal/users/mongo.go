@@ -5,56 +5,130 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"regexp"
 	"time"
 
+	"github.com/epicmet/dekamond-task/internal/metrics"
+	"github.com/epicmet/dekamond-task/internal/tracing"
 	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/event"
 	"go.mongodb.org/mongo-driver/v2/mongo"
 	"go.mongodb.org/mongo-driver/v2/mongo/options"
 )
 
 type MongoUserRepository struct {
+	client     *mongo.Client
 	collection *mongo.Collection
+	timeout    time.Duration
 }
 
-func NewMongoUserRepository(mongoURI, dbName string) (*MongoUserRepository, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	client, err := mongo.Connect(options.Client().ApplyURI(mongoURI))
+// NewMongoUserRepository connects to MongoDB and prepares the users
+// collection within ctx. Every later operation is bounded by timeout on top
+// of the deadline of its own context.
+func NewMongoUserRepository(ctx context.Context, mongoURI, dbName string, timeout time.Duration) (*MongoUserRepository, error) {
+	monitor := combineMonitors(metrics.MongoMonitor(), tracing.MongoMonitor())
+	client, err := mongo.Connect(options.Client().ApplyURI(mongoURI).SetMonitor(monitor))
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to MongoDB: %w", err)
 	}
 
 	err = client.Ping(ctx, nil)
 	if err != nil {
+		client.Disconnect(context.Background())
 		return nil, fmt.Errorf("failed to ping MongoDB: %w", err)
 	}
 
 	collection := client.Database(dbName).Collection("users")
 
+	// Phone numbers are unique among users that are not deleted. Soft deleted
+	// users each carry a distinct deleted_at, so their number can be
+	// registered again.
+	err = collection.Indexes().DropOne(ctx, "phone_number_1")
+	if err != nil && !isIndexNotFound(err) {
+		client.Disconnect(context.Background())
+		return nil, fmt.Errorf("failed to drop legacy index: %w", err)
+	}
+
 	indexModel := mongo.IndexModel{
-		Keys:    bson.D{{"phone_number", 1}},
+		Keys:    bson.D{{Key: "phone_number", Value: 1}, {Key: "deleted_at", Value: 1}},
 		Options: options.Index().SetUnique(true),
 	}
 	_, err = collection.Indexes().CreateOne(ctx, indexModel)
 	if err != nil {
+		client.Disconnect(context.Background())
+		return nil, fmt.Errorf("failed to create index: %w", err)
+	}
+
+	// Passkey IDs are unique across users. Users without passkeys have no
+	// passkeys field, which the partial filter leaves out of the index.
+	indexModel = mongo.IndexModel{
+		Keys: bson.D{{Key: "passkeys.id", Value: 1}},
+		Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"passkeys.id": bson.M{"$exists": true}}),
+	}
+	_, err = collection.Indexes().CreateOne(ctx, indexModel)
+	if err != nil {
+		client.Disconnect(context.Background())
 		return nil, fmt.Errorf("failed to create index: %w", err)
 	}
 
-	return &MongoUserRepository{collection: collection}, nil
+	return &MongoUserRepository{client: client, collection: collection, timeout: timeout}, nil
+}
+
+// combineMonitors returns a command monitor forwarding every event to each
+// of monitors, as the driver accepts a single one.
+func combineMonitors(monitors ...*event.CommandMonitor) *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, e *event.CommandStartedEvent) {
+			for _, m := range monitors {
+				if m.Started != nil {
+					m.Started(ctx, e)
+				}
+			}
+		},
+		Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+			for _, m := range monitors {
+				if m.Succeeded != nil {
+					m.Succeeded(ctx, e)
+				}
+			}
+		},
+		Failed: func(ctx context.Context, e *event.CommandFailedEvent) {
+			for _, m := range monitors {
+				if m.Failed != nil {
+					m.Failed(ctx, e)
+				}
+			}
+		},
+	}
+}
+
+func (r *MongoUserRepository) Close(ctx context.Context) error {
+	return r.client.Disconnect(ctx)
 }
 
-func (r *MongoUserRepository) Create(phoneNumber string) (*User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (r *MongoUserRepository) HealthCheck(ctx context.Context) error {
+	return r.client.Ping(ctx, nil)
+}
+
+func (r *MongoUserRepository) Create(ctx context.Context, phoneNumber string) (*User, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
 	defer cancel()
 
+	now := time.Now()
 	user := &User{
 		PhoneNumber:  phoneNumber,
-		RegisteredAt: time.Now(),
+		Role:         RoleUser,
+		Status:       StatusActive,
+		RegisteredAt: now,
+		UpdatedAt:    now,
 	}
 
 	result, err := r.collection.InsertOne(ctx, user)
 	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrDuplicatePhone
+		}
 		return nil, fmt.Errorf("failed to create user: %w", err)
 	}
 
@@ -62,20 +136,20 @@ func (r *MongoUserRepository) Create(phoneNumber string) (*User, error) {
 	return user, nil
 }
 
-func (r *MongoUserRepository) FindByID(id string) (*User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (r *MongoUserRepository) FindByID(ctx context.Context, id string) (*User, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
 	defer cancel()
 
 	objectID, err := bson.ObjectIDFromHex(id)
 	if err != nil {
-		return nil, fmt.Errorf("invalid ID format: %w", err)
+		return nil, ErrInvalidID
 	}
 
 	var user User
-	err = r.collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&user)
+	err = r.collection.FindOne(ctx, bson.M{"_id": objectID, "deleted_at": nil}).Decode(&user)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			return nil, fmt.Errorf("user not found")
+			return nil, ErrUserNotFound
 		}
 		return nil, fmt.Errorf("failed to find user: %w", err)
 	}
@@ -83,12 +157,12 @@ func (r *MongoUserRepository) FindByID(id string) (*User, error) {
 	return &user, nil
 }
 
-func (r *MongoUserRepository) FindByPhone(phoneNumber string) (*User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (r *MongoUserRepository) FindByPhone(ctx context.Context, phoneNumber string) (*User, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
 	defer cancel()
 
 	var user User
-	err := r.collection.FindOne(ctx, bson.M{"phone_number": phoneNumber}).Decode(&user)
+	err := r.collection.FindOne(ctx, bson.M{"phone_number": phoneNumber, "deleted_at": nil}).Decode(&user)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return nil, ErrUserNotFound
@@ -99,14 +173,18 @@ func (r *MongoUserRepository) FindByPhone(phoneNumber string) (*User, error) {
 	return &user, nil
 }
 
-func (r *MongoUserRepository) Upsert(phoneNumber string) (*User, error) {
-	possibleUser, err := r.FindByPhone(phoneNumber)
+func (r *MongoUserRepository) Upsert(ctx context.Context, phoneNumber string) (*User, error) {
+	possibleUser, err := r.FindByPhone(ctx, phoneNumber)
 	if err != nil {
 		if !errors.Is(err, ErrUserNotFound) {
 			return nil, err
 		}
 
-		createdUser, err := r.Create(phoneNumber)
+		createdUser, err := r.Create(ctx, phoneNumber)
+		if errors.Is(err, ErrDuplicatePhone) {
+			// Lost a race with a concurrent registration of the same number.
+			return r.FindByPhone(ctx, phoneNumber)
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -117,25 +195,677 @@ func (r *MongoUserRepository) Upsert(phoneNumber string) (*User, error) {
 	return possibleUser, nil
 }
 
-func (r *MongoUserRepository) SearchByPhone(phonePrefix string, page, pageSize int) (*PaginatedUsers, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (r *MongoUserRepository) UpdateProfile(ctx context.Context, id string, patch ProfilePatch) (*User, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
 	defer cancel()
 
+	objectID, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrInvalidID
+	}
+
+	set := bson.M{"updated_at": time.Now()}
+	unset := bson.M{}
+	for field, value := range patch {
+		if value == nil {
+			unset[field] = ""
+			continue
+		}
+		set[field] = *value
+	}
+	if _, ok := patch["email"]; ok {
+		unset["email_verified_at"] = ""
+	}
+
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var user User
+	err = r.collection.FindOneAndUpdate(ctx, bson.M{"_id": objectID, "deleted_at": nil}, update, opts).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to update user: %w", err)
+	}
+
+	return &user, nil
+}
+
+func (r *MongoUserRepository) VerifyEmail(ctx context.Context, id string, email string) (*User, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	objectID, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrInvalidID
+	}
+
+	now := time.Now()
+	update := bson.M{"$set": bson.M{"email_verified_at": now, "updated_at": now}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var user User
+	err = r.collection.FindOneAndUpdate(ctx, bson.M{"_id": objectID, "deleted_at": nil, "email": email}, update, opts).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			if _, findErr := r.FindByID(ctx, id); findErr == nil {
+				return nil, ErrConflict
+			}
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to verify user email: %w", err)
+	}
+
+	return &user, nil
+}
+
+func (r *MongoUserRepository) Delete(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	objectID, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrInvalidID
+	}
+
+	now := time.Now()
+	update := bson.M{"$set": bson.M{"deleted_at": now, "updated_at": now}}
+
+	result, err := r.collection.UpdateOne(ctx, bson.M{"_id": objectID, "deleted_at": nil}, update)
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+func (r *MongoUserRepository) Restore(ctx context.Context, id string) (*User, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	objectID, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrInvalidID
+	}
+
+	update := bson.M{
+		"$set":   bson.M{"updated_at": time.Now()},
+		"$unset": bson.M{"deleted_at": ""},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var user User
+	err = r.collection.FindOneAndUpdate(ctx, bson.M{"_id": objectID, "deleted_at": bson.M{"$ne": nil}}, update, opts).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			if _, findErr := r.FindByID(ctx, id); findErr == nil {
+				return nil, ErrConflict
+			}
+			return nil, ErrUserNotFound
+		}
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrDuplicatePhone
+		}
+		return nil, fmt.Errorf("failed to restore user: %w", err)
+	}
+
+	return &user, nil
+}
+
+func (r *MongoUserRepository) SetStatus(ctx context.Context, id string, change StatusChange) (*User, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	objectID, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrInvalidID
+	}
+
+	set := bson.M{"status": change.Status, "updated_at": time.Now()}
+	unset := bson.M{}
+	if change.Reason != "" {
+		set["status_reason"] = change.Reason
+	} else {
+		unset["status_reason"] = ""
+	}
+	if change.ExpiresAt != nil {
+		set["status_expires_at"] = *change.ExpiresAt
+	} else {
+		unset["status_expires_at"] = ""
+	}
+
+	update := bson.M{"$set": set, "$unset": unset}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var user User
+	err = r.collection.FindOneAndUpdate(ctx, bson.M{"_id": objectID, "deleted_at": nil}, update, opts).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to update user status: %w", err)
+	}
+
+	return &user, nil
+}
+
+func (r *MongoUserRepository) SetRole(ctx context.Context, id string, role Role) (*User, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	objectID, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrInvalidID
+	}
+
+	update := bson.M{"$set": bson.M{"role": role, "updated_at": time.Now()}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var user User
+	err = r.collection.FindOneAndUpdate(ctx, bson.M{"_id": objectID, "deleted_at": nil}, update, opts).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to update user role: %w", err)
+	}
+
+	return &user, nil
+}
+
+// SetTOTP stores the recovery code hashes in the totp_recovery_codes field,
+// which isn't part of User.
+func (r *MongoUserRepository) SetTOTP(ctx context.Context, id string, totp *TOTP, recoveryCodeHashes []string) (*User, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	objectID, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrInvalidID
+	}
+
+	update := bson.M{
+		"$set":   bson.M{"updated_at": time.Now()},
+		"$unset": bson.M{"totp": "", "totp_recovery_codes": ""},
+	}
+	if totp != nil {
+		if recoveryCodeHashes == nil {
+			recoveryCodeHashes = []string{}
+		}
+		update = bson.M{"$set": bson.M{"totp": totp, "totp_recovery_codes": recoveryCodeHashes, "updated_at": time.Now()}}
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var user User
+	err = r.collection.FindOneAndUpdate(ctx, bson.M{"_id": objectID, "deleted_at": nil}, update, opts).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to update user TOTP: %w", err)
+	}
+
+	return &user, nil
+}
+
+func (r *MongoUserRepository) UseTOTPStep(ctx context.Context, id string, step int64) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	objectID, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrInvalidID
+	}
+
+	filter := bson.M{"_id": objectID, "deleted_at": nil, "totp.last_step": bson.M{"$lt": step}}
+	result, err := r.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"totp.last_step": step}})
+	if err != nil {
+		return fmt.Errorf("failed to update user TOTP: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return r.conflictOrNotFound(ctx, id)
+	}
+
+	return nil
+}
+
+func (r *MongoUserRepository) UseRecoveryCode(ctx context.Context, id string, hash string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	objectID, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrInvalidID
+	}
+
+	filter := bson.M{"_id": objectID, "deleted_at": nil, "totp_recovery_codes": hash}
+	result, err := r.collection.UpdateOne(ctx, filter, bson.M{"$pull": bson.M{"totp_recovery_codes": hash}})
+	if err != nil {
+		return fmt.Errorf("failed to use recovery code: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return r.conflictOrNotFound(ctx, id)
+	}
+
+	return nil
+}
+
+// Passkeys reads the passkeys field, which isn't part of User.
+func (r *MongoUserRepository) Passkeys(ctx context.Context, id string) ([]Passkey, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	objectID, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrInvalidID
+	}
+
+	var doc struct {
+		Passkeys []Passkey `bson:"passkeys"`
+	}
+	opts := options.FindOne().SetProjection(bson.M{"passkeys": 1})
+	err = r.collection.FindOne(ctx, bson.M{"_id": objectID, "deleted_at": nil}, opts).Decode(&doc)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to find passkeys: %w", err)
+	}
+
+	if doc.Passkeys == nil {
+		doc.Passkeys = []Passkey{}
+	}
+	return doc.Passkeys, nil
+}
+
+func (r *MongoUserRepository) AddPasskey(ctx context.Context, id string, passkey Passkey) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	objectID, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrInvalidID
+	}
+
+	// The unique index only spans documents, so the filter guards against
+	// a duplicate within the user's own passkeys.
+	filter := bson.M{"_id": objectID, "deleted_at": nil, "passkeys.id": bson.M{"$ne": passkey.ID}}
+	result, err := r.collection.UpdateOne(ctx, filter, bson.M{"$push": bson.M{"passkeys": passkey}})
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrConflict
+		}
+		return fmt.Errorf("failed to add passkey: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return r.conflictOrNotFound(ctx, id)
+	}
+
+	return nil
+}
+
+func (r *MongoUserRepository) UsePasskey(ctx context.Context, id string, passkeyID []byte, signCount uint32) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	objectID, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrInvalidID
+	}
+
+	filter := bson.M{"_id": objectID, "deleted_at": nil, "passkeys.id": passkeyID}
+	update := bson.M{"$set": bson.M{"passkeys.$.sign_count": signCount, "passkeys.$.last_used_at": time.Now().UTC()}}
+	result, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return fmt.Errorf("failed to update passkey: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return r.conflictOrNotFound(ctx, id)
+	}
+
+	return nil
+}
+
+// Devices reads the devices field, which isn't part of User.
+func (r *MongoUserRepository) Devices(ctx context.Context, id string) ([]Device, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	objectID, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrInvalidID
+	}
+
+	var doc struct {
+		Devices []Device `bson:"devices"`
+	}
+	opts := options.FindOne().SetProjection(bson.M{"devices": 1})
+	err = r.collection.FindOne(ctx, bson.M{"_id": objectID, "deleted_at": nil}, opts).Decode(&doc)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to find devices: %w", err)
+	}
+
+	if doc.Devices == nil {
+		doc.Devices = []Device{}
+	}
+	return doc.Devices, nil
+}
+
+func (r *MongoUserRepository) TrustDevice(ctx context.Context, id string, device Device) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	objectID, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrInvalidID
+	}
+
+	// Replace the device if the user has it, and add it otherwise. The
+	// filter of the second update keeps a concurrent request from adding
+	// the device twice.
+	filter := bson.M{"_id": objectID, "deleted_at": nil, "devices.id": device.ID}
+	update := bson.M{"$set": bson.M{
+		"devices.$.name":          device.Name,
+		"devices.$.public_key":    device.PublicKey,
+		"devices.$.trusted_until": device.TrustedUntil,
+	}}
+	result, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return fmt.Errorf("failed to trust device: %w", err)
+	}
+	if result.MatchedCount > 0 {
+		return nil
+	}
+
+	filter = bson.M{"_id": objectID, "deleted_at": nil, "devices.id": bson.M{"$ne": device.ID}}
+	result, err = r.collection.UpdateOne(ctx, filter, bson.M{"$push": bson.M{"devices": device}})
+	if err != nil {
+		return fmt.Errorf("failed to trust device: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return r.conflictOrNotFound(ctx, id)
+	}
+
+	return nil
+}
+
+func (r *MongoUserRepository) UseDevice(ctx context.Context, id string, deviceID string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	objectID, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrInvalidID
+	}
+
+	filter := bson.M{"_id": objectID, "deleted_at": nil, "devices.id": deviceID}
+	result, err := r.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"devices.$.last_used_at": time.Now().UTC()}})
+	if err != nil {
+		return fmt.Errorf("failed to update device: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return r.conflictOrNotFound(ctx, id)
+	}
+
+	return nil
+}
+
+func (r *MongoUserRepository) DeleteDevice(ctx context.Context, id string, deviceID string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	objectID, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrInvalidID
+	}
+
+	filter := bson.M{"_id": objectID, "deleted_at": nil, "devices.id": deviceID}
+	result, err := r.collection.UpdateOne(ctx, filter, bson.M{"$pull": bson.M{"devices": bson.M{"id": deviceID}}})
+	if err != nil {
+		return fmt.Errorf("failed to delete device: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return r.conflictOrNotFound(ctx, id)
+	}
+
+	return nil
+}
+
+// Sessions reads the sessions field, which isn't part of User.
+func (r *MongoUserRepository) Sessions(ctx context.Context, id string) ([]Session, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	objectID, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrInvalidID
+	}
+
+	var doc struct {
+		Sessions []Session `bson:"sessions"`
+	}
+	opts := options.FindOne().SetProjection(bson.M{"sessions": 1})
+	err = r.collection.FindOne(ctx, bson.M{"_id": objectID, "deleted_at": nil}, opts).Decode(&doc)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to find sessions: %w", err)
+	}
+
+	now := time.Now()
+	sessions := []Session{}
+	for _, session := range doc.Sessions {
+		if session.ExpiresAt.After(now) {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, nil
+}
+
+func (r *MongoUserRepository) CreateSession(ctx context.Context, id string, session Session) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	objectID, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrInvalidID
+	}
+
+	// A field can't be pulled from and pushed to in one update.
+	filter := bson.M{"_id": objectID, "deleted_at": nil}
+	expired := bson.M{"$pull": bson.M{"sessions": bson.M{"expires_at": bson.M{"$lte": time.Now().UTC()}}}}
+	if _, err := r.collection.UpdateOne(ctx, filter, expired); err != nil {
+		return fmt.Errorf("failed to drop expired sessions: %w", err)
+	}
+	result, err := r.collection.UpdateOne(ctx, filter, bson.M{"$push": bson.M{"sessions": session}})
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+func (r *MongoUserRepository) TouchSession(ctx context.Context, id string, sessionID string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	objectID, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrInvalidID
+	}
+
+	now := time.Now().UTC()
 	filter := bson.M{
-		"phone_number": bson.M{"$regex": phonePrefix},
+		"_id":        objectID,
+		"deleted_at": nil,
+		"sessions":   bson.M{"$elemMatch": bson.M{"id": sessionID, "expires_at": bson.M{"$gt": now}}},
+	}
+	result, err := r.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"sessions.$.last_seen_at": now}})
+	if err != nil {
+		return fmt.Errorf("failed to update session: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return r.conflictOrNotFound(ctx, id)
+	}
+
+	return nil
+}
+
+func (r *MongoUserRepository) DeleteSession(ctx context.Context, id string, sessionID string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	objectID, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrInvalidID
+	}
+
+	filter := bson.M{"_id": objectID, "deleted_at": nil, "sessions.id": sessionID}
+	result, err := r.collection.UpdateOne(ctx, filter, bson.M{"$pull": bson.M{"sessions": bson.M{"id": sessionID}}})
+	if err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return r.conflictOrNotFound(ctx, id)
+	}
+
+	return nil
+}
+
+func (r *MongoUserRepository) DeleteSessions(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	objectID, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrInvalidID
+	}
+
+	result, err := r.collection.UpdateOne(ctx, bson.M{"_id": objectID, "deleted_at": nil}, bson.M{"$unset": bson.M{"sessions": ""}})
+	if err != nil {
+		return fmt.Errorf("failed to delete sessions: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// conflictOrNotFound returns ErrUserNotFound if the user with the given ID
+// doesn't exist and ErrConflict otherwise.
+func (r *MongoUserRepository) conflictOrNotFound(ctx context.Context, id string) error {
+	if _, err := r.FindByID(ctx, id); err != nil {
+		return err
 	}
+	return ErrConflict
+}
+
+func (r *MongoUserRepository) Purge(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	result, err := r.collection.DeleteMany(ctx, bson.M{"deleted_at": bson.M{"$lt": deletedBefore}})
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge users: %w", err)
+	}
+
+	return result.DeletedCount, nil
+}
+
+func (r *MongoUserRepository) SearchByPhone(ctx context.Context, phonePrefix string, query UserQuery) (*PaginatedUsers, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	filter := queryFilter(query)
+	filter["phone_number"] = bson.M{"$regex": "^" + regexp.QuoteMeta(phonePrefix)}
 
-	return r.findPaginated(ctx, filter, page, pageSize)
+	return r.findPaginated(ctx, filter, query)
 }
 
-func (r *MongoUserRepository) GetAll(page, pageSize int) (*PaginatedUsers, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (r *MongoUserRepository) GetAll(ctx context.Context, query UserQuery) (*PaginatedUsers, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
 	defer cancel()
 
-	return r.findPaginated(ctx, bson.M{}, page, pageSize)
+	return r.findPaginated(ctx, queryFilter(query), query)
 }
 
-func (r *MongoUserRepository) findPaginated(ctx context.Context, filter bson.M, page, pageSize int) (*PaginatedUsers, error) {
+func queryFilter(query UserQuery) bson.M {
+	filter := bson.M{"deleted_at": nil}
+
+	registeredAt := bson.M{}
+	if query.RegisteredAfter != nil {
+		registeredAt["$gt"] = *query.RegisteredAfter
+	}
+	if query.RegisteredBefore != nil {
+		registeredAt["$lt"] = *query.RegisteredBefore
+	}
+	if len(registeredAt) > 0 {
+		filter["registered_at"] = registeredAt
+	}
+
+	now := time.Now()
+	switch query.Status {
+	case StatusActive:
+		filter["$or"] = bson.A{
+			bson.M{"status": bson.M{"$in": bson.A{StatusActive, nil}}},
+			bson.M{"status": StatusSuspended, "status_expires_at": bson.M{"$lte": now}},
+		}
+	case StatusSuspended:
+		filter["status"] = StatusSuspended
+		filter["$or"] = bson.A{
+			bson.M{"status_expires_at": nil},
+			bson.M{"status_expires_at": bson.M{"$gt": now}},
+		}
+	case StatusBanned:
+		filter["status"] = StatusBanned
+	}
+
+	if query.Role == RoleUser {
+		filter["role"] = bson.M{"$in": bson.A{RoleUser, nil}}
+	} else if query.Role != "" {
+		filter["role"] = query.Role
+	}
+
+	return filter
+}
+
+func querySort(query UserQuery) bson.D {
+	fields := query.Sort
+	if len(fields) == 0 {
+		fields = DefaultSort
+	}
+
+	sort := bson.D{}
+	for _, f := range fields {
+		order := 1
+		if f.Desc {
+			order = -1
+		}
+		sort = append(sort, bson.E{Key: f.Field, Value: order})
+	}
+
+	// Break ties on _id so that pages are stable.
+	return append(sort, bson.E{Key: "_id", Value: 1})
+}
+
+func (r *MongoUserRepository) findPaginated(ctx context.Context, filter bson.M, query UserQuery) (*PaginatedUsers, error) {
+	page, pageSize := query.Page, query.PageSize
 	skip := (page - 1) * pageSize
 
 	totalCount, err := r.collection.CountDocuments(ctx, filter)
@@ -146,7 +876,7 @@ func (r *MongoUserRepository) findPaginated(ctx context.Context, filter bson.M,
 	opts := options.Find().
 		SetSkip(int64(skip)).
 		SetLimit(int64(pageSize)).
-		SetSort(bson.D{{"registered_at", -1}})
+		SetSort(querySort(query))
 
 	cursor, err := r.collection.Find(ctx, filter, opts)
 	if err != nil {
@@ -154,7 +884,7 @@ func (r *MongoUserRepository) findPaginated(ctx context.Context, filter bson.M,
 	}
 	defer cursor.Close(ctx)
 
-	var users []User
+	users := []User{}
 	if err = cursor.All(ctx, &users); err != nil {
 		return nil, fmt.Errorf("failed to decode users: %w", err)
 	}
@@ -169,3 +899,12 @@ func (r *MongoUserRepository) findPaginated(ctx context.Context, filter bson.M,
 		TotalPages: totalPages,
 	}, nil
 }
+
+func isIndexNotFound(err error) bool {
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) {
+		// IndexNotFound and NamespaceNotFound (collection doesn't exist yet).
+		return cmdErr.Code == 27 || cmdErr.Code == 26
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+package users_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/epicmet/dekamond-task/internal/users"
+	"github.com/epicmet/dekamond-task/internal/users/userstest"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Set MONGO_TEST_URI to a disposable server to run these tests. Every test
+// uses, and then drops, its own database.
+func TestMongoUserRepository(t *testing.T) {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI is not set")
+	}
+
+	client, err := mongo.Connect(options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect(context.Background())
+
+	userstest.Run(t, func(t *testing.T) users.UserRepository {
+		dbName := fmt.Sprintf("dekamond_test_%d", time.Now().UnixNano())
+		t.Cleanup(func() {
+			client.Database(dbName).Drop(context.Background())
+		})
+
+		repo, err := users.NewMongoUserRepository(t.Context(), uri, dbName, 5*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { repo.Close(context.Background()) })
+
+		return repo
+	})
+}
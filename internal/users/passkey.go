@@ -0,0 +1,20 @@
+package users
+
+import "time"
+
+// Passkey is a WebAuthn public key credential registered by a user. IDs are
+// unique across all users, as authenticators generate them randomly.
+type Passkey struct {
+	ID              []byte   `bson:"id"`
+	PublicKey       []byte   `bson:"public_key"`
+	AttestationType string   `bson:"attestation_type"`
+	Transports      []string `bson:"transports,omitempty"`
+	AAGUID          []byte   `bson:"aaguid"`
+	// SignCount is the signature counter of the last login, used to detect
+	// cloned authenticators. Authenticators that don't keep one report 0.
+	SignCount      uint32 `bson:"sign_count"`
+	BackupEligible bool   `bson:"backup_eligible"`
+
+	CreatedAt  time.Time  `bson:"created_at"`
+	LastUsedAt *time.Time `bson:"last_used_at,omitempty"`
+}
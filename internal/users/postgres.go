@@ -0,0 +1,762 @@
+package users
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+//go:embed migrations/postgres/*.sql
+var postgresMigrations embed.FS
+
+type PostgresUserRepository struct {
+	pool    *pgxpool.Pool
+	timeout time.Duration
+}
+
+// NewPostgresUserRepository connects to PostgreSQL and migrates the schema
+// within ctx. Every later operation is bounded by timeout on top of the
+// deadline of its own context.
+func NewPostgresUserRepository(ctx context.Context, dsn string, timeout time.Duration) (*PostgresUserRepository, error) {
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to PostgreSQL: %w", err)
+	}
+
+	err = pool.Ping(ctx)
+	if err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to ping PostgreSQL: %w", err)
+	}
+
+	err = migratePostgres(ctx, pool)
+	if err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to migrate PostgreSQL: %w", err)
+	}
+
+	return &PostgresUserRepository{pool: pool, timeout: timeout}, nil
+}
+
+// migratePostgres applies the embedded migrations that haven't been applied
+// yet, in file name order, each in its own transaction.
+func migratePostgres(ctx context.Context, pool *pgxpool.Pool) error {
+	_, err := pool.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    TEXT PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return err
+	}
+
+	files, err := fs.Glob(postgresMigrations, "migrations/postgres/*.sql")
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		version := strings.TrimSuffix(file[strings.LastIndex(file, "/")+1:], ".sql")
+
+		script, err := postgresMigrations.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		err = pgx.BeginFunc(ctx, pool, func(tx pgx.Tx) error {
+			// Serialize concurrent instances migrating the same database.
+			if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext('schema_migrations'))"); err != nil {
+				return err
+			}
+
+			var applied bool
+			err := tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)", version).Scan(&applied)
+			if err != nil || applied {
+				return err
+			}
+
+			if _, err := tx.Exec(ctx, string(script)); err != nil {
+				return fmt.Errorf("migration %s: %w", version, err)
+			}
+
+			_, err = tx.Exec(ctx, "INSERT INTO schema_migrations (version) VALUES ($1)", version)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close waits for acquired connections to be released, ignoring ctx, as
+// pgxpool offers no way to bound it.
+func (r *PostgresUserRepository) Close(context.Context) error {
+	r.pool.Close()
+	return nil
+}
+
+func (r *PostgresUserRepository) HealthCheck(ctx context.Context) error {
+	return r.pool.Ping(ctx)
+}
+
+func postgresArgs() *sqlArgs {
+	return &sqlArgs{placeholder: func(n int) string { return fmt.Sprintf("$%d", n) }}
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+func (r *PostgresUserRepository) Create(ctx context.Context, phoneNumber string) (*User, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	user := &User{
+		ID:           bson.NewObjectID(),
+		PhoneNumber:  phoneNumber,
+		Role:         RoleUser,
+		Status:       StatusActive,
+		RegisteredAt: now,
+		UpdatedAt:    now,
+	}
+
+	_, err := r.pool.Exec(ctx,
+		`INSERT INTO users (id, phone_number, role, status, registered_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		user.ID.Hex(), user.PhoneNumber, user.Role, user.Status, user.RegisteredAt, user.UpdatedAt,
+	)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrDuplicatePhone
+		}
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+
+	return user, nil
+}
+
+func (r *PostgresUserRepository) FindByID(ctx context.Context, id string) (*User, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	if _, err := bson.ObjectIDFromHex(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	row := r.pool.QueryRow(ctx, "SELECT "+userColumns+" FROM users WHERE id = $1 AND deleted_at IS NULL", id)
+	return r.scanOne(row, "failed to find user")
+}
+
+func (r *PostgresUserRepository) FindByPhone(ctx context.Context, phoneNumber string) (*User, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	row := r.pool.QueryRow(ctx, "SELECT "+userColumns+" FROM users WHERE phone_number = $1 AND deleted_at IS NULL", phoneNumber)
+	return r.scanOne(row, "failed to find user")
+}
+
+func (r *PostgresUserRepository) Upsert(ctx context.Context, phoneNumber string) (*User, error) {
+	possibleUser, err := r.FindByPhone(ctx, phoneNumber)
+	if err != nil {
+		if !errors.Is(err, ErrUserNotFound) {
+			return nil, err
+		}
+
+		createdUser, err := r.Create(ctx, phoneNumber)
+		if errors.Is(err, ErrDuplicatePhone) {
+			// Lost a race with a concurrent registration of the same number.
+			return r.FindByPhone(ctx, phoneNumber)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return createdUser, nil
+	}
+
+	return possibleUser, nil
+}
+
+func (r *PostgresUserRepository) UpdateProfile(ctx context.Context, id string, patch ProfilePatch) (*User, error) {
+	args := postgresArgs()
+	sets := []string{"updated_at = " + args.add(time.Now().UTC())}
+
+	fields := make([]string, 0, len(patch))
+	for field := range patch {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	// Field names are validated by ParseProfilePatch against the profile
+	// whitelist, so they are safe to interpolate.
+	for _, field := range fields {
+		if value := patch[field]; value != nil {
+			sets = append(sets, field+" = "+args.add(*value))
+		} else {
+			sets = append(sets, field+" = NULL")
+		}
+	}
+	if _, ok := patch["email"]; ok {
+		sets = append(sets, "email_verified_at = NULL")
+	}
+
+	return r.updateOne(ctx, id, strings.Join(sets, ", "), "deleted_at IS NULL", args, "failed to update user")
+}
+
+func (r *PostgresUserRepository) VerifyEmail(ctx context.Context, id string, email string) (*User, error) {
+	args := postgresArgs()
+	now := args.add(time.Now().UTC())
+	cond := "deleted_at IS NULL AND email = " + args.add(email)
+
+	user, err := r.updateOne(ctx, id, "email_verified_at = "+now+", updated_at = "+now, cond, args, "failed to verify user email")
+	if errors.Is(err, ErrUserNotFound) {
+		if _, findErr := r.FindByID(ctx, id); findErr == nil {
+			return nil, ErrConflict
+		}
+	}
+
+	return user, err
+}
+
+func (r *PostgresUserRepository) Delete(ctx context.Context, id string) error {
+	args := postgresArgs()
+	now := args.add(time.Now().UTC())
+
+	_, err := r.updateOne(ctx, id, "deleted_at = "+now+", updated_at = "+now, "deleted_at IS NULL", args, "failed to delete user")
+	return err
+}
+
+func (r *PostgresUserRepository) Restore(ctx context.Context, id string) (*User, error) {
+	args := postgresArgs()
+	sets := "deleted_at = NULL, updated_at = " + args.add(time.Now().UTC())
+
+	user, err := r.updateOne(ctx, id, sets, "deleted_at IS NOT NULL", args, "failed to restore user")
+	if errors.Is(err, ErrUserNotFound) {
+		if _, findErr := r.FindByID(ctx, id); findErr == nil {
+			return nil, ErrConflict
+		}
+	}
+
+	return user, err
+}
+
+func (r *PostgresUserRepository) SetStatus(ctx context.Context, id string, change StatusChange) (*User, error) {
+	args := postgresArgs()
+
+	var reason, expiresAt any
+	if change.Reason != "" {
+		reason = change.Reason
+	}
+	if change.ExpiresAt != nil {
+		expiresAt = change.ExpiresAt.UTC()
+	}
+
+	sets := fmt.Sprintf(
+		"status = %s, status_reason = %s, status_expires_at = %s, updated_at = %s",
+		args.add(change.Status), args.add(reason), args.add(expiresAt), args.add(time.Now().UTC()),
+	)
+
+	return r.updateOne(ctx, id, sets, "deleted_at IS NULL", args, "failed to update user status")
+}
+
+func (r *PostgresUserRepository) SetRole(ctx context.Context, id string, role Role) (*User, error) {
+	args := postgresArgs()
+	sets := fmt.Sprintf("role = %s, updated_at = %s", args.add(role), args.add(time.Now().UTC()))
+
+	return r.updateOne(ctx, id, sets, "deleted_at IS NULL", args, "failed to update user role")
+}
+
+func (r *PostgresUserRepository) SetTOTP(ctx context.Context, id string, totp *TOTP, recoveryCodeHashes []string) (*User, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	if _, err := bson.ObjectIDFromHex(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	secret, confirmedAt, lastStep := totpValues(totp)
+	var user *User
+	err := pgx.BeginFunc(ctx, r.pool, func(tx pgx.Tx) error {
+		row := tx.QueryRow(ctx,
+			`UPDATE users SET totp_secret = $1, totp_confirmed_at = $2, totp_last_step = $3, updated_at = $4
+			WHERE id = $5 AND deleted_at IS NULL RETURNING `+userColumns,
+			secret, confirmedAt, lastStep, time.Now().UTC(), id,
+		)
+		var err error
+		user, err = r.scanOne(row, "failed to update user TOTP")
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(ctx, "DELETE FROM user_recovery_codes WHERE user_id = $1", id); err != nil {
+			return fmt.Errorf("failed to delete recovery codes: %w", err)
+		}
+		if totp == nil {
+			return nil
+		}
+		for _, hash := range recoveryCodeHashes {
+			if _, err := tx.Exec(ctx, "INSERT INTO user_recovery_codes (user_id, code_hash) VALUES ($1, $2)", id, hash); err != nil {
+				return fmt.Errorf("failed to store recovery codes: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (r *PostgresUserRepository) UseTOTPStep(ctx context.Context, id string, step int64) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	if _, err := bson.ObjectIDFromHex(id); err != nil {
+		return ErrInvalidID
+	}
+
+	tag, err := r.pool.Exec(ctx,
+		`UPDATE users SET totp_last_step = $1
+		WHERE id = $2 AND deleted_at IS NULL AND totp_secret IS NOT NULL AND totp_last_step < $1`,
+		step, id,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update user TOTP: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return r.conflictOrNotFound(ctx, id)
+	}
+
+	return nil
+}
+
+func (r *PostgresUserRepository) UseRecoveryCode(ctx context.Context, id string, hash string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	if _, err := bson.ObjectIDFromHex(id); err != nil {
+		return ErrInvalidID
+	}
+
+	tag, err := r.pool.Exec(ctx,
+		`DELETE FROM user_recovery_codes WHERE user_id = $1 AND code_hash = $2
+		AND EXISTS (SELECT 1 FROM users WHERE id = $1 AND deleted_at IS NULL)`,
+		id, hash,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to use recovery code: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return r.conflictOrNotFound(ctx, id)
+	}
+
+	return nil
+}
+
+func (r *PostgresUserRepository) Passkeys(ctx context.Context, id string) ([]Passkey, error) {
+	if _, err := r.FindByID(ctx, id); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	rows, err := r.pool.Query(ctx, "SELECT "+passkeyColumns+" FROM user_passkeys WHERE user_id = $1 ORDER BY created_at, id", id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find passkeys: %w", err)
+	}
+	defer rows.Close()
+
+	passkeys := []Passkey{}
+	for rows.Next() {
+		passkey, err := scanPasskey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode passkey: %w", err)
+		}
+		passkeys = append(passkeys, passkey)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to find passkeys: %w", err)
+	}
+
+	return passkeys, nil
+}
+
+func (r *PostgresUserRepository) AddPasskey(ctx context.Context, id string, passkey Passkey) error {
+	if _, err := r.FindByID(ctx, id); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	_, err := r.pool.Exec(ctx,
+		"INSERT INTO user_passkeys (user_id, "+passkeyColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NULL)",
+		id, passkey.ID, passkey.PublicKey, passkey.AttestationType, strings.Join(passkey.Transports, ","), passkey.AAGUID,
+		int64(passkey.SignCount), passkey.BackupEligible, passkey.CreatedAt.UTC(),
+	)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrConflict
+		}
+		return fmt.Errorf("failed to add passkey: %w", err)
+	}
+
+	return nil
+}
+
+func (r *PostgresUserRepository) UsePasskey(ctx context.Context, id string, passkeyID []byte, signCount uint32) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	if _, err := bson.ObjectIDFromHex(id); err != nil {
+		return ErrInvalidID
+	}
+
+	tag, err := r.pool.Exec(ctx,
+		`UPDATE user_passkeys SET sign_count = $1, last_used_at = $2 WHERE user_id = $3 AND id = $4
+		AND EXISTS (SELECT 1 FROM users WHERE id = $3 AND deleted_at IS NULL)`,
+		int64(signCount), time.Now().UTC(), id, passkeyID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update passkey: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return r.conflictOrNotFound(ctx, id)
+	}
+
+	return nil
+}
+
+func (r *PostgresUserRepository) Devices(ctx context.Context, id string) ([]Device, error) {
+	if _, err := r.FindByID(ctx, id); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	rows, err := r.pool.Query(ctx, "SELECT "+deviceColumns+" FROM user_devices WHERE user_id = $1 ORDER BY created_at, id", id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find devices: %w", err)
+	}
+	defer rows.Close()
+
+	devices := []Device{}
+	for rows.Next() {
+		device, err := scanDevice(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode device: %w", err)
+		}
+		devices = append(devices, device)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to find devices: %w", err)
+	}
+
+	return devices, nil
+}
+
+func (r *PostgresUserRepository) TrustDevice(ctx context.Context, id string, device Device) error {
+	if _, err := r.FindByID(ctx, id); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	_, err := r.pool.Exec(ctx,
+		`INSERT INTO user_devices (user_id, `+deviceColumns+`) VALUES ($1, $2, $3, $4, $5, NULL, $6)
+		ON CONFLICT (user_id, id) DO UPDATE SET name = EXCLUDED.name, public_key = EXCLUDED.public_key, trusted_until = EXCLUDED.trusted_until`,
+		id, device.ID, device.Name, device.PublicKey, device.CreatedAt.UTC(), device.TrustedUntil.UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to trust device: %w", err)
+	}
+
+	return nil
+}
+
+func (r *PostgresUserRepository) UseDevice(ctx context.Context, id string, deviceID string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	if _, err := bson.ObjectIDFromHex(id); err != nil {
+		return ErrInvalidID
+	}
+
+	tag, err := r.pool.Exec(ctx,
+		`UPDATE user_devices SET last_used_at = $1 WHERE user_id = $2 AND id = $3
+		AND EXISTS (SELECT 1 FROM users WHERE id = $2 AND deleted_at IS NULL)`,
+		time.Now().UTC(), id, deviceID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update device: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return r.conflictOrNotFound(ctx, id)
+	}
+
+	return nil
+}
+
+func (r *PostgresUserRepository) DeleteDevice(ctx context.Context, id string, deviceID string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	if _, err := bson.ObjectIDFromHex(id); err != nil {
+		return ErrInvalidID
+	}
+
+	tag, err := r.pool.Exec(ctx,
+		`DELETE FROM user_devices WHERE user_id = $1 AND id = $2
+		AND EXISTS (SELECT 1 FROM users WHERE id = $1 AND deleted_at IS NULL)`,
+		id, deviceID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to delete device: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return r.conflictOrNotFound(ctx, id)
+	}
+
+	return nil
+}
+
+func (r *PostgresUserRepository) Sessions(ctx context.Context, id string) ([]Session, error) {
+	if _, err := r.FindByID(ctx, id); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	rows, err := r.pool.Query(ctx,
+		"SELECT "+sessionColumns+" FROM user_sessions WHERE user_id = $1 AND expires_at > $2 ORDER BY created_at, id",
+		id, time.Now().UTC(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find sessions: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode session: %w", err)
+		}
+		sessions = append(sessions, session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to find sessions: %w", err)
+	}
+
+	return sessions, nil
+}
+
+func (r *PostgresUserRepository) CreateSession(ctx context.Context, id string, session Session) error {
+	if _, err := r.FindByID(ctx, id); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	err := pgx.BeginFunc(ctx, r.pool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, "DELETE FROM user_sessions WHERE user_id = $1 AND expires_at <= $2", id, time.Now().UTC()); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx,
+			"INSERT INTO user_sessions (user_id, "+sessionColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+			id, session.ID, session.UserAgent, session.IP, session.DeviceID,
+			session.CreatedAt.UTC(), session.LastSeenAt.UTC(), session.ExpiresAt.UTC(),
+		)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+
+	return nil
+}
+
+func (r *PostgresUserRepository) TouchSession(ctx context.Context, id string, sessionID string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	if _, err := bson.ObjectIDFromHex(id); err != nil {
+		return ErrInvalidID
+	}
+
+	now := time.Now().UTC()
+	tag, err := r.pool.Exec(ctx,
+		`UPDATE user_sessions SET last_seen_at = $1 WHERE user_id = $2 AND id = $3 AND expires_at > $1
+		AND EXISTS (SELECT 1 FROM users WHERE id = $2 AND deleted_at IS NULL)`,
+		now, id, sessionID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update session: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return r.conflictOrNotFound(ctx, id)
+	}
+
+	return nil
+}
+
+func (r *PostgresUserRepository) DeleteSession(ctx context.Context, id string, sessionID string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	if _, err := bson.ObjectIDFromHex(id); err != nil {
+		return ErrInvalidID
+	}
+
+	tag, err := r.pool.Exec(ctx,
+		`DELETE FROM user_sessions WHERE user_id = $1 AND id = $2
+		AND EXISTS (SELECT 1 FROM users WHERE id = $1 AND deleted_at IS NULL)`,
+		id, sessionID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return r.conflictOrNotFound(ctx, id)
+	}
+
+	return nil
+}
+
+func (r *PostgresUserRepository) DeleteSessions(ctx context.Context, id string) error {
+	if _, err := r.FindByID(ctx, id); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	if _, err := r.pool.Exec(ctx, "DELETE FROM user_sessions WHERE user_id = $1", id); err != nil {
+		return fmt.Errorf("failed to delete sessions: %w", err)
+	}
+
+	return nil
+}
+
+// conflictOrNotFound returns ErrUserNotFound if the user with the given ID
+// doesn't exist and ErrConflict otherwise.
+func (r *PostgresUserRepository) conflictOrNotFound(ctx context.Context, id string) error {
+	if _, err := r.FindByID(ctx, id); err != nil {
+		return err
+	}
+	return ErrConflict
+}
+
+func (r *PostgresUserRepository) Purge(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	tag, err := r.pool.Exec(ctx, "DELETE FROM users WHERE deleted_at < $1", deletedBefore.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge users: %w", err)
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+func (r *PostgresUserRepository) SearchByPhone(ctx context.Context, phonePrefix string, query UserQuery) (*PaginatedUsers, error) {
+	return r.findPaginated(ctx, phonePrefix, query)
+}
+
+func (r *PostgresUserRepository) GetAll(ctx context.Context, query UserQuery) (*PaginatedUsers, error) {
+	return r.findPaginated(ctx, "", query)
+}
+
+func (r *PostgresUserRepository) findPaginated(ctx context.Context, phonePrefix string, query UserQuery) (*PaginatedUsers, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	args := postgresArgs()
+	where := userQueryWhere(query, phonePrefix, time.Now().UTC(), args)
+
+	var totalCount int64
+	err := r.pool.QueryRow(ctx, "SELECT count(*) FROM users WHERE "+where, args.values...).Scan(&totalCount)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count users: %w", err)
+	}
+
+	stmt := fmt.Sprintf(
+		"SELECT %s FROM users WHERE %s ORDER BY %s LIMIT %s OFFSET %s",
+		userColumns, where, userQueryOrderBy(query), args.add(query.PageSize), args.add((query.Page-1)*query.PageSize),
+	)
+
+	rows, err := r.pool.Query(ctx, stmt, args.values...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find users: %w", err)
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode users: %w", err)
+		}
+		users = append(users, *user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to find users: %w", err)
+	}
+
+	return &PaginatedUsers{
+		Users:      users,
+		Page:       query.Page,
+		PageSize:   query.PageSize,
+		TotalCount: totalCount,
+		TotalPages: totalPages(totalCount, query.PageSize),
+	}, nil
+}
+
+// updateOne applies sets to the user with the given ID if it matches cond,
+// returning the updated user or ErrUserNotFound.
+func (r *PostgresUserRepository) updateOne(ctx context.Context, id, sets, cond string, args *sqlArgs, errMsg string) (*User, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	if _, err := bson.ObjectIDFromHex(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	stmt := fmt.Sprintf("UPDATE users SET %s WHERE id = %s AND %s RETURNING %s", sets, args.add(id), cond, userColumns)
+	return r.scanOne(r.pool.QueryRow(ctx, stmt, args.values...), errMsg)
+}
+
+func (r *PostgresUserRepository) scanOne(row pgx.Row, errMsg string) (*User, error) {
+	user, err := scanUser(row)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		if isUniqueViolation(err) {
+			return nil, ErrDuplicatePhone
+		}
+		return nil, fmt.Errorf("%s: %w", errMsg, err)
+	}
+
+	return user, nil
+}
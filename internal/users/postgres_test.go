@@ -0,0 +1,41 @@
+package users_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/epicmet/dekamond-task/internal/users"
+	"github.com/epicmet/dekamond-task/internal/users/userstest"
+	"github.com/jackc/pgx/v5"
+)
+
+// Set POSTGRES_TEST_DSN to a disposable database to run these tests. Every
+// test truncates the users table.
+func TestPostgresUserRepository(t *testing.T) {
+	dsn := os.Getenv("POSTGRES_TEST_DSN")
+	if dsn == "" {
+		t.Skip("POSTGRES_TEST_DSN is not set")
+	}
+
+	userstest.Run(t, func(t *testing.T) users.UserRepository {
+		repo, err := users.NewPostgresUserRepository(t.Context(), dsn, 5*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { repo.Close(context.Background()) })
+
+		conn, err := pgx.Connect(context.Background(), dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close(context.Background())
+
+		if _, err := conn.Exec(context.Background(), "TRUNCATE users CASCADE"); err != nil {
+			t.Fatal(err)
+		}
+
+		return repo
+	})
+}
@@ -0,0 +1,116 @@
+package users
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/text/language"
+)
+
+// ProfilePatch is a JSON Merge Patch (RFC 7396) of the profile fields of a
+// User, keyed by field name. A nil value removes the field.
+type ProfilePatch map[string]*string
+
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+var profileFields = map[string]func(string) error{
+	"first_name": validateName,
+	"last_name":  validateName,
+	"email":      validateEmail,
+	"avatar_url": validateAvatarURL,
+	"locale":     validateLocale,
+	"timezone":   validateTimezone,
+}
+
+// ParseProfilePatch decodes and validates a merge patch document. Unknown
+// fields, including read-only ones such as phone_number, are rejected.
+func ParseProfilePatch(data []byte) (ProfilePatch, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil || raw == nil {
+		return nil, &ValidationError{Message: "patch must be a JSON object"}
+	}
+
+	patch := make(ProfilePatch, len(raw))
+	for field, value := range raw {
+		validate, ok := profileFields[field]
+		if !ok {
+			return nil, &ValidationError{Field: field, Message: "unknown or read-only field"}
+		}
+
+		if string(value) == "null" {
+			patch[field] = nil
+			continue
+		}
+
+		var s string
+		if err := json.Unmarshal(value, &s); err != nil {
+			return nil, &ValidationError{Field: field, Message: "must be a string or null"}
+		}
+
+		s = strings.TrimSpace(s)
+		if err := validate(s); err != nil {
+			return nil, &ValidationError{Field: field, Message: err.Error()}
+		}
+
+		patch[field] = &s
+	}
+
+	return patch, nil
+}
+
+func validateName(s string) error {
+	n := utf8.RuneCountInString(s)
+	if n == 0 || n > 64 {
+		return errors.New("must be between 1 and 64 characters")
+	}
+	return nil
+}
+
+func validateEmail(s string) error {
+	addr, err := mail.ParseAddress(s)
+	if err != nil || addr.Address != s || len(s) > 254 {
+		return errors.New("must be a valid email address")
+	}
+	return nil
+}
+
+func validateAvatarURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || len(s) > 2048 {
+		return errors.New("must be an absolute http(s) URL")
+	}
+	return nil
+}
+
+func validateLocale(s string) error {
+	if _, err := language.Parse(s); err != nil {
+		return errors.New("must be a BCP 47 language tag")
+	}
+	return nil
+}
+
+func validateTimezone(s string) error {
+	if s == "" || s == "Local" {
+		return errors.New("must be an IANA time zone name")
+	}
+	if _, err := time.LoadLocation(s); err != nil {
+		return errors.New("must be an IANA time zone name")
+	}
+	return nil
+}
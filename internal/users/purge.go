@@ -0,0 +1,40 @@
+package users
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// StartPurgeJob hard deletes, every interval, the users that were soft
+// deleted more than retention ago. The job stops when ctx is done; the
+// returned channel is closed once it has.
+func StartPurgeJob(ctx context.Context, logger *slog.Logger, repo UserRepository, retention, interval time.Duration) <-chan struct{} {
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			purged, err := repo.Purge(ctx, time.Now().Add(-retention))
+			if err != nil {
+				logger.Error("failed to purge deleted users", "error", err)
+				continue
+			}
+			if purged > 0 {
+				logger.Info("purged deleted users", "count", purged)
+			}
+		}
+	}()
+
+	return done
+}
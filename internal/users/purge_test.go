@@ -0,0 +1,46 @@
+package users_test
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/epicmet/dekamond-task/internal/users"
+	"go.uber.org/goleak"
+)
+
+// purgeRecorder reports every Purge call on purged.
+type purgeRecorder struct {
+	users.UserRepository
+	purged chan time.Time
+}
+
+func (r purgeRecorder) Purge(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	select {
+	case r.purged <- deletedBefore:
+	default:
+	}
+	return 0, nil
+}
+
+func TestPurgeJobStops(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	repo := purgeRecorder{UserRepository: users.NewMemoryUserRepository(), purged: make(chan time.Time)}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	done := users.StartPurgeJob(ctx, slog.New(slog.DiscardHandler), repo, time.Hour, time.Millisecond)
+
+	select {
+	case deletedBefore := <-repo.purged:
+		if time.Since(deletedBefore) < time.Hour {
+			t.Errorf("purged users deleted before %s, want at least an hour ago", deletedBefore)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("purge job didn't run")
+	}
+
+	cancel()
+	<-done
+}
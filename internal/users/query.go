@@ -0,0 +1,69 @@
+package users
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SortField is a single sort key of a UserQuery. Field is the stored field
+// name (e.g. "registered_at").
+type SortField struct {
+	Field string
+	Desc  bool
+}
+
+// UserQuery holds pagination, ordering and filtering options for listing
+// users. Zero values mean "no filter".
+type UserQuery struct {
+	Page     int
+	PageSize int
+	Sort     []SortField
+
+	RegisteredAfter  *time.Time
+	RegisteredBefore *time.Time
+	// Status filters on the effective status, so expired suspensions count
+	// as active.
+	Status Status
+	Role   Role
+}
+
+var DefaultSort = []SortField{{Field: "registered_at", Desc: true}}
+
+var sortableFields = map[string]bool{
+	"registered_at": true,
+	"phone_number":  true,
+}
+
+var ErrInvalidSort = errors.New("invalid sort parameter")
+
+// ParseSort parses a comma separated list of field names, each optionally
+// prefixed with "-" for descending order, e.g. "-registered_at,phone_number".
+// Only whitelisted fields are accepted.
+func ParseSort(s string) ([]SortField, error) {
+	if strings.TrimSpace(s) == "" {
+		return DefaultSort, nil
+	}
+
+	seen := make(map[string]bool)
+	var fields []SortField
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+
+		desc := strings.HasPrefix(part, "-")
+		name := strings.TrimPrefix(part, "-")
+
+		if !sortableFields[name] {
+			return nil, fmt.Errorf("%w: unknown field %q", ErrInvalidSort, name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("%w: duplicate field %q", ErrInvalidSort, name)
+		}
+		seen[name] = true
+
+		fields = append(fields, SortField{Field: name, Desc: desc})
+	}
+
+	return fields, nil
+}
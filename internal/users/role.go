@@ -0,0 +1,34 @@
+package users
+
+type Role string
+
+const (
+	RoleUser    Role = "user"
+	RoleSupport Role = "support"
+	RoleAdmin   Role = "admin"
+)
+
+func (r Role) Valid() bool {
+	switch r {
+	case RoleUser, RoleSupport, RoleAdmin:
+		return true
+	}
+	return false
+}
+
+var roleRanks = map[Role]int{RoleUser: 0, RoleSupport: 1, RoleAdmin: 2}
+
+// Outranks reports whether r is above other: admins are above support, who
+// are above regular users.
+func (r Role) Outranks(other Role) bool {
+	return roleRanks[r] > roleRanks[other]
+}
+
+// EffectiveRole returns the role of the user, treating users created before
+// roles existed as regular users.
+func (u *User) EffectiveRole() Role {
+	if u.Role == "" {
+		return RoleUser
+	}
+	return u.Role
+}
@@ -0,0 +1,16 @@
+package users
+
+import "time"
+
+// Session is a login of a user on one client. Tokens carry the ID of their
+// session and stop working once it is deleted or expires with them.
+type Session struct {
+	ID        string `json:"id" bson:"id"`
+	UserAgent string `json:"user_agent,omitempty" bson:"user_agent,omitempty"`
+	IP        string `json:"ip,omitempty" bson:"ip,omitempty"`
+	// DeviceID is the trusted device the user logged in from, if any.
+	DeviceID   string    `json:"device_id,omitempty" bson:"device_id,omitempty"`
+	CreatedAt  time.Time `json:"created_at" bson:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at" bson:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at" bson:"expires_at"`
+}
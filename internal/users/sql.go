@@ -0,0 +1,243 @@
+package users
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// Helpers shared by the SQL implementations of UserRepository.
+
+const userColumns = `id, phone_number, first_name, last_name, email, email_verified_at, avatar_url, locale, timezone,
+	role, status, status_reason, status_expires_at, registered_at, updated_at, deleted_at,
+	totp_secret, totp_confirmed_at, totp_last_step`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanUser(row rowScanner) (*User, error) {
+	var (
+		id                                                string
+		user                                              User
+		firstName, lastName, email, avatarURL, locale, tz sql.NullString
+		statusReason                                      sql.NullString
+		emailVerifiedAt, statusExpiresAt, deletedAt       sql.Null[time.Time]
+		totpSecret                                        sql.NullString
+		totpConfirmedAt                                   sql.Null[time.Time]
+		totpLastStep                                      sql.NullInt64
+	)
+
+	err := row.Scan(
+		&id, &user.PhoneNumber, &firstName, &lastName, &email, &emailVerifiedAt, &avatarURL, &locale, &tz,
+		&user.Role, &user.Status, &statusReason, &statusExpiresAt, &user.RegisteredAt, &user.UpdatedAt, &deletedAt,
+		&totpSecret, &totpConfirmedAt, &totpLastStep,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	user.ID, err = bson.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid stored ID %q: %w", id, err)
+	}
+
+	user.FirstName = firstName.String
+	user.LastName = lastName.String
+	user.Email = email.String
+	user.AvatarURL = avatarURL.String
+	user.Locale = locale.String
+	user.Timezone = tz.String
+	user.StatusReason = statusReason.String
+	user.RegisteredAt = user.RegisteredAt.UTC()
+	user.UpdatedAt = user.UpdatedAt.UTC()
+	if emailVerifiedAt.Valid {
+		t := emailVerifiedAt.V.UTC()
+		user.EmailVerifiedAt = &t
+	}
+	if statusExpiresAt.Valid {
+		t := statusExpiresAt.V.UTC()
+		user.StatusExpiresAt = &t
+	}
+	if deletedAt.Valid {
+		t := deletedAt.V.UTC()
+		user.DeletedAt = &t
+	}
+	if totpSecret.Valid {
+		user.TOTP = &TOTP{Secret: totpSecret.String, LastStep: totpLastStep.Int64}
+		if totpConfirmedAt.Valid {
+			t := totpConfirmedAt.V.UTC()
+			user.TOTP.ConfirmedAt = &t
+		}
+	}
+
+	return &user, nil
+}
+
+// totpValues returns the values of the totp_secret, totp_confirmed_at and
+// totp_last_step columns storing totp, which are all NULL if it is nil.
+func totpValues(totp *TOTP) (secret, confirmedAt, lastStep any) {
+	if totp == nil {
+		return nil, nil, nil
+	}
+	if totp.ConfirmedAt != nil {
+		confirmedAt = totp.ConfirmedAt.UTC()
+	}
+	return totp.Secret, confirmedAt, totp.LastStep
+}
+
+const passkeyColumns = `id, public_key, attestation_type, transports, aaguid, sign_count, backup_eligible,
+	created_at, last_used_at`
+
+func scanPasskey(row rowScanner) (Passkey, error) {
+	var (
+		passkey    Passkey
+		transports string
+		lastUsedAt sql.Null[time.Time]
+	)
+
+	err := row.Scan(
+		&passkey.ID, &passkey.PublicKey, &passkey.AttestationType, &transports, &passkey.AAGUID, &passkey.SignCount, &passkey.BackupEligible,
+		&passkey.CreatedAt, &lastUsedAt,
+	)
+	if err != nil {
+		return Passkey{}, err
+	}
+
+	if transports != "" {
+		passkey.Transports = strings.Split(transports, ",")
+	}
+	passkey.CreatedAt = passkey.CreatedAt.UTC()
+	if lastUsedAt.Valid {
+		t := lastUsedAt.V.UTC()
+		passkey.LastUsedAt = &t
+	}
+
+	return passkey, nil
+}
+
+const sessionColumns = "id, user_agent, ip, device_id, created_at, last_seen_at, expires_at"
+
+func scanSession(row rowScanner) (Session, error) {
+	var session Session
+
+	err := row.Scan(&session.ID, &session.UserAgent, &session.IP, &session.DeviceID, &session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt)
+	if err != nil {
+		return Session{}, err
+	}
+
+	session.CreatedAt = session.CreatedAt.UTC()
+	session.LastSeenAt = session.LastSeenAt.UTC()
+	session.ExpiresAt = session.ExpiresAt.UTC()
+	return session, nil
+}
+
+const deviceColumns = "id, name, public_key, created_at, last_used_at, trusted_until"
+
+func scanDevice(row rowScanner) (Device, error) {
+	var (
+		device     Device
+		lastUsedAt sql.Null[time.Time]
+	)
+
+	err := row.Scan(&device.ID, &device.Name, &device.PublicKey, &device.CreatedAt, &lastUsedAt, &device.TrustedUntil)
+	if err != nil {
+		return Device{}, err
+	}
+
+	device.CreatedAt = device.CreatedAt.UTC()
+	device.TrustedUntil = device.TrustedUntil.UTC()
+	if lastUsedAt.Valid {
+		t := lastUsedAt.V.UTC()
+		device.LastUsedAt = &t
+	}
+
+	return device, nil
+}
+
+// sqlArgs collects the positional arguments of a statement and renders their
+// placeholders in the syntax of the database. If convert is set, values are
+// passed through it first.
+type sqlArgs struct {
+	values      []any
+	placeholder func(n int) string
+	convert     func(v any) any
+}
+
+func (a *sqlArgs) add(v any) string {
+	if a.convert != nil {
+		v = a.convert(v)
+	}
+	a.values = append(a.values, v)
+	return a.placeholder(len(a.values))
+}
+
+// userQueryWhere renders the WHERE clause selecting the users matched by
+// query, and by phonePrefix unless it is empty.
+func userQueryWhere(query UserQuery, phonePrefix string, now time.Time, args *sqlArgs) string {
+	conds := []string{"deleted_at IS NULL"}
+
+	if phonePrefix != "" {
+		conds = append(conds, fmt.Sprintf(`phone_number LIKE %s ESCAPE '\'`, args.add(likePrefix(phonePrefix))))
+	}
+	if query.RegisteredAfter != nil {
+		conds = append(conds, "registered_at > "+args.add(*query.RegisteredAfter))
+	}
+	if query.RegisteredBefore != nil {
+		conds = append(conds, "registered_at < "+args.add(*query.RegisteredBefore))
+	}
+
+	switch query.Status {
+	case StatusActive:
+		conds = append(conds, fmt.Sprintf(
+			"(status = %s OR (status = %s AND status_expires_at <= %s))",
+			args.add(StatusActive), args.add(StatusSuspended), args.add(now),
+		))
+	case StatusSuspended:
+		conds = append(conds, fmt.Sprintf(
+			"status = %s AND (status_expires_at IS NULL OR status_expires_at > %s)",
+			args.add(StatusSuspended), args.add(now),
+		))
+	case StatusBanned:
+		conds = append(conds, "status = "+args.add(StatusBanned))
+	}
+
+	if query.Role != "" {
+		conds = append(conds, "role = "+args.add(query.Role))
+	}
+
+	return strings.Join(conds, " AND ")
+}
+
+// userQueryOrderBy renders the ORDER BY clause of query. Field names come
+// from the ParseSort whitelist, so they are safe to interpolate.
+func userQueryOrderBy(query UserQuery) string {
+	fields := query.Sort
+	if len(fields) == 0 {
+		fields = DefaultSort
+	}
+
+	var order []string
+	for _, f := range fields {
+		if f.Desc {
+			order = append(order, f.Field+" DESC")
+		} else {
+			order = append(order, f.Field+" ASC")
+		}
+	}
+
+	return strings.Join(append(order, "id ASC"), ", ")
+}
+
+func likePrefix(prefix string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(prefix) + "%"
+}
+
+func totalPages(totalCount int64, pageSize int) int {
+	return int(math.Ceil(float64(totalCount) / float64(pageSize)))
+}
@@ -0,0 +1,794 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+//go:embed migrations/sqlite/*.sql
+var sqliteMigrations embed.FS
+
+// sqliteTimeFormat is fixed width so stored times sort and compare correctly
+// as text. The driver parses it back into a time.Time for DATETIME columns.
+const sqliteTimeFormat = "2006-01-02T15:04:05.000000000Z"
+
+type SQLiteUserRepository struct {
+	db      *sql.DB
+	timeout time.Duration
+}
+
+// NewSQLiteUserRepository opens, creating it if needed, the database file at
+// path and migrates the schema within ctx. Every later operation is bounded
+// by timeout on top of the deadline of its own context.
+func NewSQLiteUserRepository(ctx context.Context, path string, timeout time.Duration) (*SQLiteUserRepository, error) {
+	dsn := "file:" + path + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SQLite database: %w", err)
+	}
+
+	// SQLite allows a single writer at a time; a single connection avoids
+	// SQLITE_BUSY errors between our own connections.
+	db.SetMaxOpenConns(1)
+
+	err = db.PingContext(ctx)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open SQLite database: %w", err)
+	}
+
+	err = migrateSQLite(ctx, db)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate SQLite database: %w", err)
+	}
+
+	return &SQLiteUserRepository{db: db, timeout: timeout}, nil
+}
+
+// migrateSQLite applies the embedded migrations that haven't been applied
+// yet, in file name order, each in its own transaction.
+func migrateSQLite(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    TEXT PRIMARY KEY,
+		applied_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		return err
+	}
+
+	files, err := fs.Glob(sqliteMigrations, "migrations/sqlite/*.sql")
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		version := strings.TrimSuffix(file[strings.LastIndex(file, "/")+1:], ".sql")
+
+		script, err := sqliteMigrations.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		err = func() error {
+			tx, err := db.BeginTx(ctx, nil)
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback()
+
+			var applied bool
+			err = tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = ?1)", version).Scan(&applied)
+			if err != nil || applied {
+				return err
+			}
+
+			if _, err := tx.ExecContext(ctx, string(script)); err != nil {
+				return fmt.Errorf("migration %s: %w", version, err)
+			}
+
+			if _, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version) VALUES (?1)", version); err != nil {
+				return err
+			}
+
+			return tx.Commit()
+		}()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close waits for in-flight queries to finish, ignoring ctx, as
+// database/sql offers no way to bound it.
+func (r *SQLiteUserRepository) Close(context.Context) error {
+	return r.db.Close()
+}
+
+func (r *SQLiteUserRepository) HealthCheck(ctx context.Context) error {
+	return r.db.PingContext(ctx)
+}
+
+func sqliteArgs() *sqlArgs {
+	return &sqlArgs{
+		placeholder: func(n int) string { return fmt.Sprintf("?%d", n) },
+		convert: func(v any) any {
+			if t, ok := v.(time.Time); ok {
+				return t.UTC().Format(sqliteTimeFormat)
+			}
+			return v
+		},
+	}
+}
+
+func isSQLiteUniqueViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+}
+
+func isSQLitePrimaryKeyViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY
+}
+
+func (r *SQLiteUserRepository) Create(ctx context.Context, phoneNumber string) (*User, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	now := time.Now().UTC()
+	user := &User{
+		ID:           bson.NewObjectID(),
+		PhoneNumber:  phoneNumber,
+		Role:         RoleUser,
+		Status:       StatusActive,
+		RegisteredAt: now,
+		UpdatedAt:    now,
+	}
+
+	args := sqliteArgs()
+	stmt := fmt.Sprintf(
+		"INSERT INTO users (id, phone_number, role, status, registered_at, updated_at) VALUES (%s, %s, %s, %s, %s, %s)",
+		args.add(user.ID.Hex()), args.add(user.PhoneNumber), args.add(string(user.Role)), args.add(string(user.Status)),
+		args.add(user.RegisteredAt), args.add(user.UpdatedAt),
+	)
+
+	_, err := r.db.ExecContext(ctx, stmt, args.values...)
+	if err != nil {
+		if isSQLiteUniqueViolation(err) {
+			return nil, ErrDuplicatePhone
+		}
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+
+	return user, nil
+}
+
+func (r *SQLiteUserRepository) FindByID(ctx context.Context, id string) (*User, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	if _, err := bson.ObjectIDFromHex(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	row := r.db.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE id = ?1 AND deleted_at IS NULL", id)
+	return r.scanOne(row, "failed to find user")
+}
+
+func (r *SQLiteUserRepository) FindByPhone(ctx context.Context, phoneNumber string) (*User, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	row := r.db.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE phone_number = ?1 AND deleted_at IS NULL", phoneNumber)
+	return r.scanOne(row, "failed to find user")
+}
+
+func (r *SQLiteUserRepository) Upsert(ctx context.Context, phoneNumber string) (*User, error) {
+	possibleUser, err := r.FindByPhone(ctx, phoneNumber)
+	if err != nil {
+		if !errors.Is(err, ErrUserNotFound) {
+			return nil, err
+		}
+
+		createdUser, err := r.Create(ctx, phoneNumber)
+		if errors.Is(err, ErrDuplicatePhone) {
+			// Lost a race with a concurrent registration of the same number.
+			return r.FindByPhone(ctx, phoneNumber)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return createdUser, nil
+	}
+
+	return possibleUser, nil
+}
+
+func (r *SQLiteUserRepository) UpdateProfile(ctx context.Context, id string, patch ProfilePatch) (*User, error) {
+	args := sqliteArgs()
+	sets := []string{"updated_at = " + args.add(time.Now())}
+
+	fields := make([]string, 0, len(patch))
+	for field := range patch {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	// Field names are validated by ParseProfilePatch against the profile
+	// whitelist, so they are safe to interpolate.
+	for _, field := range fields {
+		if value := patch[field]; value != nil {
+			sets = append(sets, field+" = "+args.add(*value))
+		} else {
+			sets = append(sets, field+" = NULL")
+		}
+	}
+	if _, ok := patch["email"]; ok {
+		sets = append(sets, "email_verified_at = NULL")
+	}
+
+	return r.updateOne(ctx, id, strings.Join(sets, ", "), "deleted_at IS NULL", args, "failed to update user")
+}
+
+func (r *SQLiteUserRepository) VerifyEmail(ctx context.Context, id string, email string) (*User, error) {
+	args := sqliteArgs()
+	now := args.add(time.Now())
+	cond := "deleted_at IS NULL AND email = " + args.add(email)
+
+	user, err := r.updateOne(ctx, id, "email_verified_at = "+now+", updated_at = "+now, cond, args, "failed to verify user email")
+	if errors.Is(err, ErrUserNotFound) {
+		if _, findErr := r.FindByID(ctx, id); findErr == nil {
+			return nil, ErrConflict
+		}
+	}
+
+	return user, err
+}
+
+func (r *SQLiteUserRepository) Delete(ctx context.Context, id string) error {
+	args := sqliteArgs()
+	now := args.add(time.Now())
+
+	_, err := r.updateOne(ctx, id, "deleted_at = "+now+", updated_at = "+now, "deleted_at IS NULL", args, "failed to delete user")
+	return err
+}
+
+func (r *SQLiteUserRepository) Restore(ctx context.Context, id string) (*User, error) {
+	args := sqliteArgs()
+	sets := "deleted_at = NULL, updated_at = " + args.add(time.Now())
+
+	user, err := r.updateOne(ctx, id, sets, "deleted_at IS NOT NULL", args, "failed to restore user")
+	if errors.Is(err, ErrUserNotFound) {
+		if _, findErr := r.FindByID(ctx, id); findErr == nil {
+			return nil, ErrConflict
+		}
+	}
+
+	return user, err
+}
+
+func (r *SQLiteUserRepository) SetStatus(ctx context.Context, id string, change StatusChange) (*User, error) {
+	args := sqliteArgs()
+
+	var reason, expiresAt any
+	if change.Reason != "" {
+		reason = change.Reason
+	}
+	if change.ExpiresAt != nil {
+		expiresAt = *change.ExpiresAt
+	}
+
+	sets := fmt.Sprintf(
+		"status = %s, status_reason = %s, status_expires_at = %s, updated_at = %s",
+		args.add(string(change.Status)), args.add(reason), args.add(expiresAt), args.add(time.Now()),
+	)
+
+	return r.updateOne(ctx, id, sets, "deleted_at IS NULL", args, "failed to update user status")
+}
+
+func (r *SQLiteUserRepository) SetRole(ctx context.Context, id string, role Role) (*User, error) {
+	args := sqliteArgs()
+	sets := fmt.Sprintf("role = %s, updated_at = %s", args.add(string(role)), args.add(time.Now()))
+
+	return r.updateOne(ctx, id, sets, "deleted_at IS NULL", args, "failed to update user role")
+}
+
+func (r *SQLiteUserRepository) SetTOTP(ctx context.Context, id string, totp *TOTP, recoveryCodeHashes []string) (*User, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	if _, err := bson.ObjectIDFromHex(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update user TOTP: %w", err)
+	}
+	defer tx.Rollback()
+
+	args := sqliteArgs()
+	secret, confirmedAt, lastStep := totpValues(totp)
+	stmt := fmt.Sprintf(
+		"UPDATE users SET totp_secret = %s, totp_confirmed_at = %s, totp_last_step = %s, updated_at = %s WHERE id = %s AND deleted_at IS NULL RETURNING %s",
+		args.add(secret), args.add(confirmedAt), args.add(lastStep), args.add(time.Now()), args.add(id), userColumns,
+	)
+	user, err := r.scanOne(tx.QueryRowContext(ctx, stmt, args.values...), "failed to update user TOTP")
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM user_recovery_codes WHERE user_id = ?1", id); err != nil {
+		return nil, fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	if totp != nil {
+		for _, hash := range recoveryCodeHashes {
+			if _, err := tx.ExecContext(ctx, "INSERT INTO user_recovery_codes (user_id, code_hash) VALUES (?1, ?2)", id, hash); err != nil {
+				return nil, fmt.Errorf("failed to store recovery codes: %w", err)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to update user TOTP: %w", err)
+	}
+	return user, nil
+}
+
+func (r *SQLiteUserRepository) UseTOTPStep(ctx context.Context, id string, step int64) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	if _, err := bson.ObjectIDFromHex(id); err != nil {
+		return ErrInvalidID
+	}
+
+	result, err := r.db.ExecContext(ctx,
+		`UPDATE users SET totp_last_step = ?1
+		WHERE id = ?2 AND deleted_at IS NULL AND totp_secret IS NOT NULL AND totp_last_step < ?1`,
+		step, id,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update user TOTP: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return r.conflictOrNotFound(ctx, id)
+	}
+
+	return nil
+}
+
+func (r *SQLiteUserRepository) UseRecoveryCode(ctx context.Context, id string, hash string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	if _, err := bson.ObjectIDFromHex(id); err != nil {
+		return ErrInvalidID
+	}
+
+	result, err := r.db.ExecContext(ctx,
+		`DELETE FROM user_recovery_codes WHERE user_id = ?1 AND code_hash = ?2
+		AND EXISTS (SELECT 1 FROM users WHERE id = ?1 AND deleted_at IS NULL)`,
+		id, hash,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to use recovery code: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return r.conflictOrNotFound(ctx, id)
+	}
+
+	return nil
+}
+
+func (r *SQLiteUserRepository) Passkeys(ctx context.Context, id string) ([]Passkey, error) {
+	if _, err := r.FindByID(ctx, id); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx, "SELECT "+passkeyColumns+" FROM user_passkeys WHERE user_id = ?1 ORDER BY created_at, id", id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find passkeys: %w", err)
+	}
+	defer rows.Close()
+
+	passkeys := []Passkey{}
+	for rows.Next() {
+		passkey, err := scanPasskey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode passkey: %w", err)
+		}
+		passkeys = append(passkeys, passkey)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to find passkeys: %w", err)
+	}
+
+	return passkeys, nil
+}
+
+func (r *SQLiteUserRepository) AddPasskey(ctx context.Context, id string, passkey Passkey) error {
+	if _, err := r.FindByID(ctx, id); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	args := sqliteArgs()
+	stmt := fmt.Sprintf(
+		"INSERT INTO user_passkeys (user_id, %s) VALUES (%s, %s, %s, %s, %s, %s, %s, %s, %s, NULL)",
+		passkeyColumns, args.add(id), args.add(passkey.ID), args.add(passkey.PublicKey), args.add(passkey.AttestationType),
+		args.add(strings.Join(passkey.Transports, ",")), args.add(passkey.AAGUID), args.add(passkey.SignCount),
+		args.add(passkey.BackupEligible), args.add(passkey.CreatedAt),
+	)
+
+	if _, err := r.db.ExecContext(ctx, stmt, args.values...); err != nil {
+		if isSQLiteUniqueViolation(err) || isSQLitePrimaryKeyViolation(err) {
+			return ErrConflict
+		}
+		return fmt.Errorf("failed to add passkey: %w", err)
+	}
+
+	return nil
+}
+
+func (r *SQLiteUserRepository) UsePasskey(ctx context.Context, id string, passkeyID []byte, signCount uint32) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	if _, err := bson.ObjectIDFromHex(id); err != nil {
+		return ErrInvalidID
+	}
+
+	args := sqliteArgs()
+	stmt := fmt.Sprintf(
+		`UPDATE user_passkeys SET sign_count = %s, last_used_at = %s WHERE user_id = %s AND id = %s
+		AND EXISTS (SELECT 1 FROM users WHERE users.id = user_passkeys.user_id AND deleted_at IS NULL)`,
+		args.add(signCount), args.add(time.Now()), args.add(id), args.add(passkeyID),
+	)
+	result, err := r.db.ExecContext(ctx, stmt, args.values...)
+	if err != nil {
+		return fmt.Errorf("failed to update passkey: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return r.conflictOrNotFound(ctx, id)
+	}
+
+	return nil
+}
+
+func (r *SQLiteUserRepository) Devices(ctx context.Context, id string) ([]Device, error) {
+	if _, err := r.FindByID(ctx, id); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx, "SELECT "+deviceColumns+" FROM user_devices WHERE user_id = ?1 ORDER BY created_at, id", id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find devices: %w", err)
+	}
+	defer rows.Close()
+
+	devices := []Device{}
+	for rows.Next() {
+		device, err := scanDevice(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode device: %w", err)
+		}
+		devices = append(devices, device)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to find devices: %w", err)
+	}
+
+	return devices, nil
+}
+
+func (r *SQLiteUserRepository) TrustDevice(ctx context.Context, id string, device Device) error {
+	if _, err := r.FindByID(ctx, id); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	args := sqliteArgs()
+	stmt := fmt.Sprintf(
+		`INSERT INTO user_devices (user_id, %s) VALUES (%s, %s, %s, %s, %s, NULL, %s)
+		ON CONFLICT (user_id, id) DO UPDATE SET name = excluded.name, public_key = excluded.public_key, trusted_until = excluded.trusted_until`,
+		deviceColumns, args.add(id), args.add(device.ID), args.add(device.Name), args.add(device.PublicKey),
+		args.add(device.CreatedAt), args.add(device.TrustedUntil),
+	)
+
+	if _, err := r.db.ExecContext(ctx, stmt, args.values...); err != nil {
+		return fmt.Errorf("failed to trust device: %w", err)
+	}
+
+	return nil
+}
+
+func (r *SQLiteUserRepository) UseDevice(ctx context.Context, id string, deviceID string) error {
+	args := sqliteArgs()
+	return r.execUserRow(ctx, id, "update device", fmt.Sprintf(
+		`UPDATE user_devices SET last_used_at = %s WHERE user_id = %s AND id = %s
+		AND EXISTS (SELECT 1 FROM users WHERE users.id = user_devices.user_id AND deleted_at IS NULL)`,
+		args.add(time.Now()), args.add(id), args.add(deviceID),
+	), args.values)
+}
+
+func (r *SQLiteUserRepository) DeleteDevice(ctx context.Context, id string, deviceID string) error {
+	args := sqliteArgs()
+	return r.execUserRow(ctx, id, "delete device", fmt.Sprintf(
+		`DELETE FROM user_devices WHERE user_id = %s AND id = %s
+		AND EXISTS (SELECT 1 FROM users WHERE users.id = user_devices.user_id AND deleted_at IS NULL)`,
+		args.add(id), args.add(deviceID),
+	), args.values)
+}
+
+// execUserRow runs stmt, which changes a device or session of the user with
+// the given ID, and returns ErrConflict if it changed nothing.
+func (r *SQLiteUserRepository) execUserRow(ctx context.Context, id, action, stmt string, values []any) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	if _, err := bson.ObjectIDFromHex(id); err != nil {
+		return ErrInvalidID
+	}
+
+	result, err := r.db.ExecContext(ctx, stmt, values...)
+	if err != nil {
+		return fmt.Errorf("failed to %s: %w", action, err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return r.conflictOrNotFound(ctx, id)
+	}
+
+	return nil
+}
+
+func (r *SQLiteUserRepository) Sessions(ctx context.Context, id string) ([]Session, error) {
+	if _, err := r.FindByID(ctx, id); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	args := sqliteArgs()
+	stmt := fmt.Sprintf(
+		"SELECT %s FROM user_sessions WHERE user_id = %s AND expires_at > %s ORDER BY created_at, id",
+		sessionColumns, args.add(id), args.add(time.Now()),
+	)
+	rows, err := r.db.QueryContext(ctx, stmt, args.values...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find sessions: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode session: %w", err)
+		}
+		sessions = append(sessions, session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to find sessions: %w", err)
+	}
+
+	return sessions, nil
+}
+
+func (r *SQLiteUserRepository) CreateSession(ctx context.Context, id string, session Session) error {
+	if _, err := r.FindByID(ctx, id); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+	defer tx.Rollback()
+
+	args := sqliteArgs()
+	stmt := fmt.Sprintf("DELETE FROM user_sessions WHERE user_id = %s AND expires_at <= %s", args.add(id), args.add(time.Now()))
+	if _, err := tx.ExecContext(ctx, stmt, args.values...); err != nil {
+		return fmt.Errorf("failed to drop expired sessions: %w", err)
+	}
+
+	args = sqliteArgs()
+	stmt = fmt.Sprintf(
+		"INSERT INTO user_sessions (user_id, %s) VALUES (%s, %s, %s, %s, %s, %s, %s, %s)",
+		sessionColumns, args.add(id), args.add(session.ID), args.add(session.UserAgent), args.add(session.IP),
+		args.add(session.DeviceID), args.add(session.CreatedAt), args.add(session.LastSeenAt), args.add(session.ExpiresAt),
+	)
+	if _, err := tx.ExecContext(ctx, stmt, args.values...); err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+func (r *SQLiteUserRepository) TouchSession(ctx context.Context, id string, sessionID string) error {
+	args := sqliteArgs()
+	now := args.add(time.Now())
+	return r.execUserRow(ctx, id, "update session", fmt.Sprintf(
+		`UPDATE user_sessions SET last_seen_at = %s WHERE user_id = %s AND id = %s AND expires_at > %s
+		AND EXISTS (SELECT 1 FROM users WHERE users.id = user_sessions.user_id AND deleted_at IS NULL)`,
+		now, args.add(id), args.add(sessionID), now,
+	), args.values)
+}
+
+func (r *SQLiteUserRepository) DeleteSession(ctx context.Context, id string, sessionID string) error {
+	args := sqliteArgs()
+	return r.execUserRow(ctx, id, "delete session", fmt.Sprintf(
+		`DELETE FROM user_sessions WHERE user_id = %s AND id = %s
+		AND EXISTS (SELECT 1 FROM users WHERE users.id = user_sessions.user_id AND deleted_at IS NULL)`,
+		args.add(id), args.add(sessionID),
+	), args.values)
+}
+
+func (r *SQLiteUserRepository) DeleteSessions(ctx context.Context, id string) error {
+	if _, err := r.FindByID(ctx, id); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	args := sqliteArgs()
+	if _, err := r.db.ExecContext(ctx, "DELETE FROM user_sessions WHERE user_id = "+args.add(id), args.values...); err != nil {
+		return fmt.Errorf("failed to delete sessions: %w", err)
+	}
+
+	return nil
+}
+
+// conflictOrNotFound returns ErrUserNotFound if the user with the given ID
+// doesn't exist and ErrConflict otherwise.
+func (r *SQLiteUserRepository) conflictOrNotFound(ctx context.Context, id string) error {
+	if _, err := r.FindByID(ctx, id); err != nil {
+		return err
+	}
+	return ErrConflict
+}
+
+func (r *SQLiteUserRepository) Purge(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	args := sqliteArgs()
+	result, err := r.db.ExecContext(ctx, "DELETE FROM users WHERE deleted_at < "+args.add(deletedBefore), args.values...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge users: %w", err)
+	}
+
+	return result.RowsAffected()
+}
+
+func (r *SQLiteUserRepository) SearchByPhone(ctx context.Context, phonePrefix string, query UserQuery) (*PaginatedUsers, error) {
+	return r.findPaginated(ctx, phonePrefix, query)
+}
+
+func (r *SQLiteUserRepository) GetAll(ctx context.Context, query UserQuery) (*PaginatedUsers, error) {
+	return r.findPaginated(ctx, "", query)
+}
+
+func (r *SQLiteUserRepository) findPaginated(ctx context.Context, phonePrefix string, query UserQuery) (*PaginatedUsers, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	args := sqliteArgs()
+	where := userQueryWhere(query, phonePrefix, time.Now(), args)
+
+	var totalCount int64
+	err := r.db.QueryRowContext(ctx, "SELECT count(*) FROM users WHERE "+where, args.values...).Scan(&totalCount)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count users: %w", err)
+	}
+
+	stmt := fmt.Sprintf(
+		"SELECT %s FROM users WHERE %s ORDER BY %s LIMIT %s OFFSET %s",
+		userColumns, where, userQueryOrderBy(query), args.add(query.PageSize), args.add((query.Page-1)*query.PageSize),
+	)
+
+	rows, err := r.db.QueryContext(ctx, stmt, args.values...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find users: %w", err)
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode users: %w", err)
+		}
+		users = append(users, *user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to find users: %w", err)
+	}
+
+	return &PaginatedUsers{
+		Users:      users,
+		Page:       query.Page,
+		PageSize:   query.PageSize,
+		TotalCount: totalCount,
+		TotalPages: totalPages(totalCount, query.PageSize),
+	}, nil
+}
+
+// updateOne applies sets to the user with the given ID if it matches cond,
+// returning the updated user or ErrUserNotFound.
+func (r *SQLiteUserRepository) updateOne(ctx context.Context, id, sets, cond string, args *sqlArgs, errMsg string) (*User, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	if _, err := bson.ObjectIDFromHex(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	stmt := fmt.Sprintf("UPDATE users SET %s WHERE id = %s AND %s RETURNING %s", sets, args.add(id), cond, userColumns)
+	return r.scanOne(r.db.QueryRowContext(ctx, stmt, args.values...), errMsg)
+}
+
+func (r *SQLiteUserRepository) scanOne(row *sql.Row, errMsg string) (*User, error) {
+	user, err := scanUser(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		if isSQLiteUniqueViolation(err) {
+			return nil, ErrDuplicatePhone
+		}
+		return nil, fmt.Errorf("%s: %w", errMsg, err)
+	}
+
+	return user, nil
+}
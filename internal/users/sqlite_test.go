@@ -0,0 +1,23 @@
+package users_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/epicmet/dekamond-task/internal/users"
+	"github.com/epicmet/dekamond-task/internal/users/userstest"
+)
+
+func TestSQLiteUserRepository(t *testing.T) {
+	userstest.Run(t, func(t *testing.T) users.UserRepository {
+		repo, err := users.NewSQLiteUserRepository(t.Context(), filepath.Join(t.TempDir(), "users.db"), 5*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { repo.Close(context.Background()) })
+
+		return repo
+	})
+}
@@ -0,0 +1,68 @@
+package users
+
+import "time"
+
+type Status string
+
+const (
+	StatusActive    Status = "active"
+	StatusSuspended Status = "suspended"
+	StatusBanned    Status = "banned"
+)
+
+func (s Status) Valid() bool {
+	switch s {
+	case StatusActive, StatusSuspended, StatusBanned:
+		return true
+	}
+	return false
+}
+
+// StatusChange is a request to move a user to another status. ExpiresAt is
+// only meaningful for suspensions; a nil value suspends indefinitely.
+type StatusChange struct {
+	Status    Status
+	Reason    string
+	ExpiresAt *time.Time
+}
+
+func (sc StatusChange) Validate(now time.Time) error {
+	if !sc.Status.Valid() {
+		return &ValidationError{Field: "status", Message: "must be one of active, suspended, banned"}
+	}
+	if sc.ExpiresAt != nil {
+		if sc.Status != StatusSuspended {
+			return &ValidationError{Field: "expires_at", Message: "only suspensions can expire"}
+		}
+		if !sc.ExpiresAt.After(now) {
+			return &ValidationError{Field: "expires_at", Message: "must be in the future"}
+		}
+	}
+	if sc.Status == StatusActive && sc.Reason != "" {
+		return &ValidationError{Field: "reason", Message: "active users have no status reason"}
+	}
+	if len(sc.Reason) > 500 {
+		return &ValidationError{Field: "reason", Message: "must be at most 500 characters"}
+	}
+	return nil
+}
+
+// EffectiveStatus returns the status of the user at the given time, taking
+// expired suspensions and users created before statuses existed into
+// account.
+func (u *User) EffectiveStatus(now time.Time) Status {
+	switch u.Status {
+	case "":
+		return StatusActive
+	case StatusSuspended:
+		if u.StatusExpiresAt != nil && !now.Before(*u.StatusExpiresAt) {
+			return StatusActive
+		}
+	}
+	return u.Status
+}
+
+// IsBlocked reports whether the user may not request codes or use tokens.
+func (u *User) IsBlocked(now time.Time) bool {
+	return u.EffectiveStatus(now) != StatusActive
+}
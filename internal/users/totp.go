@@ -0,0 +1,18 @@
+package users
+
+import "time"
+
+// TOTP is the authenticator app second factor of a user. It is pending
+// until the user proves they can generate codes, which sets ConfirmedAt.
+type TOTP struct {
+	Secret      string     `bson:"secret"`
+	ConfirmedAt *time.Time `bson:"confirmed_at,omitempty"`
+	// LastStep is the time step of the last accepted code. Codes of that
+	// step or earlier ones are rejected, so that a code can't be replayed.
+	LastStep int64 `bson:"last_step"`
+}
+
+// TOTPEnabled reports whether the user has confirmed a TOTP second factor.
+func (u *User) TOTPEnabled() bool {
+	return u.TOTP != nil && u.TOTP.ConfirmedAt != nil
+}
@@ -0,0 +1,216 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/epicmet/dekamond-task/internal/tracing"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type tracedUserRepository struct {
+	repo UserRepository
+}
+
+// WithTracing wraps repo so that every call is recorded as a span. Calls
+// failing with one of the errors documented by UserRepository, such as
+// ErrUserNotFound, aren't marked as failed spans since they are outcomes
+// rather than faults.
+func WithTracing(repo UserRepository) UserRepository {
+	return &tracedUserRepository{repo: repo}
+}
+
+func startSpan(ctx context.Context, method string) (context.Context, trace.Span) {
+	return tracing.Tracer().Start(ctx, "users."+method)
+}
+
+func endSpan(span trace.Span, err error) {
+	var validationErr *ValidationError
+	if err != nil && !errors.Is(err, ErrUserNotFound) && !errors.Is(err, ErrInvalidID) &&
+		!errors.Is(err, ErrConflict) && !errors.As(err, &validationErr) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (r *tracedUserRepository) Create(ctx context.Context, phoneNumber string) (user *User, err error) {
+	ctx, span := startSpan(ctx, "Create")
+	defer func() { endSpan(span, err) }()
+	return r.repo.Create(ctx, phoneNumber)
+}
+
+func (r *tracedUserRepository) FindByID(ctx context.Context, id string) (user *User, err error) {
+	ctx, span := startSpan(ctx, "FindByID")
+	defer func() { endSpan(span, err) }()
+	return r.repo.FindByID(ctx, id)
+}
+
+func (r *tracedUserRepository) FindByPhone(ctx context.Context, phoneNumber string) (user *User, err error) {
+	ctx, span := startSpan(ctx, "FindByPhone")
+	defer func() { endSpan(span, err) }()
+	return r.repo.FindByPhone(ctx, phoneNumber)
+}
+
+func (r *tracedUserRepository) Upsert(ctx context.Context, phoneNumber string) (user *User, err error) {
+	ctx, span := startSpan(ctx, "Upsert")
+	defer func() { endSpan(span, err) }()
+	return r.repo.Upsert(ctx, phoneNumber)
+}
+
+func (r *tracedUserRepository) SearchByPhone(ctx context.Context, phonePrefix string, query UserQuery) (result *PaginatedUsers, err error) {
+	ctx, span := startSpan(ctx, "SearchByPhone")
+	defer func() { endSpan(span, err) }()
+	return r.repo.SearchByPhone(ctx, phonePrefix, query)
+}
+
+func (r *tracedUserRepository) GetAll(ctx context.Context, query UserQuery) (result *PaginatedUsers, err error) {
+	ctx, span := startSpan(ctx, "GetAll")
+	defer func() { endSpan(span, err) }()
+	return r.repo.GetAll(ctx, query)
+}
+
+func (r *tracedUserRepository) UpdateProfile(ctx context.Context, id string, patch ProfilePatch) (user *User, err error) {
+	ctx, span := startSpan(ctx, "UpdateProfile")
+	defer func() { endSpan(span, err) }()
+	return r.repo.UpdateProfile(ctx, id, patch)
+}
+
+func (r *tracedUserRepository) VerifyEmail(ctx context.Context, id string, email string) (user *User, err error) {
+	ctx, span := startSpan(ctx, "VerifyEmail")
+	defer func() { endSpan(span, err) }()
+	return r.repo.VerifyEmail(ctx, id, email)
+}
+
+func (r *tracedUserRepository) Delete(ctx context.Context, id string) (err error) {
+	ctx, span := startSpan(ctx, "Delete")
+	defer func() { endSpan(span, err) }()
+	return r.repo.Delete(ctx, id)
+}
+
+func (r *tracedUserRepository) Restore(ctx context.Context, id string) (user *User, err error) {
+	ctx, span := startSpan(ctx, "Restore")
+	defer func() { endSpan(span, err) }()
+	return r.repo.Restore(ctx, id)
+}
+
+func (r *tracedUserRepository) SetStatus(ctx context.Context, id string, change StatusChange) (user *User, err error) {
+	ctx, span := startSpan(ctx, "SetStatus")
+	defer func() { endSpan(span, err) }()
+	return r.repo.SetStatus(ctx, id, change)
+}
+
+func (r *tracedUserRepository) SetRole(ctx context.Context, id string, role Role) (user *User, err error) {
+	ctx, span := startSpan(ctx, "SetRole")
+	defer func() { endSpan(span, err) }()
+	return r.repo.SetRole(ctx, id, role)
+}
+
+func (r *tracedUserRepository) SetTOTP(ctx context.Context, id string, totp *TOTP, recoveryCodeHashes []string) (user *User, err error) {
+	ctx, span := startSpan(ctx, "SetTOTP")
+	defer func() { endSpan(span, err) }()
+	return r.repo.SetTOTP(ctx, id, totp, recoveryCodeHashes)
+}
+
+func (r *tracedUserRepository) UseTOTPStep(ctx context.Context, id string, step int64) (err error) {
+	ctx, span := startSpan(ctx, "UseTOTPStep")
+	defer func() { endSpan(span, err) }()
+	return r.repo.UseTOTPStep(ctx, id, step)
+}
+
+func (r *tracedUserRepository) UseRecoveryCode(ctx context.Context, id string, hash string) (err error) {
+	ctx, span := startSpan(ctx, "UseRecoveryCode")
+	defer func() { endSpan(span, err) }()
+	return r.repo.UseRecoveryCode(ctx, id, hash)
+}
+
+func (r *tracedUserRepository) Passkeys(ctx context.Context, id string) (passkeys []Passkey, err error) {
+	ctx, span := startSpan(ctx, "Passkeys")
+	defer func() { endSpan(span, err) }()
+	return r.repo.Passkeys(ctx, id)
+}
+
+func (r *tracedUserRepository) AddPasskey(ctx context.Context, id string, passkey Passkey) (err error) {
+	ctx, span := startSpan(ctx, "AddPasskey")
+	defer func() { endSpan(span, err) }()
+	return r.repo.AddPasskey(ctx, id, passkey)
+}
+
+func (r *tracedUserRepository) UsePasskey(ctx context.Context, id string, passkeyID []byte, signCount uint32) (err error) {
+	ctx, span := startSpan(ctx, "UsePasskey")
+	defer func() { endSpan(span, err) }()
+	return r.repo.UsePasskey(ctx, id, passkeyID, signCount)
+}
+
+func (r *tracedUserRepository) Devices(ctx context.Context, id string) (devices []Device, err error) {
+	ctx, span := startSpan(ctx, "Devices")
+	defer func() { endSpan(span, err) }()
+	return r.repo.Devices(ctx, id)
+}
+
+func (r *tracedUserRepository) TrustDevice(ctx context.Context, id string, device Device) (err error) {
+	ctx, span := startSpan(ctx, "TrustDevice")
+	defer func() { endSpan(span, err) }()
+	return r.repo.TrustDevice(ctx, id, device)
+}
+
+func (r *tracedUserRepository) UseDevice(ctx context.Context, id string, deviceID string) (err error) {
+	ctx, span := startSpan(ctx, "UseDevice")
+	defer func() { endSpan(span, err) }()
+	return r.repo.UseDevice(ctx, id, deviceID)
+}
+
+func (r *tracedUserRepository) DeleteDevice(ctx context.Context, id string, deviceID string) (err error) {
+	ctx, span := startSpan(ctx, "DeleteDevice")
+	defer func() { endSpan(span, err) }()
+	return r.repo.DeleteDevice(ctx, id, deviceID)
+}
+
+func (r *tracedUserRepository) Sessions(ctx context.Context, id string) (sessions []Session, err error) {
+	ctx, span := startSpan(ctx, "Sessions")
+	defer func() { endSpan(span, err) }()
+	return r.repo.Sessions(ctx, id)
+}
+
+func (r *tracedUserRepository) CreateSession(ctx context.Context, id string, session Session) (err error) {
+	ctx, span := startSpan(ctx, "CreateSession")
+	defer func() { endSpan(span, err) }()
+	return r.repo.CreateSession(ctx, id, session)
+}
+
+func (r *tracedUserRepository) TouchSession(ctx context.Context, id string, sessionID string) (err error) {
+	ctx, span := startSpan(ctx, "TouchSession")
+	defer func() { endSpan(span, err) }()
+	return r.repo.TouchSession(ctx, id, sessionID)
+}
+
+func (r *tracedUserRepository) DeleteSession(ctx context.Context, id string, sessionID string) (err error) {
+	ctx, span := startSpan(ctx, "DeleteSession")
+	defer func() { endSpan(span, err) }()
+	return r.repo.DeleteSession(ctx, id, sessionID)
+}
+
+func (r *tracedUserRepository) DeleteSessions(ctx context.Context, id string) (err error) {
+	ctx, span := startSpan(ctx, "DeleteSessions")
+	defer func() { endSpan(span, err) }()
+	return r.repo.DeleteSessions(ctx, id)
+}
+
+func (r *tracedUserRepository) Purge(ctx context.Context, deletedBefore time.Time) (purged int64, err error) {
+	ctx, span := startSpan(ctx, "Purge")
+	defer func() { endSpan(span, err) }()
+	return r.repo.Purge(ctx, deletedBefore)
+}
+
+// Close and HealthCheck aren't part of serving a request, so they aren't
+// traced.
+
+func (r *tracedUserRepository) Close(ctx context.Context) error {
+	return r.repo.Close(ctx)
+}
+
+func (r *tracedUserRepository) HealthCheck(ctx context.Context) error {
+	return r.repo.HealthCheck(ctx)
+}
@@ -1,16 +1,38 @@
 package users
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"time"
 
 	"go.mongodb.org/mongo-driver/v2/bson"
 )
 
 type User struct {
-	ID           bson.ObjectID `json:"id" bson:"_id,omitempty"`
-	PhoneNumber  string        `json:"phone_number" bson:"phone_number"`
-	RegisteredAt time.Time     `json:"registered_at" bson:"registered_at"`
+	ID          bson.ObjectID `json:"id" bson:"_id,omitempty"`
+	PhoneNumber string        `json:"phone_number" bson:"phone_number"`
+	FirstName   string        `json:"first_name,omitempty" bson:"first_name,omitempty"`
+	LastName    string        `json:"last_name,omitempty" bson:"last_name,omitempty"`
+	Email       string        `json:"email,omitempty" bson:"email,omitempty"`
+	AvatarURL   string        `json:"avatar_url,omitempty" bson:"avatar_url,omitempty"`
+	Locale      string        `json:"locale,omitempty" bson:"locale,omitempty"`
+	Timezone    string        `json:"timezone,omitempty" bson:"timezone,omitempty"`
+
+	// EmailVerifiedAt is when the user confirmed they own Email. Setting or
+	// removing Email clears it.
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty" bson:"email_verified_at,omitempty"`
+
+	Role            Role       `json:"role" bson:"role,omitempty"`
+	Status          Status     `json:"status" bson:"status,omitempty"`
+	StatusReason    string     `json:"status_reason,omitempty" bson:"status_reason,omitempty"`
+	StatusExpiresAt *time.Time `json:"status_expires_at,omitempty" bson:"status_expires_at,omitempty"`
+
+	TOTP *TOTP `json:"-" bson:"totp,omitempty"`
+
+	RegisteredAt time.Time  `json:"registered_at" bson:"registered_at"`
+	UpdatedAt    time.Time  `json:"updated_at" bson:"updated_at"`
+	DeletedAt    *time.Time `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
 }
 
 type PaginatedUsers struct {
@@ -22,12 +44,88 @@ type PaginatedUsers struct {
 }
 
 type UserRepository interface {
-	Create(phoneNumber string) (*User, error)
-	FindByID(id string) (*User, error)
-	FindByPhone(phoneNumber string) (*User, error)
-	Upsert(phoneNumber string) (*User, error)
-	SearchByPhone(phonePrefix string, page, pageSize int) (*PaginatedUsers, error)
-	GetAll(page, pageSize int) (*PaginatedUsers, error)
+	Create(ctx context.Context, phoneNumber string) (*User, error)
+	FindByID(ctx context.Context, id string) (*User, error)
+	FindByPhone(ctx context.Context, phoneNumber string) (*User, error)
+	Upsert(ctx context.Context, phoneNumber string) (*User, error)
+	SearchByPhone(ctx context.Context, phonePrefix string, query UserQuery) (*PaginatedUsers, error)
+	GetAll(ctx context.Context, query UserQuery) (*PaginatedUsers, error)
+	UpdateProfile(ctx context.Context, id string, patch ProfilePatch) (*User, error)
+	// VerifyEmail records that a user confirmed they own email. It returns
+	// ErrConflict if email is no longer the user's address.
+	VerifyEmail(ctx context.Context, id string, email string) (*User, error)
+	// Delete soft deletes a user. Deleted users are hidden from every other
+	// method except Restore and Purge.
+	Delete(ctx context.Context, id string) error
+	// Restore undeletes a user. It returns ErrConflict if the user isn't
+	// deleted, and ErrDuplicatePhone if its phone number has been registered
+	// again in the meantime.
+	Restore(ctx context.Context, id string) (*User, error)
+	SetStatus(ctx context.Context, id string, change StatusChange) (*User, error)
+	SetRole(ctx context.Context, id string, role Role) (*User, error)
+	// SetTOTP replaces the TOTP second factor of a user and its recovery
+	// codes, given as hashes, or removes both if totp is nil.
+	SetTOTP(ctx context.Context, id string, totp *TOTP, recoveryCodeHashes []string) (*User, error)
+	// UseTOTPStep records that a code of the given time step was accepted.
+	// It returns ErrConflict if the user has no TOTP or a code of that step
+	// or a later one was accepted already.
+	UseTOTPStep(ctx context.Context, id string, step int64) error
+	// UseRecoveryCode consumes the recovery code with the given hash. It
+	// returns ErrConflict if the user has no such code.
+	UseRecoveryCode(ctx context.Context, id string, hash string) error
+	// Passkeys returns the passkeys of a user, oldest first.
+	Passkeys(ctx context.Context, id string) ([]Passkey, error)
+	// AddPasskey registers a passkey for a user. It returns ErrConflict if
+	// a passkey with the same ID is registered already.
+	AddPasskey(ctx context.Context, id string, passkey Passkey) error
+	// UsePasskey records a login with the passkey of a user with the given
+	// ID and its new signature counter. It returns ErrConflict if the user
+	// has no such passkey.
+	UsePasskey(ctx context.Context, id string, passkeyID []byte, signCount uint32) error
+	// Devices returns the trusted devices of a user, oldest first.
+	Devices(ctx context.Context, id string) ([]Device, error)
+	// TrustDevice adds a trusted device for a user, or replaces the name,
+	// public key and trust period of the user's device with the same ID.
+	TrustDevice(ctx context.Context, id string, device Device) error
+	// UseDevice records a login with the device of a user with the given ID.
+	// It returns ErrConflict if the user has no such device.
+	UseDevice(ctx context.Context, id string, deviceID string) error
+	// DeleteDevice revokes the device of a user with the given ID. It
+	// returns ErrConflict if the user has no such device.
+	DeleteDevice(ctx context.Context, id string, deviceID string) error
+	// Sessions returns the sessions of a user that haven't expired, oldest
+	// first.
+	Sessions(ctx context.Context, id string) ([]Session, error)
+	// CreateSession adds a session for a user and drops its expired ones.
+	CreateSession(ctx context.Context, id string, session Session) error
+	// TouchSession records that the session of a user with the given ID was
+	// just used. It returns ErrConflict if the user has no such session or
+	// it has expired.
+	TouchSession(ctx context.Context, id string, sessionID string) error
+	// DeleteSession ends the session of a user with the given ID. It returns
+	// ErrConflict if the user has no such session.
+	DeleteSession(ctx context.Context, id string, sessionID string) error
+	// DeleteSessions ends every session of a user.
+	DeleteSessions(ctx context.Context, id string) error
+	// Purge permanently removes users soft deleted before deletedBefore and
+	// returns how many were removed.
+	Purge(ctx context.Context, deletedBefore time.Time) (int64, error)
+	// Close releases the connections to the underlying store. ctx bounds how
+	// long in-flight operations are waited for.
+	Close(ctx context.Context) error
+	// HealthCheck reports whether the underlying store is reachable.
+	HealthCheck(ctx context.Context) error
 }
 
-var ErrUserNotFound = errors.New("user not found")
+// Errors returned by every UserRepository implementation. Other errors are
+// failures of the underlying store.
+var (
+	ErrUserNotFound = errors.New("user not found")
+	ErrInvalidID    = errors.New("invalid user ID")
+	// ErrConflict is returned when the request conflicts with the current
+	// state of the user, e.g. restoring a user that isn't deleted.
+	ErrConflict = errors.New("conflict with the current state of the user")
+	// ErrDuplicatePhone is a conflict with another user holding the same
+	// phone number.
+	ErrDuplicatePhone = fmt.Errorf("%w: phone number is already registered", ErrConflict)
+)
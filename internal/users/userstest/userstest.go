@@ -0,0 +1,864 @@
+// Package userstest provides a conformance suite that every implementation
+// of users.UserRepository must pass.
+package userstest
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/epicmet/dekamond-task/internal/users"
+)
+
+// Run runs the conformance suite. newRepo must return an empty repository
+// that is not shared with other tests.
+func Run(t *testing.T, newRepo func(t *testing.T) users.UserRepository) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, repo users.UserRepository)
+	}{
+		{"CreateAndFind", testCreateAndFind},
+		{"DuplicatePhone", testDuplicatePhone},
+		{"NotFound", testNotFound},
+		{"Upsert", testUpsert},
+		{"ConcurrentUpsert", testConcurrentUpsert},
+		{"UpdateProfile", testUpdateProfile},
+		{"VerifyEmail", testVerifyEmail},
+		{"SearchByPhone", testSearchByPhone},
+		{"Pagination", testPagination},
+		{"Sort", testSort},
+		{"RegisteredFilters", testRegisteredFilters},
+		{"SoftDelete", testSoftDelete},
+		{"Purge", testPurge},
+		{"Status", testStatus},
+		{"Role", testRole},
+		{"TOTP", testTOTP},
+		{"Passkeys", testPasskeys},
+		{"Devices", testDevices},
+		{"Sessions", testSessions},
+		{"HealthCheck", testHealthCheck},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newRepo(t))
+		})
+	}
+}
+
+func mustCreate(t *testing.T, repo users.UserRepository, phoneNumber string) *users.User {
+	t.Helper()
+
+	user, err := repo.Create(t.Context(), phoneNumber)
+	if err != nil {
+		t.Fatalf("Create(%q): %v", phoneNumber, err)
+	}
+	return user
+}
+
+func query(page, pageSize int) users.UserQuery {
+	return users.UserQuery{Page: page, PageSize: pageSize}
+}
+
+func phoneNumbers(result *users.PaginatedUsers) []string {
+	var pns []string
+	for _, u := range result.Users {
+		pns = append(pns, u.PhoneNumber)
+	}
+	return pns
+}
+
+func assertPhoneNumbers(t *testing.T, result *users.PaginatedUsers, want ...string) {
+	t.Helper()
+
+	if got := phoneNumbers(result); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("got users %v, want %v", got, want)
+	}
+}
+
+func testCreateAndFind(t *testing.T, repo users.UserRepository) {
+	created := mustCreate(t, repo, "09120000001")
+
+	if created.ID.IsZero() {
+		t.Fatal("Create returned a user without ID")
+	}
+	if created.EffectiveRole() != users.RoleUser || created.EffectiveStatus(time.Now()) != users.StatusActive {
+		t.Fatalf("new user has role %q and status %q", created.Role, created.Status)
+	}
+
+	byID, err := repo.FindByID(t.Context(), created.ID.Hex())
+	if err != nil {
+		t.Fatalf("FindByID: %v", err)
+	}
+	if byID.ID != created.ID || byID.PhoneNumber != created.PhoneNumber {
+		t.Fatalf("FindByID returned %+v, want %+v", byID, created)
+	}
+	if d := byID.RegisteredAt.Sub(created.RegisteredAt).Abs(); d > time.Millisecond {
+		t.Fatalf("registered_at changed by %v after a round trip", d)
+	}
+
+	byPhone, err := repo.FindByPhone(t.Context(), "09120000001")
+	if err != nil {
+		t.Fatalf("FindByPhone: %v", err)
+	}
+	if byPhone.ID != created.ID {
+		t.Fatalf("FindByPhone returned ID %s, want %s", byPhone.ID.Hex(), created.ID.Hex())
+	}
+}
+
+func testDuplicatePhone(t *testing.T, repo users.UserRepository) {
+	mustCreate(t, repo, "09120000001")
+
+	_, err := repo.Create(t.Context(), "09120000001")
+	if !errors.Is(err, users.ErrDuplicatePhone) || !errors.Is(err, users.ErrConflict) {
+		t.Fatalf("second Create returned %v, want ErrDuplicatePhone", err)
+	}
+}
+
+func testNotFound(t *testing.T, repo users.UserRepository) {
+	const missingID = "000000000000000000000001"
+
+	if _, err := repo.FindByID(t.Context(), missingID); !errors.Is(err, users.ErrUserNotFound) {
+		t.Errorf("FindByID returned %v, want ErrUserNotFound", err)
+	}
+	if _, err := repo.FindByPhone(t.Context(), "09120000001"); !errors.Is(err, users.ErrUserNotFound) {
+		t.Errorf("FindByPhone returned %v, want ErrUserNotFound", err)
+	}
+	if _, err := repo.UpdateProfile(t.Context(), missingID, users.ProfilePatch{}); !errors.Is(err, users.ErrUserNotFound) {
+		t.Errorf("UpdateProfile returned %v, want ErrUserNotFound", err)
+	}
+	if _, err := repo.VerifyEmail(t.Context(), missingID, "sara@example.com"); !errors.Is(err, users.ErrUserNotFound) {
+		t.Errorf("VerifyEmail returned %v, want ErrUserNotFound", err)
+	}
+	if err := repo.Delete(t.Context(), missingID); !errors.Is(err, users.ErrUserNotFound) {
+		t.Errorf("Delete returned %v, want ErrUserNotFound", err)
+	}
+	if _, err := repo.Restore(t.Context(), missingID); !errors.Is(err, users.ErrUserNotFound) {
+		t.Errorf("Restore returned %v, want ErrUserNotFound", err)
+	}
+	if _, err := repo.SetStatus(t.Context(), missingID, users.StatusChange{Status: users.StatusBanned}); !errors.Is(err, users.ErrUserNotFound) {
+		t.Errorf("SetStatus returned %v, want ErrUserNotFound", err)
+	}
+	if _, err := repo.SetRole(t.Context(), missingID, users.RoleAdmin); !errors.Is(err, users.ErrUserNotFound) {
+		t.Errorf("SetRole returned %v, want ErrUserNotFound", err)
+	}
+
+	const malformedID = "not-an-id"
+
+	if _, err := repo.FindByID(t.Context(), malformedID); !errors.Is(err, users.ErrInvalidID) {
+		t.Errorf("FindByID with a malformed ID returned %v, want ErrInvalidID", err)
+	}
+	if _, err := repo.UpdateProfile(t.Context(), malformedID, users.ProfilePatch{}); !errors.Is(err, users.ErrInvalidID) {
+		t.Errorf("UpdateProfile with a malformed ID returned %v, want ErrInvalidID", err)
+	}
+	if err := repo.Delete(t.Context(), malformedID); !errors.Is(err, users.ErrInvalidID) {
+		t.Errorf("Delete with a malformed ID returned %v, want ErrInvalidID", err)
+	}
+	if _, err := repo.Restore(t.Context(), malformedID); !errors.Is(err, users.ErrInvalidID) {
+		t.Errorf("Restore with a malformed ID returned %v, want ErrInvalidID", err)
+	}
+	if _, err := repo.SetStatus(t.Context(), malformedID, users.StatusChange{Status: users.StatusBanned}); !errors.Is(err, users.ErrInvalidID) {
+		t.Errorf("SetStatus with a malformed ID returned %v, want ErrInvalidID", err)
+	}
+	if _, err := repo.SetRole(t.Context(), malformedID, users.RoleAdmin); !errors.Is(err, users.ErrInvalidID) {
+		t.Errorf("SetRole with a malformed ID returned %v, want ErrInvalidID", err)
+	}
+}
+
+func testUpsert(t *testing.T, repo users.UserRepository) {
+	first, err := repo.Upsert(t.Context(), "09120000001")
+	if err != nil {
+		t.Fatalf("Upsert: %v", err)
+	}
+
+	second, err := repo.Upsert(t.Context(), "09120000001")
+	if err != nil {
+		t.Fatalf("second Upsert: %v", err)
+	}
+
+	if first.ID != second.ID {
+		t.Fatalf("Upsert created a second user %s for the same number as %s", second.ID.Hex(), first.ID.Hex())
+	}
+}
+
+func testConcurrentUpsert(t *testing.T, repo users.UserRepository) {
+	const workers = 8
+
+	var wg sync.WaitGroup
+	ids := make([]string, workers)
+	errs := make([]error, workers)
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			user, err := repo.Upsert(t.Context(), "09120000001")
+			if err == nil {
+				ids[i] = user.ID.Hex()
+			}
+			errs[i] = err
+		}()
+	}
+	wg.Wait()
+
+	for i := range workers {
+		if errs[i] != nil {
+			t.Fatalf("concurrent Upsert: %v", errs[i])
+		}
+		if ids[i] != ids[0] {
+			t.Fatalf("concurrent Upserts returned users %s and %s for the same number", ids[0], ids[i])
+		}
+	}
+
+	result, err := repo.GetAll(t.Context(), query(1, 10))
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	if result.TotalCount != 1 {
+		t.Fatalf("concurrent Upserts created %d users", result.TotalCount)
+	}
+}
+
+func testUpdateProfile(t *testing.T, repo users.UserRepository) {
+	user := mustCreate(t, repo, "09120000001")
+
+	patch, err := users.ParseProfilePatch([]byte(`{"first_name":"Sara","email":"sara@example.com","timezone":"Asia/Tehran"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updated, err := repo.UpdateProfile(t.Context(), user.ID.Hex(), patch)
+	if err != nil {
+		t.Fatalf("UpdateProfile: %v", err)
+	}
+	if updated.FirstName != "Sara" || updated.Email != "sara@example.com" || updated.Timezone != "Asia/Tehran" {
+		t.Fatalf("UpdateProfile returned %+v", updated)
+	}
+	if updated.UpdatedAt.Before(user.UpdatedAt.Truncate(time.Millisecond)) {
+		t.Fatalf("updated_at went backwards from %v to %v", user.UpdatedAt, updated.UpdatedAt)
+	}
+
+	patch, err = users.ParseProfilePatch([]byte(`{"email":null,"last_name":"Ahmadi"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updated, err = repo.UpdateProfile(t.Context(), user.ID.Hex(), patch)
+	if err != nil {
+		t.Fatalf("UpdateProfile: %v", err)
+	}
+	if updated.FirstName != "Sara" || updated.LastName != "Ahmadi" || updated.Email != "" {
+		t.Fatalf("merge patch applied as %+v", updated)
+	}
+
+	found, err := repo.FindByID(t.Context(), user.ID.Hex())
+	if err != nil {
+		t.Fatalf("FindByID: %v", err)
+	}
+	if found.FirstName != "Sara" || found.LastName != "Ahmadi" || found.Email != "" || found.Timezone != "Asia/Tehran" {
+		t.Fatalf("stored profile is %+v", found)
+	}
+}
+
+func testVerifyEmail(t *testing.T, repo users.UserRepository) {
+	user := mustCreate(t, repo, "09120000001")
+	id := user.ID.Hex()
+
+	if _, err := repo.VerifyEmail(t.Context(), id, ""); !errors.Is(err, users.ErrConflict) {
+		t.Fatalf("VerifyEmail without an email = %v, want ErrConflict", err)
+	}
+
+	patch, err := users.ParseProfilePatch([]byte(`{"email":"sara@example.com"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.UpdateProfile(t.Context(), id, patch); err != nil {
+		t.Fatalf("UpdateProfile: %v", err)
+	}
+	if _, err := repo.VerifyEmail(t.Context(), id, "old@example.com"); !errors.Is(err, users.ErrConflict) {
+		t.Fatalf("VerifyEmail of another address = %v, want ErrConflict", err)
+	}
+
+	verified, err := repo.VerifyEmail(t.Context(), id, "sara@example.com")
+	if err != nil {
+		t.Fatalf("VerifyEmail: %v", err)
+	}
+	if verified.EmailVerifiedAt == nil {
+		t.Fatalf("VerifyEmail returned %+v", verified)
+	}
+	found, err := repo.FindByID(t.Context(), id)
+	if err != nil {
+		t.Fatalf("FindByID: %v", err)
+	}
+	if found.EmailVerifiedAt == nil || !found.EmailVerifiedAt.Equal(*verified.EmailVerifiedAt) {
+		t.Fatalf("stored email_verified_at is %v, want %v", found.EmailVerifiedAt, verified.EmailVerifiedAt)
+	}
+
+	patch, err = users.ParseProfilePatch([]byte(`{"email":"other@example.com"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated, err := repo.UpdateProfile(t.Context(), id, patch)
+	if err != nil {
+		t.Fatalf("UpdateProfile: %v", err)
+	}
+	if updated.EmailVerifiedAt != nil {
+		t.Errorf("changing the email kept email_verified_at %v", updated.EmailVerifiedAt)
+	}
+}
+
+func testSearchByPhone(t *testing.T, repo users.UserRepository) {
+	for _, pn := range []string{"09121111111", "09121112222", "09351111111", "+989121111111"} {
+		mustCreate(t, repo, pn)
+	}
+
+	result, err := repo.SearchByPhone(t.Context(), "0912", users.UserQuery{Page: 1, PageSize: 10, Sort: []users.SortField{{Field: "phone_number"}}})
+	if err != nil {
+		t.Fatalf("SearchByPhone: %v", err)
+	}
+	assertPhoneNumbers(t, result, "09121111111", "09121112222")
+
+	// Only prefixes match, and the prefix is not a pattern.
+	for _, prefix := range []string{"9121", "+98", ".*", "0912%", "0912_"} {
+		result, err := repo.SearchByPhone(t.Context(), prefix, query(1, 10))
+		if err != nil {
+			t.Fatalf("SearchByPhone(%q): %v", prefix, err)
+		}
+
+		want := int64(0)
+		if prefix == "+98" {
+			want = 1
+		}
+		if result.TotalCount != want {
+			t.Errorf("SearchByPhone(%q) matched %v, want %d users", prefix, phoneNumbers(result), want)
+		}
+	}
+}
+
+func testPagination(t *testing.T, repo users.UserRepository) {
+	result, err := repo.GetAll(t.Context(), query(1, 10))
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	if result.Users == nil || len(result.Users) != 0 || result.TotalCount != 0 || result.TotalPages != 0 {
+		t.Fatalf("empty repository returned %+v, want no users and no pages", result)
+	}
+
+	for i := range 6 {
+		mustCreate(t, repo, fmt.Sprintf("0912000000%d", i))
+	}
+
+	for _, tt := range []struct {
+		page, pageSize, wantLen, wantPages int
+	}{
+		{1, 3, 3, 2},
+		{2, 3, 3, 2},
+		{3, 3, 0, 2},
+		{1, 4, 4, 2},
+		{2, 4, 2, 2},
+		{1, 6, 6, 1},
+		{1, 100, 6, 1},
+		{6, 1, 1, 6},
+	} {
+		result, err := repo.GetAll(t.Context(), query(tt.page, tt.pageSize))
+		if err != nil {
+			t.Fatalf("GetAll: %v", err)
+		}
+		if len(result.Users) != tt.wantLen || result.TotalPages != tt.wantPages || result.TotalCount != 6 ||
+			result.Page != tt.page || result.PageSize != tt.pageSize {
+			t.Errorf("page %d of size %d returned %d users, page %d/%d of size %d, total %d; want %d users of %d pages",
+				tt.page, tt.pageSize, len(result.Users), result.Page, result.TotalPages, result.PageSize, result.TotalCount,
+				tt.wantLen, tt.wantPages)
+		}
+	}
+
+	// Pages don't overlap.
+	seen := make(map[string]bool)
+	for page := 1; page <= 3; page++ {
+		result, err := repo.GetAll(t.Context(), query(page, 2))
+		if err != nil {
+			t.Fatalf("GetAll: %v", err)
+		}
+		for _, u := range result.Users {
+			if seen[u.PhoneNumber] {
+				t.Fatalf("user %s returned on more than one page", u.PhoneNumber)
+			}
+			seen[u.PhoneNumber] = true
+		}
+	}
+	if len(seen) != 6 {
+		t.Fatalf("paging through returned %d distinct users, want 6", len(seen))
+	}
+}
+
+func testSort(t *testing.T, repo users.UserRepository) {
+	for _, pn := range []string{"09120000002", "09120000003", "09120000001", "09120000005", "09120000004"} {
+		mustCreate(t, repo, pn)
+		// Keep registration times distinct at millisecond precision.
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	result, err := repo.GetAll(t.Context(), query(1, 2))
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	assertPhoneNumbers(t, result, "09120000004", "09120000005")
+	if result.Page != 1 || result.PageSize != 2 || result.TotalCount != 5 || result.TotalPages != 3 {
+		t.Fatalf("got pagination %+v, want page 1 of 3 with 5 users", result)
+	}
+
+	result, err = repo.GetAll(t.Context(), query(3, 2))
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	assertPhoneNumbers(t, result, "09120000002")
+
+	result, err = repo.GetAll(t.Context(), query(4, 2))
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	if result.Users == nil || len(result.Users) != 0 || result.TotalCount != 5 {
+		t.Fatalf("page past the end returned %+v, want an empty non-nil page", result)
+	}
+
+	sort, err := users.ParseSort("phone_number")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err = repo.GetAll(t.Context(), users.UserQuery{Page: 2, PageSize: 2, Sort: sort})
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	assertPhoneNumbers(t, result, "09120000003", "09120000004")
+}
+
+func testRegisteredFilters(t *testing.T, repo users.UserRepository) {
+	mustCreate(t, repo, "09120000001")
+	time.Sleep(5 * time.Millisecond)
+	middle := time.Now()
+	time.Sleep(5 * time.Millisecond)
+	mustCreate(t, repo, "09120000002")
+
+	result, err := repo.GetAll(t.Context(), users.UserQuery{Page: 1, PageSize: 10, RegisteredAfter: &middle})
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	assertPhoneNumbers(t, result, "09120000002")
+
+	result, err = repo.SearchByPhone(t.Context(), "0912", users.UserQuery{Page: 1, PageSize: 10, RegisteredBefore: &middle})
+	if err != nil {
+		t.Fatalf("SearchByPhone: %v", err)
+	}
+	assertPhoneNumbers(t, result, "09120000001")
+}
+
+func testSoftDelete(t *testing.T, repo users.UserRepository) {
+	user := mustCreate(t, repo, "09120000001")
+
+	if err := repo.Delete(t.Context(), user.ID.Hex()); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := repo.Delete(t.Context(), user.ID.Hex()); !errors.Is(err, users.ErrUserNotFound) {
+		t.Fatalf("second Delete returned %v, want ErrUserNotFound", err)
+	}
+
+	if _, err := repo.FindByID(t.Context(), user.ID.Hex()); !errors.Is(err, users.ErrUserNotFound) {
+		t.Errorf("FindByID returned %v for a deleted user", err)
+	}
+	if _, err := repo.FindByPhone(t.Context(), user.PhoneNumber); !errors.Is(err, users.ErrUserNotFound) {
+		t.Errorf("FindByPhone returned %v for a deleted user", err)
+	}
+	if result, err := repo.GetAll(t.Context(), query(1, 10)); err != nil || result.TotalCount != 0 {
+		t.Errorf("GetAll returned %v, %v with only a deleted user", phoneNumbers(result), err)
+	}
+	if result, err := repo.SearchByPhone(t.Context(), "0912", query(1, 10)); err != nil || result.TotalCount != 0 {
+		t.Errorf("SearchByPhone returned %v, %v with only a deleted user", phoneNumbers(result), err)
+	}
+
+	restored, err := repo.Restore(t.Context(), user.ID.Hex())
+	if err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if restored.ID != user.ID || restored.DeletedAt != nil {
+		t.Fatalf("Restore returned %+v", restored)
+	}
+	if _, err := repo.Restore(t.Context(), user.ID.Hex()); !errors.Is(err, users.ErrConflict) || errors.Is(err, users.ErrDuplicatePhone) {
+		t.Fatalf("Restore of an active user returned %v, want ErrConflict", err)
+	}
+
+	// A deleted number can register again, after which the old user can't
+	// be restored.
+	if err := repo.Delete(t.Context(), user.ID.Hex()); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	again, err := repo.Upsert(t.Context(), user.PhoneNumber)
+	if err != nil {
+		t.Fatalf("Upsert of a deleted number: %v", err)
+	}
+	if again.ID == user.ID {
+		t.Fatal("Upsert of a deleted number returned the deleted user")
+	}
+	_, err = repo.Restore(t.Context(), user.ID.Hex())
+	if !errors.Is(err, users.ErrDuplicatePhone) || !errors.Is(err, users.ErrConflict) {
+		t.Fatalf("Restore over a re-registered number returned %v, want ErrDuplicatePhone", err)
+	}
+}
+
+func testPurge(t *testing.T, repo users.UserRepository) {
+	deleted := mustCreate(t, repo, "09120000001")
+	kept := mustCreate(t, repo, "09120000002")
+
+	if err := repo.Delete(t.Context(), deleted.ID.Hex()); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	purged, err := repo.Purge(t.Context(), time.Now().Add(-time.Hour))
+	if err != nil || purged != 0 {
+		t.Fatalf("Purge before the deletion removed %d users, err %v", purged, err)
+	}
+
+	purged, err = repo.Purge(t.Context(), time.Now().Add(time.Second))
+	if err != nil || purged != 1 {
+		t.Fatalf("Purge removed %d users, err %v, want 1", purged, err)
+	}
+
+	if _, err := repo.Restore(t.Context(), deleted.ID.Hex()); !errors.Is(err, users.ErrUserNotFound) {
+		t.Fatalf("Restore of a purged user returned %v", err)
+	}
+	if _, err := repo.FindByID(t.Context(), kept.ID.Hex()); err != nil {
+		t.Fatalf("Purge removed an active user: %v", err)
+	}
+}
+
+func testStatus(t *testing.T, repo users.UserRepository) {
+	active := mustCreate(t, repo, "09120000001")
+	suspended := mustCreate(t, repo, "09120000002")
+	expired := mustCreate(t, repo, "09120000003")
+	banned := mustCreate(t, repo, "09120000004")
+
+	future := time.Now().Add(time.Hour)
+	past := time.Now().Add(-time.Hour)
+
+	updated, err := repo.SetStatus(t.Context(), suspended.ID.Hex(), users.StatusChange{Status: users.StatusSuspended, Reason: "spam", ExpiresAt: &future})
+	if err != nil {
+		t.Fatalf("SetStatus: %v", err)
+	}
+	if updated.Status != users.StatusSuspended || updated.StatusReason != "spam" || updated.StatusExpiresAt == nil {
+		t.Fatalf("SetStatus returned %+v", updated)
+	}
+
+	// Expired suspensions can't be created through the API, but they are
+	// what every suspension becomes.
+	if _, err := repo.SetStatus(t.Context(), expired.ID.Hex(), users.StatusChange{Status: users.StatusSuspended, ExpiresAt: &past}); err != nil {
+		t.Fatalf("SetStatus: %v", err)
+	}
+	if _, err := repo.SetStatus(t.Context(), banned.ID.Hex(), users.StatusChange{Status: users.StatusBanned, Reason: "fraud"}); err != nil {
+		t.Fatalf("SetStatus: %v", err)
+	}
+
+	for status, want := range map[users.Status][]string{
+		users.StatusActive:    {active.PhoneNumber, expired.PhoneNumber},
+		users.StatusSuspended: {suspended.PhoneNumber},
+		users.StatusBanned:    {banned.PhoneNumber},
+	} {
+		sort, _ := users.ParseSort("phone_number")
+		result, err := repo.GetAll(t.Context(), users.UserQuery{Page: 1, PageSize: 10, Sort: sort, Status: status})
+		if err != nil {
+			t.Fatalf("GetAll: %v", err)
+		}
+		if got := phoneNumbers(result); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("status %s matched %v, want %v", status, got, want)
+		}
+	}
+
+	reactivated, err := repo.SetStatus(t.Context(), suspended.ID.Hex(), users.StatusChange{Status: users.StatusActive})
+	if err != nil {
+		t.Fatalf("SetStatus: %v", err)
+	}
+	if reactivated.IsBlocked(time.Now()) || reactivated.StatusReason != "" || reactivated.StatusExpiresAt != nil {
+		t.Fatalf("reactivated user is %+v", reactivated)
+	}
+}
+
+func testRole(t *testing.T, repo users.UserRepository) {
+	mustCreate(t, repo, "09120000001")
+	admin := mustCreate(t, repo, "09120000002")
+
+	updated, err := repo.SetRole(t.Context(), admin.ID.Hex(), users.RoleAdmin)
+	if err != nil {
+		t.Fatalf("SetRole: %v", err)
+	}
+	if updated.Role != users.RoleAdmin {
+		t.Fatalf("SetRole returned role %q", updated.Role)
+	}
+
+	for role, want := range map[users.Role][]string{
+		users.RoleUser:    {"09120000001"},
+		users.RoleAdmin:   {"09120000002"},
+		users.RoleSupport: nil,
+	} {
+		result, err := repo.GetAll(t.Context(), users.UserQuery{Page: 1, PageSize: 10, Role: role})
+		if err != nil {
+			t.Fatalf("GetAll: %v", err)
+		}
+		if got := phoneNumbers(result); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("role %s matched %v, want %v", role, got, want)
+		}
+	}
+}
+
+func testTOTP(t *testing.T, repo users.UserRepository) {
+	user := mustCreate(t, repo, "09120000001")
+	id := user.ID.Hex()
+
+	if err := repo.UseTOTPStep(t.Context(), id, 1); !errors.Is(err, users.ErrConflict) {
+		t.Fatalf("UseTOTPStep without TOTP returned %v, want ErrConflict", err)
+	}
+
+	confirmedAt := time.Now().UTC().Truncate(time.Millisecond)
+	updated, err := repo.SetTOTP(t.Context(), id, &users.TOTP{Secret: "SECRET", ConfirmedAt: &confirmedAt, LastStep: 10}, []string{"hash1", "hash2"})
+	if err != nil {
+		t.Fatalf("SetTOTP: %v", err)
+	}
+	if !updated.TOTPEnabled() || updated.TOTP.Secret != "SECRET" || updated.TOTP.LastStep != 10 {
+		t.Fatalf("SetTOTP returned %+v", updated.TOTP)
+	}
+
+	for _, step := range []int64{10, 9} {
+		if err := repo.UseTOTPStep(t.Context(), id, step); !errors.Is(err, users.ErrConflict) {
+			t.Errorf("UseTOTPStep(%d) after step 10 returned %v, want ErrConflict", step, err)
+		}
+	}
+	if err := repo.UseTOTPStep(t.Context(), id, 11); err != nil {
+		t.Fatalf("UseTOTPStep(11): %v", err)
+	}
+	found, err := repo.FindByID(t.Context(), id)
+	if err != nil || found.TOTP == nil || found.TOTP.LastStep != 11 || !found.TOTP.ConfirmedAt.Equal(confirmedAt) {
+		t.Fatalf("FindByID after UseTOTPStep returned %+v, %v", found, err)
+	}
+
+	if err := repo.UseRecoveryCode(t.Context(), id, "hash1"); err != nil {
+		t.Fatalf("UseRecoveryCode: %v", err)
+	}
+	if err := repo.UseRecoveryCode(t.Context(), id, "hash1"); !errors.Is(err, users.ErrConflict) {
+		t.Errorf("UseRecoveryCode of a used code returned %v, want ErrConflict", err)
+	}
+
+	if _, err := repo.SetTOTP(t.Context(), id, nil, nil); err != nil {
+		t.Fatalf("SetTOTP(nil): %v", err)
+	}
+	if err := repo.UseRecoveryCode(t.Context(), id, "hash2"); !errors.Is(err, users.ErrConflict) {
+		t.Errorf("UseRecoveryCode after removing TOTP returned %v, want ErrConflict", err)
+	}
+	if found, err := repo.FindByID(t.Context(), id); err != nil || found.TOTP != nil {
+		t.Fatalf("FindByID after removing TOTP returned %+v, %v", found, err)
+	}
+
+	other := mustCreate(t, repo, "09120000002")
+	if err := repo.Delete(t.Context(), other.ID.Hex()); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := repo.SetTOTP(t.Context(), other.ID.Hex(), &users.TOTP{Secret: "SECRET"}, nil); !errors.Is(err, users.ErrUserNotFound) {
+		t.Errorf("SetTOTP of a deleted user returned %v", err)
+	}
+	if err := repo.UseRecoveryCode(t.Context(), other.ID.Hex(), "hash1"); !errors.Is(err, users.ErrUserNotFound) {
+		t.Errorf("UseRecoveryCode of a deleted user returned %v", err)
+	}
+}
+
+func testPasskeys(t *testing.T, repo users.UserRepository) {
+	user := mustCreate(t, repo, "09120000001")
+	id := user.ID.Hex()
+
+	createdAt := time.Now().UTC().Truncate(time.Millisecond)
+	passkey := users.Passkey{
+		ID:              []byte("credential-1"),
+		PublicKey:       []byte("public key"),
+		AttestationType: "none",
+		Transports:      []string{"internal", "hybrid"},
+		AAGUID:          make([]byte, 16),
+		SignCount:       1,
+		BackupEligible:  true,
+		CreatedAt:       createdAt,
+	}
+	if err := repo.AddPasskey(t.Context(), id, passkey); err != nil {
+		t.Fatalf("AddPasskey: %v", err)
+	}
+
+	other := mustCreate(t, repo, "09120000002")
+	if err := repo.AddPasskey(t.Context(), other.ID.Hex(), passkey); !errors.Is(err, users.ErrConflict) {
+		t.Errorf("AddPasskey with a registered ID returned %v, want ErrConflict", err)
+	}
+	if err := repo.UsePasskey(t.Context(), other.ID.Hex(), passkey.ID, 2); !errors.Is(err, users.ErrConflict) {
+		t.Errorf("UsePasskey of another user's passkey returned %v, want ErrConflict", err)
+	}
+
+	if err := repo.UsePasskey(t.Context(), id, passkey.ID, 5); err != nil {
+		t.Fatalf("UsePasskey: %v", err)
+	}
+	passkeys, err := repo.Passkeys(t.Context(), id)
+	if err != nil || len(passkeys) != 1 {
+		t.Fatalf("Passkeys returned %+v, %v", passkeys, err)
+	}
+	got := passkeys[0]
+	if string(got.ID) != "credential-1" || string(got.PublicKey) != "public key" || fmt.Sprint(got.Transports) != "[internal hybrid]" ||
+		got.SignCount != 5 || !got.BackupEligible || !got.CreatedAt.Equal(createdAt) || got.LastUsedAt == nil {
+		t.Errorf("Passkeys returned %+v", got)
+	}
+
+	if passkeys, err := repo.Passkeys(t.Context(), other.ID.Hex()); err != nil || len(passkeys) != 0 {
+		t.Errorf("Passkeys of a user without any returned %+v, %v", passkeys, err)
+	}
+
+	if err := repo.Delete(t.Context(), id); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := repo.Passkeys(t.Context(), id); !errors.Is(err, users.ErrUserNotFound) {
+		t.Errorf("Passkeys of a deleted user returned %v", err)
+	}
+	if err := repo.UsePasskey(t.Context(), id, passkey.ID, 6); !errors.Is(err, users.ErrUserNotFound) {
+		t.Errorf("UsePasskey of a deleted user returned %v", err)
+	}
+}
+
+func testDevices(t *testing.T, repo users.UserRepository) {
+	user := mustCreate(t, repo, "09120000001")
+	id := user.ID.Hex()
+
+	createdAt := time.Now().UTC().Truncate(time.Millisecond)
+	device := users.Device{
+		ID:           "device-1",
+		Name:         "Pixel",
+		PublicKey:    []byte("public key"),
+		CreatedAt:    createdAt,
+		TrustedUntil: createdAt.Add(time.Hour),
+	}
+	if err := repo.TrustDevice(t.Context(), id, device); err != nil {
+		t.Fatalf("TrustDevice: %v", err)
+	}
+	device.PublicKey = []byte("new key")
+	device.CreatedAt = createdAt.Add(time.Minute)
+	device.TrustedUntil = createdAt.Add(2 * time.Hour)
+	if err := repo.TrustDevice(t.Context(), id, device); err != nil {
+		t.Fatalf("TrustDevice of a trusted device: %v", err)
+	}
+
+	other := mustCreate(t, repo, "09120000002")
+	if err := repo.TrustDevice(t.Context(), other.ID.Hex(), device); err != nil {
+		t.Errorf("TrustDevice with another user's device ID: %v", err)
+	}
+	if err := repo.UseDevice(t.Context(), other.ID.Hex(), "device-2"); !errors.Is(err, users.ErrConflict) {
+		t.Errorf("UseDevice of an unknown device returned %v, want ErrConflict", err)
+	}
+
+	if err := repo.UseDevice(t.Context(), id, device.ID); err != nil {
+		t.Fatalf("UseDevice: %v", err)
+	}
+	devices, err := repo.Devices(t.Context(), id)
+	if err != nil || len(devices) != 1 {
+		t.Fatalf("Devices returned %+v, %v", devices, err)
+	}
+	got := devices[0]
+	if got.ID != "device-1" || got.Name != "Pixel" || string(got.PublicKey) != "new key" || !got.CreatedAt.Equal(createdAt) ||
+		!got.TrustedUntil.Equal(createdAt.Add(2*time.Hour)) || got.LastUsedAt == nil {
+		t.Errorf("Devices returned %+v", got)
+	}
+
+	if err := repo.DeleteDevice(t.Context(), id, device.ID); err != nil {
+		t.Fatalf("DeleteDevice: %v", err)
+	}
+	if err := repo.DeleteDevice(t.Context(), id, device.ID); !errors.Is(err, users.ErrConflict) {
+		t.Errorf("DeleteDevice of a revoked device returned %v, want ErrConflict", err)
+	}
+	if devices, err := repo.Devices(t.Context(), id); err != nil || len(devices) != 0 {
+		t.Errorf("Devices after DeleteDevice returned %+v, %v", devices, err)
+	}
+	if devices, err := repo.Devices(t.Context(), other.ID.Hex()); err != nil || len(devices) != 1 {
+		t.Errorf("Devices of the other user returned %+v, %v", devices, err)
+	}
+
+	if err := repo.Delete(t.Context(), id); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := repo.Devices(t.Context(), id); !errors.Is(err, users.ErrUserNotFound) {
+		t.Errorf("Devices of a deleted user returned %v", err)
+	}
+	if err := repo.TrustDevice(t.Context(), id, device); !errors.Is(err, users.ErrUserNotFound) {
+		t.Errorf("TrustDevice of a deleted user returned %v", err)
+	}
+}
+
+func testSessions(t *testing.T, repo users.UserRepository) {
+	user := mustCreate(t, repo, "09120000001")
+	id := user.ID.Hex()
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	session := func(sessionID string, expiresAt time.Time) users.Session {
+		return users.Session{
+			ID:         sessionID,
+			UserAgent:  "curl/8.5.0",
+			IP:         "203.0.113.7",
+			DeviceID:   "device-1",
+			CreatedAt:  now,
+			LastSeenAt: now,
+			ExpiresAt:  expiresAt,
+		}
+	}
+	for _, s := range []users.Session{session("expired", now.Add(-time.Second)), session("session-1", now.Add(time.Hour)), session("session-2", now.Add(time.Hour))} {
+		if err := repo.CreateSession(t.Context(), id, s); err != nil {
+			t.Fatalf("CreateSession(%s): %v", s.ID, err)
+		}
+	}
+
+	if err := repo.TouchSession(t.Context(), id, "expired"); !errors.Is(err, users.ErrConflict) {
+		t.Errorf("TouchSession of an expired session returned %v, want ErrConflict", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if err := repo.TouchSession(t.Context(), id, "session-1"); err != nil {
+		t.Fatalf("TouchSession: %v", err)
+	}
+	sessions, err := repo.Sessions(t.Context(), id)
+	if err != nil || len(sessions) != 2 {
+		t.Fatalf("Sessions returned %+v, %v", sessions, err)
+	}
+	got := sessions[0]
+	if got.ID != "session-1" || got.UserAgent != "curl/8.5.0" || got.IP != "203.0.113.7" || got.DeviceID != "device-1" ||
+		!got.CreatedAt.Equal(now) || !got.LastSeenAt.After(now) || !got.ExpiresAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("Sessions returned %+v", got)
+	}
+
+	other := mustCreate(t, repo, "09120000002")
+	if err := repo.DeleteSession(t.Context(), other.ID.Hex(), "session-1"); !errors.Is(err, users.ErrConflict) {
+		t.Errorf("DeleteSession of another user's session returned %v, want ErrConflict", err)
+	}
+	if err := repo.DeleteSession(t.Context(), id, "session-1"); err != nil {
+		t.Fatalf("DeleteSession: %v", err)
+	}
+	if err := repo.TouchSession(t.Context(), id, "session-1"); !errors.Is(err, users.ErrConflict) {
+		t.Errorf("TouchSession of a deleted session returned %v, want ErrConflict", err)
+	}
+	if err := repo.DeleteSessions(t.Context(), id); err != nil {
+		t.Fatalf("DeleteSessions: %v", err)
+	}
+	if sessions, err := repo.Sessions(t.Context(), id); err != nil || len(sessions) != 0 {
+		t.Errorf("Sessions after DeleteSessions returned %+v, %v", sessions, err)
+	}
+
+	if err := repo.Delete(t.Context(), id); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := repo.CreateSession(t.Context(), id, session("session-3", now.Add(time.Hour))); !errors.Is(err, users.ErrUserNotFound) {
+		t.Errorf("CreateSession of a deleted user returned %v", err)
+	}
+	if err := repo.TouchSession(t.Context(), id, "session-2"); !errors.Is(err, users.ErrUserNotFound) {
+		t.Errorf("TouchSession of a deleted user returned %v", err)
+	}
+}
+
+func testHealthCheck(t *testing.T, repo users.UserRepository) {
+	if err := repo.HealthCheck(t.Context()); err != nil {
+		t.Fatalf("HealthCheck: %v", err)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"html/template"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+
+	"github.com/epicmet/dekamond-task/internal/logging"
+	"github.com/epicmet/dekamond-task/internal/otp"
+	"github.com/epicmet/dekamond-task/internal/problem"
+	"github.com/epicmet/dekamond-task/internal/users"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/render"
+)
+
+var magicLinks *otp.MagicLinks
+
+// newMagicLinks keeps the links in sm, so they expire with OTPs, and emails
+// them to mail. The links are signed with a key derived from the JWT secret,
+// so a link can't be used as a bearer token or the other way around.
+func newMagicLinks(sm otp.OTPStateManager, mail io.Writer) *otp.MagicLinks {
+	mac := hmac.New(sha256.New, jwtSecret())
+	mac.Write([]byte("magic-link"))
+
+	return otp.NewMagicLinks(sm, otp.NewConsoleMailer(mail), otp.MagicLinkOptions{
+		URL:            cfg.MagicLink.URL,
+		VerifyURL:      cfg.MagicLink.VerifyURL,
+		Key:            mac.Sum(nil),
+		AppName:        cfg.OTP.AppName,
+		MaxAttempts:    cfg.OTP.MaxAttempts,
+		ResendCooldown: cfg.OTP.ResendCooldown,
+	})
+}
+
+// sendMagicLink answers /send-otp with delivery "link" by emailing user, the
+// owner of phone, a link logging them in. Links are only sent to verified
+// addresses.
+func sendMagicLink(c *gin.Context, phone string, user *users.User) {
+	if user == nil || user.Email == "" {
+		problem.Abort(c, problem.New(http.StatusConflict, problem.CodeEmailNotSet, "set an email address to log in with a link"))
+		return
+	}
+	if user.EmailVerifiedAt == nil {
+		problem.Abort(c, problem.New(http.StatusConflict, problem.CodeEmailNotVerified, "verify your email address to log in with a link"))
+		return
+	}
+
+	delivery, err := magicLinks.Send(c.Request.Context(), user.ID.Hex(), user.Email)
+	if err != nil {
+		respondSendError(c, err)
+		return
+	}
+	logging.FromContext(c.Request.Context()).Info("otp sent", "phone", phone, "channel", delivery.Channel)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "login link has been sent",
+		"expires_in":   int(math.Round(delivery.ExpiresIn.Seconds())),
+		"resend_after": int(math.Round(delivery.ResendAfter.Seconds())),
+		"channel":      delivery.Channel,
+	})
+}
+
+// magicLinkPage asks to confirm the login before the link is used, so that
+// mail scanners and link previews opening it don't use it up.
+var magicLinkPage = template.Must(template.New("magic-link").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Log in to {{.AppName}}</title>
+</head>
+<body>
+<form method="post" action="magic-link">
+<input type="hidden" name="token" value="{{.Token}}">
+<button type="submit">Log in to {{.AppName}}</button>
+</form>
+</body>
+</html>
+`))
+
+// @Summary		Open magic link
+// @Description	Open a link emailed by /send-otp without using it: the page asks to confirm the login, which posts the token to /magic-link. If a redirect URL is configured, the response redirects there instead with the token in the fragment, for the app to post.
+// @Tags			OTP
+// @Produce		html
+// @Param			token	query		string	true	"Token of the link"
+// @Success		200		{string}	string	"Confirmation page"
+// @Success		302
+// @Failure		500		{object}	problem.Problem
+// @Router			/magic-link [get]
+func openMagicLink(c *gin.Context) {
+	token := c.Query("token")
+	// Keep the token out of the Referer of anything the page loads.
+	c.Header("Referrer-Policy", "no-referrer")
+	c.Header("Cache-Control", "no-store")
+
+	if cfg.MagicLink.RedirectURL == "" {
+		c.Render(http.StatusOK, render.HTML{
+			Template: magicLinkPage,
+			Data:     gin.H{"AppName": cfg.OTP.AppName, "Token": token},
+		})
+		return
+	}
+
+	redirect, err := url.Parse(cfg.MagicLink.RedirectURL)
+	if err != nil {
+		respondError(c, err, "parse redirect url")
+		return
+	}
+	// The fragment stays on the client: unlike the query, it isn't sent to
+	// the app's server or in a Referer, so the token doesn't end up in logs.
+	redirect.Fragment = url.Values{"token": {token}}.Encode()
+	c.Redirect(http.StatusFound, redirect.String())
+}
+
+// @Summary		Log in with magic link
+// @Description	Log in with the token of a link emailed by /send-otp, like /verify-otp does with a code. A link works once and expires with the OTP TTL.
+// @Tags			OTP
+// @Accept			json
+// @Accept			x-www-form-urlencoded
+// @Produce		json
+// @Param			request	body		object{token=string}	true	"Token of the link"
+// @Success		200		{object}	object{message=string,token=string,mfa_required=bool,mfa_token=string}
+// @Failure		400		{object}	problem.Problem
+// @Failure		403		{object}	problem.Problem
+// @Failure		404		{object}	problem.Problem
+// @Failure		429		{object}	problem.Problem
+// @Failure		500		{object}	problem.Problem
+// @Router			/magic-link [post]
+func useMagicLink(c *gin.Context) {
+	var req struct {
+		Token string `json:"token" form:"token"`
+	}
+	if err := c.ShouldBind(&req); err != nil || req.Token == "" {
+		problem.Abort(c, problem.New(http.StatusBadRequest, problem.CodeInvalidRequest, "invalid request body"))
+		return
+	}
+
+	userID, err := magicLinks.Check(c.Request.Context(), req.Token)
+	if err != nil {
+		respondOTPError(c, "", err)
+		return
+	}
+	user, err := usersRepo.FindByID(c.Request.Context(), userID)
+	if err != nil {
+		respondError(c, err, "fetch data from db")
+		return
+	}
+
+	_, body := loginUser(c, user, mfaSubject{}, "magic link verified successfully")
+	if body == nil {
+		return
+	}
+	c.JSON(http.StatusOK, body)
+}
@@ -1,147 +1,329 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"math"
 	"net/http"
 	"os"
+	"os/signal"
+	"slices"
 	"strconv"
+	"syscall"
 	"time"
+	_ "time/tzdata"
 
 	docs "github.com/epicmet/dekamond-task/docs"
+	"github.com/epicmet/dekamond-task/internal/authz"
+	"github.com/epicmet/dekamond-task/internal/config"
+	"github.com/epicmet/dekamond-task/internal/health"
+	"github.com/epicmet/dekamond-task/internal/logging"
+	"github.com/epicmet/dekamond-task/internal/metrics"
 	"github.com/epicmet/dekamond-task/internal/otp"
+	"github.com/epicmet/dekamond-task/internal/problem"
 	ratelimit "github.com/epicmet/dekamond-task/internal/rate-limit"
+	"github.com/epicmet/dekamond-task/internal/tracing"
 	"github.com/epicmet/dekamond-task/internal/users"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/joho/godotenv"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"golang.org/x/text/language"
 )
 
-var OTP_LENGTH = 6
-var stateManager = otp.NewMemStateManager(time.Minute * 2)
-var otpProvider otp.OTPProvider = otp.NewConsoleOTP(
-	stateManager,
-	os.Stdout,
-	OTP_LENGTH,
-)
+var cfg = config.Default()
+
+var otpProvider otp.OTPProvider
 
 var usersRepo users.UserRepository
 
-func getEnvOrDefault(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+func newUserRepository(db config.DBConfig) (users.UserRepository, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), db.ConnectTimeout)
+	defer cancel()
+
+	switch db.Driver {
+	case "mongo":
+		return users.NewMongoUserRepository(ctx, db.MongoURI, db.MongoDatabase, db.OpTimeout)
+	case "postgres":
+		return users.NewPostgresUserRepository(ctx, db.PostgresDSN, db.OpTimeout)
+	case "sqlite":
+		return users.NewSQLiteUserRepository(ctx, db.SQLitePath, db.OpTimeout)
+	case "memory":
+		return users.NewMemoryUserRepository(), nil
+	default:
+		return nil, fmt.Errorf("unknown DB_DRIVER %q (mongo, postgres, sqlite, memory)", db.Driver)
+	}
+}
+
+func otpOptions() (otp.Options, error) {
+	messages, err := otp.NewMessages(otp.MessageOptions{
+		AppName:       cfg.OTP.AppName,
+		AppHash:       cfg.OTP.AppHash,
+		Domain:        cfg.OTP.Domain,
+		DefaultLocale: cfg.OTP.DefaultLocale,
+		TemplatesDir:  cfg.OTP.TemplatesDir,
+	})
+	if err != nil {
+		return otp.Options{}, err
 	}
-	return defaultValue
+
+	return otp.Options{
+		Length:         cfg.OTP.Length,
+		MaxAttempts:    cfg.OTP.MaxAttempts,
+		ResendCooldown: cfg.OTP.ResendCooldown,
+		Messages:       messages,
+	}, nil
 }
 
-func generateJWT(phoneNumber string) (string, error) {
+// messageLanguages returns the languages to write an OTP message to user in:
+// their saved locale, if any, then those of the Accept-Language header.
+func messageLanguages(c *gin.Context, user *users.User) []language.Tag {
+	var tags []language.Tag
+	if user != nil && user.Locale != "" {
+		if tag, err := language.Parse(user.Locale); err == nil {
+			tags = append(tags, tag)
+		}
+	}
+	accepted, _, _ := language.ParseAcceptLanguage(c.GetHeader("Accept-Language"))
+	return append(tags, accepted...)
+}
+
+// newOTPProvider instruments p with metrics and tracing under name.
+func newOTPProvider(name string, p otp.OTPProvider) otp.OTPProvider {
+	return metrics.InstrumentOTPProvider(name, tracing.InstrumentOTPProvider(name, p))
+}
+
+// generateJWT returns a token of user that lasts as long as session.
+func generateJWT(ctx context.Context, user *users.User, session users.Session) (string, error) {
+	_, span := tracing.Tracer().Start(ctx, "jwt.sign")
+	defer span.End()
+
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"phone_number": phoneNumber,
-		"exp":          time.Now().Add(time.Hour * 24).Unix(),
+		"sub":          user.ID.Hex(),
+		"sid":          session.ID,
+		"phone_number": user.PhoneNumber,
+		"role":         user.EffectiveRole(),
+		"exp":          session.ExpiresAt.Unix(),
 	})
-	return token.SignedString([]byte(getEnvOrDefault("JWT_SECRET_KEY", "dummy dum key")))
+	return token.SignedString(jwtSecret())
 }
 
 // @Summary		Send OTP
-// @Description	Send OTP to phone number
+// @Description	Send OTP to phone number, in the user's saved locale or the best match for Accept-Language. platform may be android or ios to format the message for autofill. With delivery "link", a single-use login link is emailed to the user's address instead, to be opened at /magic-link.
 // @Tags			OTP
 // @Accept			json
 // @Produce		json
-// @Param			request	body		object{phone=string}	true	"Phone number"
-// @Success		200		{object}	object{message=string}
-// @Failure		400		{object}	object{error=string}
-// @Failure		500		{object}	object{error=string}
+// @Param			Accept-Language	header		string									false	"Preferred message languages"
+// @Param			request			body		object{phone=string,platform=string,delivery=string}	true	"Phone number, platform and delivery (code or link)"
+// @Success		200				{object}	object{message=string,expires_in=int,resend_after=int,code_length=int,channel=string,locale=string}
+// @Failure		400		{object}	problem.Problem
+// @Failure		403		{object}	problem.Problem
+// @Failure		409		{object}	problem.Problem
+// @Failure		429		{object}	problem.Problem
+// @Failure		500		{object}	problem.Problem
 // @Router			/send-otp [post]
 func sendOtp(c *gin.Context) {
 	var req struct {
-		Phone string `json:"phone"`
+		Phone    string `json:"phone"`
+		Platform string `json:"platform"`
+		Delivery string `json:"delivery"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		problem.Abort(c, problem.New(http.StatusBadRequest, problem.CodeInvalidRequest, "invalid request body"))
 		return
 	}
-
-	err := otpProvider.Send(req.Phone)
+	format, err := otp.ParseFormat(req.Platform)
 	if err != nil {
-		fmt.Printf("error while sending the otp: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to send otp"})
+		problem.Abort(c, problem.New(http.StatusBadRequest, problem.CodeValidationFailed, "platform must be one of android, ios").With("field", "platform"))
+		return
+	}
+	if req.Delivery != "" && req.Delivery != "code" && req.Delivery != "link" {
+		problem.Abort(c, problem.New(http.StatusBadRequest, problem.CodeValidationFailed, "delivery must be one of code, link").With("field", "delivery"))
+		return
+	}
+
+	user, err := usersRepo.FindByPhone(c.Request.Context(), req.Phone)
+	if err != nil && !errors.Is(err, users.ErrUserNotFound) {
+		respondError(c, err, "fetch data from db")
+		return
+	}
+	if user != nil && user.IsBlocked(time.Now()) {
+		respondBlocked(c, user)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "otp has been sent"})
+	if req.Delivery == "link" {
+		sendMagicLink(c, req.Phone, user)
+		return
+	}
+
+	delivery, err := otpProvider.Send(c.Request.Context(), otp.Recipient{
+		Phone:     req.Phone,
+		Languages: messageLanguages(c, user),
+		Format:    format,
+	})
+	if err != nil {
+		respondSendError(c, err)
+		return
+	}
+	logging.FromContext(c.Request.Context()).Info("otp sent", "phone", req.Phone, "channel", delivery.Channel)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "otp has been sent",
+		"expires_in":   int(math.Round(delivery.ExpiresIn.Seconds())),
+		"resend_after": int(math.Round(delivery.ResendAfter.Seconds())),
+		"code_length":  delivery.CodeLength,
+		"channel":      delivery.Channel,
+		"locale":       delivery.Locale,
+	})
 }
 
 // @Summary		Verify OTP
-// @Description	Verify OTP for phone number
+// @Description	Verify OTP for phone number. Users with TOTP enabled get an mfa_token instead of a token, to be completed with /verify-totp. If device is given, once the login completes the device is trusted to log in without an OTP at /devices/login until device_trusted_until; its public_key is a base64 PKIX encoded ECDSA P-256 or Ed25519 key.
 // @Tags			OTP
 // @Accept			json
 // @Produce		json
-// @Param			request	body		object{phone=string,otp=string}	true	"Phone and OTP"
-// @Success		200		{object}	object{message=string}
-// @Failure		400		{object}	object{error=string}
+// @Param			request	body		object{phone=string,otp=string,device=object{id=string,name=string,public_key=string}}	true	"Phone, OTP and optionally the device to trust"
+// @Success		200		{object}	object{message=string,token=string,mfa_required=bool,mfa_token=string,device_trusted_until=string}
+// @Failure		400		{object}	problem.Problem
+// @Failure		403		{object}	problem.Problem
+// @Failure		429		{object}	problem.Problem
+// @Failure		500		{object}	problem.Problem
 // @Router			/verify-otp [post]
 func verifyOtp(c *gin.Context) {
 	var req struct {
-		Phone string `json:"phone"`
-		OTP   string `json:"otp"`
+		Phone  string         `json:"phone"`
+		OTP    string         `json:"otp"`
+		Device *deviceRequest `json:"device"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		problem.Abort(c, problem.New(http.StatusBadRequest, problem.CodeInvalidRequest, "invalid request body"))
+		return
+	}
+	if req.Device != nil && !validateDevice(c, req.Device) {
 		return
 	}
 
-	isOtpCorrect := otpProvider.Check(req.Phone, req.OTP)
+	if err := otpProvider.Check(c.Request.Context(), req.Phone, req.OTP); err != nil {
+		respondOTPError(c, req.Phone, err)
+		return
+	}
 
-	if !isOtpCorrect {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid otp"})
+	login := mfaSubject{Device: req.Device}
+	if req.Device != nil {
+		login.DeviceID = req.Device.ID
+	}
+	_, body := loginPhone(c, req.Phone, login, "otp verified successfully")
+	if body == nil {
 		return
 	}
+	c.JSON(http.StatusOK, body)
+}
 
-	user, err := usersRepo.Upsert(req.Phone)
+// loginPhone logs in, or signs up, the owner of phone once they proved they
+// have it, like loginUser.
+func loginPhone(c *gin.Context, phone string, login mfaSubject, message string) (*users.User, gin.H) {
+	user, err := usersRepo.Upsert(c.Request.Context(), phone)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch data from db"})
-		return
+		respondError(c, err, "fetch data from db")
+		return nil, nil
+	}
+	return loginUser(c, user, login, message)
+}
+
+// loginUser logs in user once they proved who they are with a first factor.
+// login says which device they log in from and which to trust, if any;
+// loginUser fills in its UserID. It returns the user and the response body
+// of completeLogin, or an mfa_token if the user has a second factor: then the
+// device is only trusted once /verify-totp succeeds. The body is nil if it
+// already responded with an error.
+func loginUser(c *gin.Context, user *users.User, login mfaSubject, message string) (*users.User, gin.H) {
+	if user.IsBlocked(time.Now()) {
+		respondBlocked(c, user)
+		return nil, nil
+	}
+
+	if isAdminPhoneNumber(user.PhoneNumber) && user.EffectiveRole() != users.RoleAdmin {
+		admin, err := usersRepo.SetRole(c.Request.Context(), user.ID.Hex(), users.RoleAdmin)
+		if err != nil {
+			respondError(c, err, "fetch data from db")
+			return nil, nil
+		}
+		user = admin
+	}
+
+	if user.TOTPEnabled() {
+		login.UserID = user.ID.Hex()
+		subject, err := json.Marshal(login)
+		if err != nil {
+			respondError(c, err, "create mfa challenge")
+			return nil, nil
+		}
+		mfaToken, err := mfaChallenges.Create(c.Request.Context(), string(subject))
+		if err != nil {
+			respondError(c, err, "create mfa challenge")
+			return nil, nil
+		}
+		return user, gin.H{"message": "second factor required", "mfa_required": true, "mfa_token": mfaToken}
 	}
 
-	token, err := generateJWT(user.PhoneNumber)
+	return user, completeLogin(c, user, login, message)
+}
+
+// completeLogin issues a JWT described by message to user, logged in from
+// the device login describes, and trusts login.Device if set. It returns the
+// response body, or nil if it already responded with an error.
+func completeLogin(c *gin.Context, user *users.User, login mfaSubject, message string) gin.H {
+	token, err := issueToken(c, user, login.DeviceID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
+		respondError(c, err, "generate token")
+		return nil
 	}
+	body := gin.H{"message": message, "token": token}
 
-	c.JSON(http.StatusOK, gin.H{"message": "otp verified successfully", "token": token})
+	if login.Device != nil {
+		trustedUntil, err := trustDevice(c.Request.Context(), user, login.Device)
+		if err != nil {
+			respondError(c, err, "trust device")
+			return nil
+		}
+		body["device_trusted_until"] = trustedUntil
+	}
+	return body
 }
 
 // @Summary		Get user by ID
 // @Description	Retrieve single user details by ID
 // @Tags			Users
+// @Security		BearerAuth
 // @Accept			json
 // @Produce		json
 // @Param			id	path		string	true	"User ID"
 // @Success		200	{object}	users.User
-// @Failure		400	{object}	object{error=string}
-// @Failure		404	{object}	object{error=string}
-// @Failure		500	{object}	object{error=string}
+// @Failure		400	{object}	problem.Problem
+// @Failure		401	{object}	problem.Problem
+// @Failure		403	{object}	problem.Problem
+// @Failure		404	{object}	problem.Problem
+// @Failure		500	{object}	problem.Problem
 // @Router			/users/{id} [get]
 func getUserByID(c *gin.Context) {
 	id := c.Param("id")
 	if id == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "user id is required"})
+		problem.Abort(c, problem.New(http.StatusBadRequest, problem.CodeInvalidRequest, "user id is required"))
 		return
 	}
 
-	user, err := usersRepo.FindByID(id)
+	user, err := usersRepo.FindByID(c.Request.Context(), id)
 	if err != nil {
-		if errors.Is(err, users.ErrUserNotFound) {
-			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
-			return
-		}
-		fmt.Printf("error while fetching user: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch user"})
+		respondError(c, err, "fetch user")
 		return
 	}
 
@@ -151,31 +333,32 @@ func getUserByID(c *gin.Context) {
 // @Summary		Get all users
 // @Description	Retrieve list of users with pagination
 // @Tags			Users
+// @Security		BearerAuth
 // @Accept			json
 // @Produce		json
-// @Param			page		query		int	false	"Page number"	default(1)
-// @Param			page_size	query		int	false	"Page size"		default(10)
-// @Success		200			{object}	users.PaginatedUsers
-// @Failure		400			{object}	object{error=string}
-// @Failure		500			{object}	object{error=string}
+// @Param			page				query		int		false	"Page number"	default(1)
+// @Param			page_size			query		int		false	"Page size"		default(10)
+// @Param			sort				query		string	false	"Comma separated sort fields, prefix with - for descending (registered_at, phone_number)"	default(-registered_at)
+// @Param			registered_after	query		string	false	"Only users registered after this time (RFC 3339)"
+// @Param			registered_before	query		string	false	"Only users registered before this time (RFC 3339)"
+// @Param			status				query		string	false	"Only users with this status"	Enums(active, suspended, banned)
+// @Param			role				query		string	false	"Only users with this role"		Enums(user, support, admin)
+// @Success		200					{object}	users.PaginatedUsers
+// @Failure		400			{object}	problem.Problem
+// @Failure		401			{object}	problem.Problem
+// @Failure		403			{object}	problem.Problem
+// @Failure		500			{object}	problem.Problem
 // @Router			/users [get]
 func getUsers(c *gin.Context) {
-	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
-	if err != nil || page < 1 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid page parameter"})
-		return
-	}
-
-	pageSize, err := strconv.Atoi(c.DefaultQuery("page_size", "10"))
-	if err != nil || pageSize < 1 || pageSize > 100 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid page_size parameter (1-100)"})
+	query, err := parseUserQuery(c)
+	if err != nil {
+		problem.Abort(c, problem.New(http.StatusBadRequest, problem.CodeInvalidRequest, err.Error()))
 		return
 	}
 
-	result, err := usersRepo.GetAll(page, pageSize)
+	result, err := usersRepo.GetAll(c.Request.Context(), query)
 	if err != nil {
-		fmt.Printf("error while fetching users: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch users"})
+		respondError(c, err, "fetch users")
 		return
 	}
 
@@ -185,70 +368,547 @@ func getUsers(c *gin.Context) {
 // @Summary		Search users by phone
 // @Description	Search users by phone number prefix
 // @Tags			Users
+// @Security		BearerAuth
 // @Accept			json
 // @Produce		json
 // @Param			phone		query		string	true	"Phone number prefix to search"
-// @Param			page		query		int		false	"Page number"	default(1)
-// @Param			page_size	query		int		false	"Page size"		default(10)
-// @Success		200			{object}	users.PaginatedUsers
-// @Failure		400			{object}	object{error=string}
-// @Failure		500			{object}	object{error=string}
+// @Param			page				query		int		false	"Page number"	default(1)
+// @Param			page_size			query		int		false	"Page size"		default(10)
+// @Param			sort				query		string	false	"Comma separated sort fields, prefix with - for descending (registered_at, phone_number)"	default(-registered_at)
+// @Param			registered_after	query		string	false	"Only users registered after this time (RFC 3339)"
+// @Param			registered_before	query		string	false	"Only users registered before this time (RFC 3339)"
+// @Param			status				query		string	false	"Only users with this status"	Enums(active, suspended, banned)
+// @Param			role				query		string	false	"Only users with this role"		Enums(user, support, admin)
+// @Success		200					{object}	users.PaginatedUsers
+// @Failure		400			{object}	problem.Problem
+// @Failure		401			{object}	problem.Problem
+// @Failure		403			{object}	problem.Problem
+// @Failure		500			{object}	problem.Problem
 // @Router			/users/search [get]
 func searchUsers(c *gin.Context) {
 	phonePrefix := c.Query("phone")
 	if phonePrefix == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "phone parameter is required"})
+		problem.Abort(c, problem.New(http.StatusBadRequest, problem.CodeInvalidRequest, "phone parameter is required"))
+		return
+	}
+
+	query, err := parseUserQuery(c)
+	if err != nil {
+		problem.Abort(c, problem.New(http.StatusBadRequest, problem.CodeInvalidRequest, err.Error()))
+		return
+	}
+
+	result, err := usersRepo.SearchByPhone(c.Request.Context(), phonePrefix, query)
+	if err != nil {
+		respondError(c, err, "search users")
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// @Summary		Update user profile
+// @Description	Update profile fields of a user with a JSON Merge Patch (RFC 7396). A null value removes the field. Only the user can change their own email address.
+// @Tags			Users
+// @Security		BearerAuth
+// @Accept			json
+// @Accept			application/merge-patch+json
+// @Produce		json
+// @Param			id		path		string												true	"User ID"
+// @Param			request	body		object{first_name=string,last_name=string,email=string,avatar_url=string,locale=string,timezone=string}	true	"Profile patch"
+// @Success		200		{object}	users.User
+// @Failure		400		{object}	problem.Problem
+// @Failure		401		{object}	problem.Problem
+// @Failure		403		{object}	problem.Problem
+// @Failure		404		{object}	problem.Problem
+// @Failure		500		{object}	problem.Problem
+// @Router			/users/{id} [patch]
+func updateUser(c *gin.Context) {
+	id := c.Param("id")
+	if id == "" {
+		problem.Abort(c, problem.New(http.StatusBadRequest, problem.CodeInvalidRequest, "user id is required"))
+		return
+	}
+
+	patchProfile(c, id)
+}
+
+// @Summary		Get current user
+// @Description	Retrieve the profile of the authenticated user
+// @Tags			Me
+// @Produce		json
+// @Security		BearerAuth
+// @Success		200	{object}	users.User
+// @Failure		401	{object}	problem.Problem
+// @Router			/me [get]
+func getMe(c *gin.Context) {
+	c.JSON(http.StatusOK, currentUser(c))
+}
+
+// @Summary		Update current user
+// @Description	Update profile fields of the authenticated user with a JSON Merge Patch (RFC 7396). A null value removes the field. Changing the email address clears its verification.
+// @Tags			Me
+// @Accept			json
+// @Accept			application/merge-patch+json
+// @Produce		json
+// @Security		BearerAuth
+// @Param			request	body		object{first_name=string,last_name=string,email=string,avatar_url=string,locale=string,timezone=string}	true	"Profile patch"
+// @Success		200		{object}	users.User
+// @Failure		400		{object}	problem.Problem
+// @Failure		401		{object}	problem.Problem
+// @Failure		500		{object}	problem.Problem
+// @Router			/me [patch]
+func updateMe(c *gin.Context) {
+	patchProfile(c, currentUser(c).ID.Hex())
+}
+
+func patchProfile(c *gin.Context, id string) {
+	body, err := c.GetRawData()
+	if err != nil {
+		problem.Abort(c, problem.New(http.StatusBadRequest, problem.CodeInvalidRequest, "invalid request body"))
+		return
+	}
+
+	patch, err := users.ParseProfilePatch(body)
+	if err != nil {
+		respondError(c, err, "parse profile patch")
+		return
+	}
+	// The email address receives login links, so whoever could change it
+	// for another user could take over their account.
+	if _, ok := patch["email"]; ok && id != currentUser(c).ID.Hex() {
+		problem.Abort(c, problem.New(http.StatusForbidden, problem.CodePermissionDenied, "only the user can change their email address").
+			With("field", "email"))
+		return
+	}
+
+	user, err := usersRepo.UpdateProfile(c.Request.Context(), id, patch)
+	if err != nil {
+		respondError(c, err, "update user")
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// @Summary		Delete user
+// @Description	Soft delete a user. The user is permanently removed after the retention window unless restored.
+// @Tags			Users
+// @Security		BearerAuth
+// @Param			id	path	string	true	"User ID"
+// @Success		204
+// @Failure		400	{object}	problem.Problem
+// @Failure		401	{object}	problem.Problem
+// @Failure		403	{object}	problem.Problem
+// @Failure		404	{object}	problem.Problem
+// @Failure		500	{object}	problem.Problem
+// @Router			/users/{id} [delete]
+func deleteUser(c *gin.Context) {
+	err := usersRepo.Delete(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		respondError(c, err, "delete user")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// @Summary		Restore user
+// @Description	Restore a soft deleted user
+// @Tags			Users
+// @Security		BearerAuth
+// @Produce		json
+// @Param			id	path		string	true	"User ID"
+// @Success		200	{object}	users.User
+// @Failure		400	{object}	problem.Problem
+// @Failure		401	{object}	problem.Problem
+// @Failure		403	{object}	problem.Problem
+// @Failure		404	{object}	problem.Problem
+// @Failure		409	{object}	problem.Problem
+// @Failure		500	{object}	problem.Problem
+// @Router			/users/{id}/restore [post]
+func restoreUser(c *gin.Context) {
+	user, err := usersRepo.Restore(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		respondError(c, err, "restore user")
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// @Summary		Change user status
+// @Description	Suspend, ban or reactivate a user. Suspensions may carry an expiry after which the user is active again. Only admins can change the status of support staff and admins.
+// @Tags			Users
+// @Security		BearerAuth
+// @Accept			json
+// @Produce		json
+// @Param			id		path		string												true	"User ID"
+// @Param			request	body		object{status=string,reason=string,expires_at=string}	true	"New status"
+// @Success		200		{object}	users.User
+// @Failure		400		{object}	problem.Problem
+// @Failure		401		{object}	problem.Problem
+// @Failure		403		{object}	problem.Problem
+// @Failure		404		{object}	problem.Problem
+// @Failure		500		{object}	problem.Problem
+// @Router			/users/{id}/status [put]
+func setUserStatus(c *gin.Context) {
+	var req struct {
+		Status    users.Status `json:"status"`
+		Reason    string       `json:"reason"`
+		ExpiresAt *time.Time   `json:"expires_at"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Abort(c, problem.New(http.StatusBadRequest, problem.CodeInvalidRequest, "invalid request body"))
+		return
+	}
+
+	change := users.StatusChange{Status: req.Status, Reason: req.Reason, ExpiresAt: req.ExpiresAt}
+	if err := change.Validate(time.Now()); err != nil {
+		respondError(c, err, "validate status change")
+		return
+	}
+
+	// Support can block regular users, but not their peers or admins.
+	target, err := usersRepo.FindByID(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		respondError(c, err, "fetch data from db")
+		return
+	}
+	role := currentUser(c).EffectiveRole()
+	if role != users.RoleAdmin && !role.Outranks(target.EffectiveRole()) {
+		problem.Abort(c, problem.New(http.StatusForbidden, problem.CodePermissionDenied, "only admins can change the status of staff"))
+		return
+	}
+
+	user, err := usersRepo.SetStatus(c.Request.Context(), c.Param("id"), change)
+	if err != nil {
+		respondError(c, err, "update user status")
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+func respondBlocked(c *gin.Context, user *users.User) {
+	status := user.EffectiveStatus(time.Now())
+	p := problem.New(http.StatusForbidden, problem.CodeAccountBlocked, fmt.Sprintf("account is %s", status)).
+		With("account_status", status)
+	if user.StatusReason != "" {
+		p.With("reason", user.StatusReason)
+	}
+	if user.StatusExpiresAt != nil {
+		p.With("expires_at", user.StatusExpiresAt)
+	}
+	problem.Abort(c, p)
+}
+
+// @Summary		Change user role
+// @Description	Grant a user the user, support or admin role. Takes effect on the user's next login.
+// @Tags			Users
+// @Accept			json
+// @Produce		json
+// @Security		BearerAuth
+// @Param			id		path		string				true	"User ID"
+// @Param			request	body		object{role=string}	true	"New role"
+// @Success		200		{object}	users.User
+// @Failure		400		{object}	problem.Problem
+// @Failure		401		{object}	problem.Problem
+// @Failure		403		{object}	problem.Problem
+// @Failure		404		{object}	problem.Problem
+// @Failure		500		{object}	problem.Problem
+// @Router			/users/{id}/role [put]
+func setUserRole(c *gin.Context) {
+	var req struct {
+		Role users.Role `json:"role"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Abort(c, problem.New(http.StatusBadRequest, problem.CodeInvalidRequest, "invalid request body"))
 		return
 	}
 
+	if !req.Role.Valid() {
+		problem.Abort(c, problem.New(http.StatusBadRequest, problem.CodeValidationFailed, "role must be one of user, support, admin").With("field", "role"))
+		return
+	}
+
+	user, err := usersRepo.SetRole(c.Request.Context(), c.Param("id"), req.Role)
+	if err != nil {
+		respondError(c, err, "update user role")
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// isAdminPhoneNumber reports whether the phone number is listed in
+// ADMIN_PHONE_NUMBERS, which bootstraps the first administrators.
+func isAdminPhoneNumber(phoneNumber string) bool {
+	return slices.Contains(cfg.AdminPhoneNumbers, phoneNumber)
+}
+
+func parseUserQuery(c *gin.Context) (users.UserQuery, error) {
+	var query users.UserQuery
+
 	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
 	if err != nil || page < 1 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid page parameter"})
-		return
+		return query, errors.New("invalid page parameter")
 	}
 
 	pageSize, err := strconv.Atoi(c.DefaultQuery("page_size", "10"))
 	if err != nil || pageSize < 1 || pageSize > 100 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid page_size parameter (1-100)"})
-		return
+		return query, errors.New("invalid page_size parameter (1-100)")
 	}
 
-	result, err := usersRepo.SearchByPhone(phonePrefix, page, pageSize)
+	sort, err := users.ParseSort(c.Query("sort"))
 	if err != nil {
-		fmt.Printf("error while searching users: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to search users"})
-		return
+		return query, err
 	}
 
-	c.JSON(http.StatusOK, result)
+	query.Page = page
+	query.PageSize = pageSize
+	query.Sort = sort
+
+	if v := c.Query("registered_after"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return query, errors.New("invalid registered_after parameter (RFC 3339)")
+		}
+		query.RegisteredAfter = &t
+	}
+
+	if v := c.Query("registered_before"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return query, errors.New("invalid registered_before parameter (RFC 3339)")
+		}
+		query.RegisteredBefore = &t
+	}
+
+	if v := c.Query("status"); v != "" {
+		query.Status = users.Status(v)
+		if !query.Status.Valid() {
+			return query, errors.New("invalid status parameter (active, suspended, banned)")
+		}
+	}
+
+	if v := c.Query("role"); v != "" {
+		query.Role = users.Role(v)
+		if !query.Role.Valid() {
+			return query, errors.New("invalid role parameter (user, support, admin)")
+		}
+	}
+
+	return query, nil
 }
 
+// @securityDefinitions.apikey	BearerAuth
+// @in							header
+// @name						Authorization
+// @description				Type "Bearer" followed by a space and the JWT.
 func main() {
-	err := godotenv.Load()
+	dotenvErr := godotenv.Load()
+
+	var err error
+	cfg, err = config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		slog.Error("failed to load config", "error", err)
+		os.Exit(1)
+	}
+
+	logger := logging.New(os.Stdout, cfg.Mode == config.ModeRelease, cfg.Log.SlogLevel())
+	slog.SetDefault(logger)
+
+	if dotenvErr != nil {
+		logger.Info("no .env file to read")
+	}
+	logger.Info("effective configuration", "config", cfg.String())
+
+	gin.SetMode(cfg.Mode)
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing.Exporter, cfg.Tracing.SampleRatio)
+	if err != nil {
+		logger.Error("failed to set up tracing", "error", err)
+		os.Exit(1)
+	}
+
+	otpOpts, err := otpOptions()
+	if err != nil {
+		logger.Error("failed to load the OTP message templates", "error", err)
+		os.Exit(1)
+	}
+	otpState := otp.NewMemStateManager(cfg.OTP.TTL)
+	otpProvider = newOTPProvider("console", otp.NewConsoleOTP(otpState, os.Stdout, otpOpts))
+	mfaChallenges = otp.NewChallenges(otpState, "mfa", cfg.OTP.MaxAttempts)
+	deviceChallenges = otp.NewChallenges(otpState, "device", cfg.OTP.MaxAttempts)
+	magicLinks = newMagicLinks(otpState, os.Stdout)
+
+	usersRepo, err = newUserRepository(cfg.DB)
+	if err != nil {
+		logger.Error("failed to open the user repository", "driver", cfg.DB.Driver, "error", err)
+		os.Exit(1)
+	}
+	usersRepo = users.WithTracing(usersRepo)
+
+	passkeys, err = newPasskeys(otpState)
 	if err != nil {
-		log.Print("No .env file to read")
+		logger.Error("failed to set up passkeys", "error", err)
+		os.Exit(1)
 	}
 
-	mongoURI := getEnvOrDefault("MONGO_URI", "mongodb://localhost:27017")
-	dbName := getEnvOrDefault("DB_NAME", "dekamond-task")
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	purgeDone := users.StartPurgeJob(ctx, logger, usersRepo, cfg.Purge.Retention, cfg.Purge.Interval)
+
+	rateLimitState := ratelimit.NewInMemoryStateManager()
+
+	for name, size := range map[string]func() int{"otp": otpState.Len, "rate_limit": rateLimitState.Len} {
+		if err := metrics.RegisterStoreSize(name, size); err != nil {
+			logger.Error("failed to register metrics", "store", name, "error", err)
+			os.Exit(1)
+		}
+	}
+	sendOtpLimiter := ratelimit.NewTokenBucket("send-otp", cfg.RateLimit.SendOTPCapacity, cfg.RateLimit.SendOTPRefill, rateLimitState)
+
+	srv := &http.Server{
+		Addr:         ":" + strconv.Itoa(cfg.HTTP.Port),
+		Handler:      setupRouter(logger, sendOtpLimiter),
+		ReadTimeout:  cfg.HTTP.ReadTimeout,
+		WriteTimeout: cfg.HTTP.WriteTimeout,
+		IdleTimeout:  cfg.HTTP.IdleTimeout,
+	}
+
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.ListenAndServe() }()
+	logger.Info("listening", "addr", srv.Addr)
+
+	select {
+	case err = <-serveErr:
+		logger.Error("server stopped", "error", err)
+	case <-ctx.Done():
+		logger.Info("shutting down")
+	}
+	stop()
+
+	// Drain in-flight requests before stopping what they depend on.
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.HTTP.ShutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logger.Error("failed to drain requests", "error", err)
+	}
+	<-purgeDone
+	sendOtpLimiter.Close()
+	rateLimitState.Close()
+	otpState.Close()
+	if err := usersRepo.Close(shutdownCtx); err != nil {
+		logger.Error("failed to close the user repository", "error", err)
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		logger.Error("failed to flush traces", "error", err)
+	}
 
-	usersRepo, err = users.NewMongoUserRepository(mongoURI, dbName)
 	if err != nil {
-		log.Fatal(err.Error())
+		os.Exit(1)
 	}
+}
 
-	r := gin.Default()
+// @Summary		Liveness probe
+// @Description	Reports that the process is up. It doesn't check any dependency.
+// @Tags			Health
+// @Produce		json
+// @Success		200	{object}	object{status=string}
+// @Router			/healthz [get]
+func healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": health.StatusOK})
+}
+
+// @Summary		Readiness probe
+// @Description	Checks every dependency and reports the status and latency of each
+// @Tags			Health
+// @Produce		json
+// @Success		200	{object}	health.Report
+// @Failure		503	{object}	health.Report
+// @Router			/readyz [get]
+func readyz(checks *health.Checks) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		report := checks.Run(c.Request.Context())
+
+		status := http.StatusOK
+		if report.Status != health.StatusOK {
+			status = http.StatusServiceUnavailable
+		}
+		c.JSON(status, report)
+	}
+}
+
+// setupRouter wires the routes. Requests are logged to logger. The caller
+// owns sendOtpLimiter and closes it once the router is no longer used.
+func setupRouter(logger *slog.Logger, sendOtpLimiter *ratelimit.TokenBucket) *gin.Engine {
+	r := gin.New()
+	r.Use(
+		otelgin.Middleware(tracing.ServiceName, otelgin.WithGinFilter(func(c *gin.Context) bool {
+			return c.FullPath() != "/metrics"
+		})),
+		logging.Middleware(logger),
+		metrics.Middleware(),
+		gin.Recovery(),
+	)
 
 	docs.SwaggerInfo.Title = "Dekamond Task"
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
-	tb := ratelimit.NewTokenBucket("send-otp", 3, time.Minute*10, ratelimit.NewInMemoryStateManager())
-	r.POST("/send-otp", tb.GinMiddleware(), sendOtp)
-	r.POST("/verify-otp", verifyOtp)
-
-	r.GET("/users/:id", getUserByID)
-	r.GET("/users", getUsers)
-	r.GET("/users/search", searchUsers)
+	checks := health.NewChecks(cfg.Health.Timeout)
+	checks.Register("users", usersRepo)
+	checks.Register("otp", otpProvider)
+	checks.Register("rate_limit", sendOtpLimiter)
+	r.GET("/healthz", healthz)
+	r.GET("/readyz", readyz(checks))
+	r.GET("/metrics", metrics.Handler())
 
-	r.Run()
+	r.POST("/send-otp", sendOtpLimiter.GinMiddleware(), sendOtp)
+	r.POST("/verify-otp", verifyOtp)
+	r.POST("/verify-totp", verifyTOTP)
+	r.GET("/magic-link", openMagicLink)
+	r.POST("/magic-link", useMagicLink)
+	r.GET("/email/verify", verifyEmail)
+
+	u := r.Group("/users", requireAuth())
+	u.GET("", authz.Require(authz.PermListUsers), getUsers)
+	u.GET("/search", authz.Require(authz.PermListUsers), searchUsers)
+	u.GET("/:id", authz.RequireOwnerOr("id", authz.PermReadUsers), getUserByID)
+	u.PATCH("/:id", authz.RequireOwnerOr("id", authz.PermUpdateUsers), updateUser)
+	u.DELETE("/:id", authz.RequireOwnerOr("id", authz.PermDeleteUsers), deleteUser)
+	u.POST("/:id/restore", authz.Require(authz.PermDeleteUsers), restoreUser)
+	u.PUT("/:id/status", authz.Require(authz.PermManageStatus), setUserStatus)
+	u.PUT("/:id/role", authz.Require(authz.PermManageRoles), setUserRole)
+
+	r.POST("/devices/login/begin", beginDeviceLogin)
+	r.POST("/devices/login/finish", finishDeviceLogin)
+
+	wa := r.Group("/webauthn")
+	wa.POST("/register/begin", requireAuth(), beginPasskeyRegistration)
+	wa.POST("/register/finish", requireAuth(), finishPasskeyRegistration)
+	wa.POST("/login/begin", beginPasskeyLogin)
+	wa.POST("/login/finish", finishPasskeyLogin)
+
+	me := r.Group("/me", requireAuth())
+	me.GET("", getMe)
+	me.PATCH("", updateMe)
+	me.POST("/email/verify", sendEmailVerification)
+	me.POST("/totp/enroll", enrollTOTP)
+	me.POST("/totp/confirm", confirmTOTP)
+	me.GET("/devices", listDevices)
+	me.DELETE("/devices/:id", deleteDevice)
+	me.GET("/sessions", listSessions)
+	me.DELETE("/sessions", deleteSessions)
+	me.DELETE("/sessions/:id", deleteSession)
+
+	return r
 }
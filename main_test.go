@@ -0,0 +1,921 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/epicmet/dekamond-task/internal/config"
+	"github.com/epicmet/dekamond-task/internal/health"
+	"github.com/epicmet/dekamond-task/internal/otp"
+	"github.com/epicmet/dekamond-task/internal/passkey/passkeytest"
+	"github.com/epicmet/dekamond-task/internal/problem"
+	ratelimit "github.com/epicmet/dekamond-task/internal/rate-limit"
+	"github.com/epicmet/dekamond-task/internal/users"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/goleak"
+)
+
+func TestMain(m *testing.M) {
+	goleak.VerifyTestMain(m)
+}
+
+var otpLine = regexp.MustCompile(`PhoneNumber = (\S+), OTP = (\d+)`)
+
+var magicLinkLine = regexp.MustCompile(`http://\S+/magic-link\?token=\S+`)
+
+var verifyLinkLine = regexp.MustCompile(`http://\S+/email/verify\?token=\S+`)
+
+type testServer struct {
+	t      *testing.T
+	router *gin.Engine
+	otps   *bytes.Buffer
+
+	otpState *otp.MemStateManager
+}
+
+// newTestServer wires the handlers to an in-memory user repository and an
+// OTP provider that writes codes to a buffer instead of stdout.
+func newTestServer(t *testing.T) *testServer {
+	gin.SetMode(gin.TestMode)
+
+	var buf bytes.Buffer
+	cfg = config.Default()
+	usersRepo = users.NewMemoryUserRepository()
+
+	otpOpts, err := otpOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otpState := otp.NewMemStateManager(cfg.OTP.TTL)
+	t.Cleanup(func() { otpState.Close() })
+	otpProvider = newOTPProvider("console", otp.NewConsoleOTP(otpState, &buf, otpOpts))
+	mfaChallenges = otp.NewChallenges(otpState, "mfa", cfg.OTP.MaxAttempts)
+	deviceChallenges = otp.NewChallenges(otpState, "device", cfg.OTP.MaxAttempts)
+	magicLinks = newMagicLinks(otpState, &buf)
+	passkeys, err = newPasskeys(otpState)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rateLimitState := ratelimit.NewInMemoryStateManager()
+	t.Cleanup(func() { rateLimitState.Close() })
+	limiter := ratelimit.NewTokenBucket("send-otp", cfg.RateLimit.SendOTPCapacity, cfg.RateLimit.SendOTPRefill, rateLimitState)
+	t.Cleanup(func() { limiter.Close() })
+
+	return &testServer{t: t, router: setupRouter(slog.New(slog.DiscardHandler), limiter), otps: &buf, otpState: otpState}
+}
+
+func (s *testServer) do(method, path, token string, body any) *httptest.ResponseRecorder {
+	s.t.Helper()
+
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			s.t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	return w
+}
+
+// lastOTP returns the last code sent to the phone number.
+func (s *testServer) lastOTP(phone string) string {
+	s.t.Helper()
+
+	var code string
+	for _, m := range otpLine.FindAllStringSubmatch(s.otps.String(), -1) {
+		if m[1] == phone {
+			code = m[2]
+		}
+	}
+	if code == "" {
+		s.t.Fatalf("no OTP was sent to %s", phone)
+	}
+	return code
+}
+
+func (s *testServer) login(phone string) string {
+	s.t.Helper()
+
+	if w := s.do("POST", "/send-otp", "", gin.H{"phone": phone}); w.Code != http.StatusOK {
+		s.t.Fatalf("send-otp returned %d: %s", w.Code, w.Body)
+	}
+
+	w := s.do("POST", "/verify-otp", "", gin.H{"phone": phone, "otp": s.lastOTP(phone)})
+	if w.Code != http.StatusOK {
+		s.t.Fatalf("verify-otp returned %d: %s", w.Code, w.Body)
+	}
+
+	var resp struct {
+		Token string `json:"token"`
+	}
+	decode(s.t, w, &resp)
+	return resp.Token
+}
+
+// verifyEmail sets the email address of the user with the token and
+// confirms it with the emailed link.
+func (s *testServer) verifyEmail(token, email string) {
+	s.t.Helper()
+
+	if w := s.do("PATCH", "/me", token, gin.H{"email": email}); w.Code != http.StatusOK {
+		s.t.Fatalf("PATCH /me returned %d: %s", w.Code, w.Body)
+	}
+	if w := s.do("POST", "/me/email/verify", token, nil); w.Code != http.StatusOK {
+		s.t.Fatalf("POST /me/email/verify returned %d: %s", w.Code, w.Body)
+	}
+	links := verifyLinkLine.FindAllString(s.otps.String(), -1)
+	if len(links) == 0 {
+		s.t.Fatalf("no verification link was emailed: %s", s.otps)
+	}
+	if w := s.do("GET", strings.TrimPrefix(links[len(links)-1], "http://localhost:8080"), "", nil); w.Code != http.StatusOK {
+		s.t.Fatalf("opening the verification link returned %d: %s", w.Code, w.Body)
+	}
+}
+
+func decode(t *testing.T, w *httptest.ResponseRecorder, v any) {
+	t.Helper()
+
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding %q: %v", w.Body, err)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	s := newTestServer(t)
+
+	w := s.do("POST", "/send-otp", "", gin.H{"phone": "09120000001"})
+	var sent struct {
+		ExpiresIn   int    `json:"expires_in"`
+		ResendAfter int    `json:"resend_after"`
+		CodeLength  int    `json:"code_length"`
+		Locale      string `json:"locale"`
+	}
+	decode(t, w, &sent)
+	if w.Code != http.StatusOK || sent.ExpiresIn != 120 || sent.ResendAfter != 60 || sent.CodeLength != 6 || sent.Locale != "fa" {
+		t.Fatalf("send-otp returned %d: %s", w.Code, w.Body)
+	}
+	if w := s.do("POST", "/send-otp", "", gin.H{"phone": "09120000001"}); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("send-otp within the cooldown returned %d: %s", w.Code, w.Body)
+	}
+
+	if w := s.do("POST", "/verify-otp", "", gin.H{"phone": "09120000001", "otp": "wrong"}); w.Code != http.StatusBadRequest {
+		t.Fatalf("verify-otp with a wrong code returned %d", w.Code)
+	}
+
+	w = s.do("POST", "/verify-otp", "", gin.H{"phone": "09120000001", "otp": s.lastOTP("09120000001")})
+	if w.Code != http.StatusOK {
+		t.Fatalf("verify-otp returned %d: %s", w.Code, w.Body)
+	}
+
+	var resp struct {
+		Token string `json:"token"`
+	}
+	decode(t, w, &resp)
+
+	w = s.do("GET", "/me", resp.Token, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /me returned %d: %s", w.Code, w.Body)
+	}
+
+	var me users.User
+	decode(t, w, &me)
+	if me.PhoneNumber != "09120000001" || me.Role != users.RoleUser {
+		t.Fatalf("GET /me returned %+v", me)
+	}
+}
+
+func TestAuthRequired(t *testing.T) {
+	s := newTestServer(t)
+
+	for _, token := range []string{"", "not-a-token"} {
+		if w := s.do("GET", "/me", token, nil); w.Code != http.StatusUnauthorized {
+			t.Errorf("GET /me with token %q returned %d", token, w.Code)
+		}
+		if w := s.do("GET", "/users", token, nil); w.Code != http.StatusUnauthorized {
+			t.Errorf("GET /users with token %q returned %d", token, w.Code)
+		}
+	}
+}
+
+func TestUserManagementPermissions(t *testing.T) {
+	s := newTestServer(t)
+	cfg.AdminPhoneNumbers = []string{"09129999999"}
+
+	userToken := s.login("09120000001")
+	adminToken := s.login("09129999999")
+
+	var me users.User
+	decode(t, s.do("GET", "/me", userToken, nil), &me)
+	userID := me.ID.Hex()
+
+	if w := s.do("GET", "/users", userToken, nil); w.Code != http.StatusForbidden {
+		t.Errorf("GET /users as a user returned %d", w.Code)
+	}
+	if w := s.do("GET", "/users/search?phone=0912", userToken, nil); w.Code != http.StatusForbidden {
+		t.Errorf("GET /users/search as a user returned %d", w.Code)
+	}
+	if w := s.do("GET", "/users/"+userID, userToken, nil); w.Code != http.StatusOK {
+		t.Errorf("GET /users/{id} as its owner returned %d", w.Code)
+	}
+
+	w := s.do("GET", "/users?sort=phone_number", adminToken, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /users as an admin returned %d: %s", w.Code, w.Body)
+	}
+	var page users.PaginatedUsers
+	decode(t, w, &page)
+	if page.TotalCount != 2 || page.Users[0].PhoneNumber != "09120000001" || page.Users[1].Role != users.RoleAdmin {
+		t.Fatalf("GET /users returned %+v", page)
+	}
+
+	var admin users.User
+	decode(t, s.do("GET", "/me", adminToken, nil), &admin)
+	if w := s.do("GET", "/users/"+admin.ID.Hex(), userToken, nil); w.Code != http.StatusForbidden {
+		t.Errorf("GET /users/{id} of another user returned %d", w.Code)
+	}
+
+	if w := s.do("GET", "/users/000000000000000000000001", adminToken, nil); w.Code != http.StatusNotFound {
+		t.Errorf("GET /users/{id} of a missing user returned %d", w.Code)
+	}
+	if w := s.do("GET", "/users/not-an-id", adminToken, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("GET /users/{id} with a malformed ID returned %d", w.Code)
+	}
+	w = s.do("PATCH", "/users/"+userID, adminToken, gin.H{"email": "admin@example.com"})
+	var p problem.Problem
+	decode(t, w, &p)
+	if w.Code != http.StatusForbidden || p.Code != problem.CodePermissionDenied {
+		t.Errorf("changing the email of another user returned %d: %s", w.Code, w.Body)
+	}
+	if w := s.do("PATCH", "/users/"+userID, adminToken, gin.H{"first_name": "Sara"}); w.Code != http.StatusOK {
+		t.Errorf("changing the name of another user returned %d: %s", w.Code, w.Body)
+	}
+	if w := s.do("POST", "/users/"+userID+"/restore", adminToken, nil); w.Code != http.StatusConflict {
+		t.Errorf("restoring a user that isn't deleted returned %d", w.Code)
+	}
+	if w := s.do("GET", "/users?sort=password", adminToken, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("GET /users with an unknown sort field returned %d", w.Code)
+	}
+
+	support, err := usersRepo.Upsert(t.Context(), "09120000002")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := usersRepo.SetRole(t.Context(), support.ID.Hex(), users.RoleSupport); err != nil {
+		t.Fatal(err)
+	}
+	supportToken := s.login("09120000002")
+	suspend := gin.H{"status": "suspended", "reason": "spam"}
+	w = s.do("PUT", "/users/"+admin.ID.Hex()+"/status", supportToken, suspend)
+	decode(t, w, &p)
+	if w.Code != http.StatusForbidden || p.Code != problem.CodePermissionDenied {
+		t.Errorf("suspending an admin as support returned %d: %s", w.Code, w.Body)
+	}
+	if w := s.do("PUT", "/users/"+support.ID.Hex()+"/status", supportToken, suspend); w.Code != http.StatusForbidden {
+		t.Errorf("suspending support as support returned %d: %s", w.Code, w.Body)
+	}
+	if w := s.do("PUT", "/users/"+userID+"/status", supportToken, suspend); w.Code != http.StatusOK {
+		t.Errorf("suspending a user as support returned %d: %s", w.Code, w.Body)
+	}
+	if w := s.do("PUT", "/users/"+support.ID.Hex()+"/status", adminToken, suspend); w.Code != http.StatusOK {
+		t.Errorf("suspending support as an admin returned %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestUpdateMe(t *testing.T) {
+	s := newTestServer(t)
+	token := s.login("09120000001")
+
+	if w := s.do("PATCH", "/me", token, gin.H{"email": "not an email"}); w.Code != http.StatusBadRequest {
+		t.Errorf("PATCH /me with an invalid email returned %d", w.Code)
+	}
+	if w := s.do("PATCH", "/me", token, gin.H{"phone_number": "09350000000"}); w.Code != http.StatusBadRequest {
+		t.Errorf("PATCH /me changing the phone number returned %d", w.Code)
+	}
+
+	w := s.do("PATCH", "/me", token, gin.H{"first_name": "Sara", "locale": "fa-IR"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("PATCH /me returned %d: %s", w.Code, w.Body)
+	}
+
+	var me users.User
+	decode(t, w, &me)
+	if me.FirstName != "Sara" || me.Locale != "fa-IR" || me.PhoneNumber != "09120000001" {
+		t.Fatalf("PATCH /me returned %+v", me)
+	}
+}
+
+func TestTOTP(t *testing.T) {
+	s := newTestServer(t)
+	token := s.login("09120000001")
+
+	w := s.do("POST", "/me/totp/enroll", token, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("enroll returned %d: %s", w.Code, w.Body)
+	}
+	var enrollment struct {
+		Secret string `json:"secret"`
+	}
+	decode(t, w, &enrollment)
+
+	opts := totpOptions()
+	code, err := opts.Code(enrollment.Secret, opts.Step(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = s.do("POST", "/me/totp/confirm", token, gin.H{"code": code})
+	if w.Code != http.StatusOK {
+		t.Fatalf("confirm returned %d: %s", w.Code, w.Body)
+	}
+	var confirmation struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	decode(t, w, &confirmation)
+	if len(confirmation.RecoveryCodes) != cfg.TOTP.RecoveryCodes {
+		t.Fatalf("got %d recovery codes", len(confirmation.RecoveryCodes))
+	}
+
+	mfaToken := func() string {
+		s.do("POST", "/send-otp", "", gin.H{"phone": "09120000001"})
+		w := s.do("POST", "/verify-otp", "", gin.H{"phone": "09120000001", "otp": s.lastOTP("09120000001")})
+		var resp struct {
+			Token    string `json:"token"`
+			MFAToken string `json:"mfa_token"`
+		}
+		decode(t, w, &resp)
+		if resp.Token != "" || resp.MFAToken == "" {
+			t.Fatalf("verify-otp with totp enabled returned %s", w.Body)
+		}
+		return resp.MFAToken
+	}
+
+	// The code used to confirm can't be used again, but the challenge stays
+	// open for another attempt.
+	challenge := mfaToken()
+	w = s.do("POST", "/verify-totp", "", gin.H{"mfa_token": challenge, "code": code})
+	var p problem.Problem
+	decode(t, w, &p)
+	if w.Code != http.StatusBadRequest || p.Code != problem.CodeOTPReused {
+		t.Fatalf("reused code returned %d: %s", w.Code, w.Body)
+	}
+
+	recovery := confirmation.RecoveryCodes[0]
+	w = s.do("POST", "/verify-totp", "", gin.H{"mfa_token": challenge, "recovery_code": recovery})
+	var resp struct {
+		Token string `json:"token"`
+	}
+	decode(t, w, &resp)
+	if w.Code != http.StatusOK || resp.Token == "" {
+		t.Fatalf("recovery code returned %d: %s", w.Code, w.Body)
+	}
+	if w := s.do("POST", "/verify-totp", "", gin.H{"mfa_token": mfaToken(), "recovery_code": recovery}); w.Code != http.StatusBadRequest {
+		t.Fatalf("used recovery code returned %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestPasskeyLogin(t *testing.T) {
+	s := newTestServer(t)
+	token := s.login("09120000001")
+	authenticator := passkeytest.New(cfg.WebAuthn.RPOrigins[0])
+
+	if w := s.do("POST", "/webauthn/register/begin", "", nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("registering without a token returned %d", w.Code)
+	}
+	w := s.do("POST", "/webauthn/register/begin", token, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("register/begin returned %d: %s", w.Code, w.Body)
+	}
+	credential, err := authenticator.Create(w.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := s.do("POST", "/webauthn/register/finish", token, json.RawMessage(credential)); w.Code != http.StatusCreated {
+		t.Fatalf("register/finish returned %d: %s", w.Code, w.Body)
+	}
+
+	w = s.do("POST", "/webauthn/login/begin", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("login/begin returned %d: %s", w.Code, w.Body)
+	}
+	assertion, err := authenticator.Get(w.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = s.do("POST", "/webauthn/login/finish", "", json.RawMessage(assertion))
+	var resp struct {
+		Token string `json:"token"`
+	}
+	decode(t, w, &resp)
+	if w.Code != http.StatusOK || resp.Token == "" {
+		t.Fatalf("login/finish returned %d: %s", w.Code, w.Body)
+	}
+	if w := s.do("GET", "/me", resp.Token, nil); w.Code != http.StatusOK {
+		t.Errorf("GET /me with the passkey token returned %d", w.Code)
+	}
+
+	w = s.do("POST", "/webauthn/login/finish", "", json.RawMessage(assertion))
+	var p problem.Problem
+	decode(t, w, &p)
+	if w.Code != http.StatusBadRequest || p.Code != problem.CodePasskeyChallengeUnknown {
+		t.Errorf("replayed login/finish returned %d: %s", w.Code, w.Body)
+	}
+
+	// A passkey replaces the OTP, not the second factor.
+	w = s.do("POST", "/me/totp/enroll", token, nil)
+	var enrollment struct {
+		Secret string `json:"secret"`
+	}
+	decode(t, w, &enrollment)
+	opts := totpOptions()
+	code, err := opts.Code(enrollment.Secret, opts.Step(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = s.do("POST", "/me/totp/confirm", token, gin.H{"code": code})
+	var confirmation struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	decode(t, w, &confirmation)
+	if w.Code != http.StatusOK {
+		t.Fatalf("confirm returned %d: %s", w.Code, w.Body)
+	}
+
+	w = s.do("POST", "/webauthn/login/begin", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("login/begin returned %d: %s", w.Code, w.Body)
+	}
+	assertion, err = authenticator.Get(w.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = s.do("POST", "/webauthn/login/finish", "", json.RawMessage(assertion))
+	var login struct {
+		Token    string `json:"token"`
+		MFAToken string `json:"mfa_token"`
+	}
+	decode(t, w, &login)
+	if w.Code != http.StatusOK || login.Token != "" || login.MFAToken == "" {
+		t.Fatalf("login/finish with totp enabled returned %d: %s", w.Code, w.Body)
+	}
+	w = s.do("POST", "/verify-totp", "", gin.H{"mfa_token": login.MFAToken, "recovery_code": confirmation.RecoveryCodes[0]})
+	decode(t, w, &login)
+	if w.Code != http.StatusOK || login.Token == "" {
+		t.Errorf("verify-totp after a passkey returned %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestMagicLink(t *testing.T) {
+	s := newTestServer(t)
+	token := s.login("09120000001")
+
+	w := s.do("POST", "/send-otp", "", gin.H{"phone": "09120000001", "delivery": "link"})
+	var p problem.Problem
+	decode(t, w, &p)
+	if w.Code != http.StatusConflict || p.Code != problem.CodeEmailNotSet {
+		t.Fatalf("sending a link without an email returned %d: %s", w.Code, w.Body)
+	}
+
+	s.verifyEmail(token, "user@example.com")
+	if w := s.do("POST", "/send-otp", "", gin.H{"phone": "09120000001", "delivery": "link"}); w.Code != http.StatusOK {
+		t.Fatalf("send-otp with a link returned %d: %s", w.Code, w.Body)
+	}
+	link := magicLinkLine.FindString(s.otps.String())
+	if link == "" {
+		t.Fatalf("no link was emailed: %s", s.otps)
+	}
+	path := strings.TrimPrefix(link, "http://localhost:8080")
+	parsed, err := url.Parse(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	linkToken := parsed.Query().Get("token")
+	if strings.Contains(link, "09120000001") {
+		t.Errorf("the link carries the phone number: %s", link)
+	}
+
+	// Opening the link, as mail scanners do, doesn't use it.
+	w = s.do("GET", path, "", nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `<form method="post"`) {
+		t.Fatalf("opening the link returned %d: %s", w.Code, w.Body)
+	}
+
+	w = s.do("POST", "/magic-link", "", gin.H{"token": linkToken + "x"})
+	decode(t, w, &p)
+	if w.Code != http.StatusBadRequest || p.Code != problem.CodeMagicLinkInvalid {
+		t.Errorf("a tampered link returned %d: %s", w.Code, w.Body)
+	}
+
+	// The confirmation page posts the token as a form.
+	req := httptest.NewRequest("POST", "/magic-link", strings.NewReader(url.Values{"token": {linkToken}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	var resp struct {
+		Token string `json:"token"`
+	}
+	decode(t, w, &resp)
+	if w.Code != http.StatusOK || resp.Token == "" {
+		t.Fatalf("using the link returned %d: %s", w.Code, w.Body)
+	}
+	if w := s.do("GET", "/me", resp.Token, nil); w.Code != http.StatusOK {
+		t.Errorf("GET /me with the link token returned %d", w.Code)
+	}
+
+	w = s.do("POST", "/magic-link", "", gin.H{"token": linkToken})
+	decode(t, w, &p)
+	if w.Code != http.StatusBadRequest || p.Code != problem.CodeOTPNotRequested {
+		t.Errorf("reusing the link returned %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestMagicLinkRedirect(t *testing.T) {
+	s := newTestServer(t)
+	cfg.MagicLink.RedirectURL = "app://login?source=email"
+	token := s.login("09120000001")
+	s.verifyEmail(token, "user@example.com")
+	if w := s.do("POST", "/send-otp", "", gin.H{"phone": "09120000001", "delivery": "link"}); w.Code != http.StatusOK {
+		t.Fatalf("send-otp with a link returned %d: %s", w.Code, w.Body)
+	}
+
+	w := s.do("GET", strings.TrimPrefix(magicLinkLine.FindString(s.otps.String()), "http://localhost:8080"), "", nil)
+	location, err := url.Parse(w.Header().Get("Location"))
+	if w.Code != http.StatusFound || err != nil {
+		t.Fatalf("opening the link with a redirect URL returned %d: %s", w.Code, w.Body)
+	}
+	fragment, err := url.ParseQuery(location.Fragment)
+	if err != nil || fragment.Get("token") == "" || location.Query().Has("token") {
+		t.Fatalf("redirected to %s, want the token in the fragment only", location)
+	}
+
+	w = s.do("POST", "/magic-link", "", gin.H{"token": fragment.Get("token")})
+	var resp struct {
+		Token string `json:"token"`
+	}
+	decode(t, w, &resp)
+	if w.Code != http.StatusOK || resp.Token == "" {
+		t.Errorf("using the redirected link returned %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestEmailVerification(t *testing.T) {
+	s := newTestServer(t)
+	token := s.login("09120000001")
+
+	w := s.do("POST", "/me/email/verify", token, nil)
+	var p problem.Problem
+	decode(t, w, &p)
+	if w.Code != http.StatusConflict || p.Code != problem.CodeEmailNotSet {
+		t.Fatalf("verifying without an email returned %d: %s", w.Code, w.Body)
+	}
+
+	if w := s.do("PATCH", "/me", token, gin.H{"email": "user@example.com"}); w.Code != http.StatusOK {
+		t.Fatalf("PATCH /me returned %d: %s", w.Code, w.Body)
+	}
+	w = s.do("POST", "/send-otp", "", gin.H{"phone": "09120000001", "delivery": "link"})
+	decode(t, w, &p)
+	if w.Code != http.StatusConflict || p.Code != problem.CodeEmailNotVerified {
+		t.Fatalf("sending a login link to an unverified email returned %d: %s", w.Code, w.Body)
+	}
+
+	s.verifyEmail(token, "user@example.com")
+	var me users.User
+	decode(t, s.do("GET", "/me", token, nil), &me)
+	if me.EmailVerifiedAt == nil {
+		t.Fatalf("email not verified after opening the link: %+v", me)
+	}
+
+	// A link for the old address doesn't verify the new one.
+	if err := s.otpState.Del(t.Context(), "verify:"+me.ID.Hex()); err != nil {
+		t.Fatal(err)
+	}
+	if w := s.do("POST", "/me/email/verify", token, nil); w.Code != http.StatusOK {
+		t.Fatalf("POST /me/email/verify returned %d: %s", w.Code, w.Body)
+	}
+	links := verifyLinkLine.FindAllString(s.otps.String(), -1)
+	w = s.do("PATCH", "/me", token, gin.H{"email": "new@example.com"})
+	var changed users.User
+	decode(t, w, &changed)
+	if w.Code != http.StatusOK || changed.EmailVerifiedAt != nil {
+		t.Fatalf("changing the email returned %d: %s", w.Code, w.Body)
+	}
+	w = s.do("GET", strings.TrimPrefix(links[len(links)-1], "http://localhost:8080"), "", nil)
+	decode(t, w, &p)
+	if w.Code != http.StatusConflict || p.Code != problem.CodeConflict {
+		t.Errorf("opening a link for the old email returned %d: %s", w.Code, w.Body)
+	}
+
+	w = s.do("GET", "/email/verify?token=x", "", nil)
+	decode(t, w, &p)
+	if w.Code != http.StatusBadRequest || p.Code != problem.CodeMagicLinkInvalid {
+		t.Errorf("opening a malformed link returned %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestTrustedDevice(t *testing.T) {
+	s := newTestServer(t)
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	begin := gin.H{"phone": "09120000001", "device_id": "device-1"}
+	w := s.do("POST", "/devices/login/begin", "", begin)
+	var p problem.Problem
+	decode(t, w, &p)
+	if w.Code != http.StatusUnauthorized || p.Code != problem.CodeDeviceNotTrusted {
+		t.Fatalf("begin with an untrusted device returned %d: %s", w.Code, w.Body)
+	}
+
+	if w := s.do("POST", "/send-otp", "", gin.H{"phone": "09120000001"}); w.Code != http.StatusOK {
+		t.Fatalf("send-otp returned %d: %s", w.Code, w.Body)
+	}
+	dev := gin.H{"id": "device-1", "name": "Pixel", "public_key": der}
+	w = s.do("POST", "/verify-otp", "", gin.H{"phone": "09120000001", "otp": s.lastOTP("09120000001"), "device": dev})
+	var login struct {
+		Token              string    `json:"token"`
+		DeviceTrustedUntil time.Time `json:"device_trusted_until"`
+	}
+	decode(t, w, &login)
+	if w.Code != http.StatusOK || login.DeviceTrustedUntil.Before(time.Now().Add(cfg.Devices.TrustTTL-time.Minute)) {
+		t.Fatalf("verify-otp with a device returned %d: %s", w.Code, w.Body)
+	}
+
+	w = s.do("POST", "/devices/login/begin", "", begin)
+	var challenge struct {
+		Challenge string `json:"challenge"`
+	}
+	decode(t, w, &challenge)
+	if w.Code != http.StatusOK {
+		t.Fatalf("begin returned %d: %s", w.Code, w.Body)
+	}
+
+	w = s.do("POST", "/devices/login/finish", "", gin.H{"challenge": challenge.Challenge, "signature": ed25519.Sign(key, []byte("other"))})
+	decode(t, w, &p)
+	if w.Code != http.StatusBadRequest || p.Code != problem.CodeOTPInvalid {
+		t.Errorf("finish with a wrong signature returned %d: %s", w.Code, w.Body)
+	}
+	signature := ed25519.Sign(key, []byte(challenge.Challenge))
+	w = s.do("POST", "/devices/login/finish", "", gin.H{"challenge": challenge.Challenge, "signature": signature})
+	var resp struct {
+		Token string `json:"token"`
+	}
+	decode(t, w, &resp)
+	if w.Code != http.StatusOK || resp.Token == "" {
+		t.Fatalf("finish returned %d: %s", w.Code, w.Body)
+	}
+	if w := s.do("POST", "/devices/login/finish", "", gin.H{"challenge": challenge.Challenge, "signature": signature}); w.Code != http.StatusBadRequest {
+		t.Errorf("replayed finish returned %d: %s", w.Code, w.Body)
+	}
+
+	w = s.do("GET", "/me/devices", resp.Token, nil)
+	var devices struct {
+		Devices []users.Device `json:"devices"`
+	}
+	decode(t, w, &devices)
+	if w.Code != http.StatusOK || len(devices.Devices) != 1 || devices.Devices[0].Name != "Pixel" || devices.Devices[0].LastUsedAt == nil {
+		t.Fatalf("GET /me/devices returned %d: %s", w.Code, w.Body)
+	}
+
+	if w := s.do("DELETE", "/me/devices/device-1", resp.Token, nil); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE /me/devices/device-1 returned %d: %s", w.Code, w.Body)
+	}
+	if w := s.do("DELETE", "/me/devices/device-1", resp.Token, nil); w.Code != http.StatusNotFound {
+		t.Errorf("deleting a revoked device returned %d", w.Code)
+	}
+	if w := s.do("POST", "/devices/login/begin", "", begin); w.Code != http.StatusUnauthorized {
+		t.Errorf("begin with a revoked device returned %d", w.Code)
+	}
+}
+
+func TestTrustedDeviceWithTOTP(t *testing.T) {
+	s := newTestServer(t)
+	token := s.login("09120000001")
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := s.do("POST", "/me/totp/enroll", token, nil)
+	var enrollment struct {
+		Secret string `json:"secret"`
+	}
+	decode(t, w, &enrollment)
+	opts := totpOptions()
+	code, err := opts.Code(enrollment.Secret, opts.Step(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = s.do("POST", "/me/totp/confirm", token, gin.H{"code": code})
+	var confirmation struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	decode(t, w, &confirmation)
+	if w.Code != http.StatusOK {
+		t.Fatalf("confirm returned %d: %s", w.Code, w.Body)
+	}
+
+	if w := s.do("POST", "/send-otp", "", gin.H{"phone": "09120000001"}); w.Code != http.StatusOK {
+		t.Fatalf("send-otp returned %d: %s", w.Code, w.Body)
+	}
+	dev := gin.H{"id": "device-1", "name": "Pixel", "public_key": der}
+	w = s.do("POST", "/verify-otp", "", gin.H{"phone": "09120000001", "otp": s.lastOTP("09120000001"), "device": dev})
+	var login struct {
+		MFAToken           string     `json:"mfa_token"`
+		DeviceTrustedUntil *time.Time `json:"device_trusted_until"`
+	}
+	decode(t, w, &login)
+	if w.Code != http.StatusOK || login.MFAToken == "" || login.DeviceTrustedUntil != nil {
+		t.Fatalf("verify-otp with a device and totp returned %d: %s", w.Code, w.Body)
+	}
+
+	begin := gin.H{"phone": "09120000001", "device_id": "device-1"}
+	if w := s.do("POST", "/devices/login/begin", "", begin); w.Code != http.StatusUnauthorized {
+		t.Fatalf("begin before the second factor returned %d: %s", w.Code, w.Body)
+	}
+
+	w = s.do("POST", "/verify-totp", "", gin.H{"mfa_token": login.MFAToken, "recovery_code": confirmation.RecoveryCodes[0]})
+	decode(t, w, &login)
+	if w.Code != http.StatusOK || login.DeviceTrustedUntil == nil {
+		t.Fatalf("verify-totp returned %d: %s", w.Code, w.Body)
+	}
+	if w := s.do("POST", "/devices/login/begin", "", begin); w.Code != http.StatusOK {
+		t.Errorf("begin after the second factor returned %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	s := newTestServer(t)
+	first := s.login("09120000001")
+	// Clear the resend cooldown to log in a second time.
+	if err := s.otpState.Del(t.Context(), "otp:09120000001"); err != nil {
+		t.Fatal(err)
+	}
+	second := s.login("09120000001")
+
+	w := s.do("GET", "/me/sessions", second, nil)
+	var resp struct {
+		Sessions         []users.Session `json:"sessions"`
+		CurrentSessionID string          `json:"current_session_id"`
+	}
+	decode(t, w, &resp)
+	if w.Code != http.StatusOK || len(resp.Sessions) != 2 || resp.Sessions[1].ID != resp.CurrentSessionID {
+		t.Fatalf("GET /me/sessions returned %d: %s", w.Code, w.Body)
+	}
+	// Requests only update last_seen_at once it is a minute old.
+	if current := resp.Sessions[1]; !current.LastSeenAt.Equal(current.CreatedAt) {
+		t.Errorf("session last seen at %v right after it was created at %v", current.LastSeenAt, current.CreatedAt)
+	}
+
+	if w := s.do("DELETE", "/me/sessions/"+resp.Sessions[0].ID, second, nil); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE /me/sessions/{id} returned %d: %s", w.Code, w.Body)
+	}
+	w = s.do("GET", "/me", first, nil)
+	var p problem.Problem
+	decode(t, w, &p)
+	if w.Code != http.StatusUnauthorized || p.Code != problem.CodeSessionRevoked {
+		t.Errorf("GET /me with a logged out token returned %d: %s", w.Code, w.Body)
+	}
+	if w := s.do("DELETE", "/me/sessions/"+resp.Sessions[0].ID, second, nil); w.Code != http.StatusNotFound {
+		t.Errorf("logging out a session twice returned %d", w.Code)
+	}
+
+	if w := s.do("DELETE", "/me/sessions", second, nil); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE /me/sessions returned %d: %s", w.Code, w.Body)
+	}
+	if w := s.do("GET", "/me", second, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("GET /me after logging out everywhere returned %d", w.Code)
+	}
+}
+
+func TestDeletedUserToken(t *testing.T) {
+	s := newTestServer(t)
+	oldToken := s.login("09120000001")
+
+	var me users.User
+	decode(t, s.do("GET", "/me", oldToken, nil), &me)
+	if w := s.do("DELETE", "/users/"+me.ID.Hex(), oldToken, nil); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE /users/{id} returned %d: %s", w.Code, w.Body)
+	}
+
+	// Signing up again with the phone number creates another account,
+	// which the old token doesn't log in to.
+	newToken := s.login("09120000001")
+	w := s.do("GET", "/me", oldToken, nil)
+	var p problem.Problem
+	decode(t, w, &p)
+	if w.Code != http.StatusUnauthorized || p.Code != problem.CodeTokenInvalid {
+		t.Errorf("GET /me with the deleted user's token returned %d: %s", w.Code, w.Body)
+	}
+	var again users.User
+	decode(t, s.do("GET", "/me", newToken, nil), &again)
+	if again.ID == me.ID {
+		t.Errorf("signing up again returned the deleted user %s", me.ID.Hex())
+	}
+}
+
+func TestSuspendedUser(t *testing.T) {
+	s := newTestServer(t)
+	cfg.AdminPhoneNumbers = []string{"09129999999"}
+
+	userToken := s.login("09120000001")
+	adminToken := s.login("09129999999")
+
+	var me users.User
+	decode(t, s.do("GET", "/me", userToken, nil), &me)
+
+	w := s.do("PUT", "/users/"+me.ID.Hex()+"/status", adminToken, gin.H{"status": "suspended", "reason": "spam"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT /users/{id}/status returned %d: %s", w.Code, w.Body)
+	}
+
+	w = s.do("GET", "/me", userToken, nil)
+	var p struct {
+		Code   problem.Code `json:"code"`
+		Reason string       `json:"reason"`
+	}
+	decode(t, w, &p)
+	if w.Code != http.StatusForbidden || p.Code != problem.CodeAccountBlocked || p.Reason != "spam" {
+		t.Errorf("GET /me as a suspended user returned %d: %s", w.Code, w.Body)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, problem.ContentType) {
+		t.Errorf("blocked response has content type %q", ct)
+	}
+	if w := s.do("POST", "/send-otp", "", gin.H{"phone": "09120000001"}); w.Code != http.StatusForbidden {
+		t.Errorf("send-otp for a suspended user returned %d", w.Code)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	s := newTestServer(t)
+
+	if w := s.do("GET", "/healthz", "", nil); w.Code != http.StatusOK {
+		t.Errorf("GET /healthz returned %d", w.Code)
+	}
+
+	w := s.do("GET", "/readyz", "", nil)
+	var report health.Report
+	decode(t, w, &report)
+	if w.Code != http.StatusOK || len(report.Checks) != 3 {
+		t.Fatalf("GET /readyz returned %d: %s", w.Code, w.Body)
+	}
+
+	s.otpState.Close()
+
+	w = s.do("GET", "/readyz", "", nil)
+	report = health.Report{}
+	decode(t, w, &report)
+	if w.Code != http.StatusServiceUnavailable || report.Checks["otp"].Status != health.StatusUnavailable {
+		t.Errorf("GET /readyz with a closed OTP store returned %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	s := newTestServer(t)
+	s.login("09120000001")
+
+	w := s.do("GET", "/metrics", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /metrics returned %d", w.Code)
+	}
+	for _, series := range []string{
+		`http_request_duration_seconds_count{method="POST",route="/verify-otp",status="200"}`,
+		`otp_sent_total{provider="console"}`,
+		`otp_verified_total{provider="console"}`,
+		`rate_limit_decisions_total{decision="allowed",limiter="send-otp"}`,
+	} {
+		if !strings.Contains(w.Body.String(), series) {
+			t.Errorf("GET /metrics is missing %s", series)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/epicmet/dekamond-task/internal/logging"
+	"github.com/epicmet/dekamond-task/internal/problem"
+	"github.com/epicmet/dekamond-task/internal/users"
+	"github.com/gin-gonic/gin"
+)
+
+// maxUserAgentLength bounds the user agent stored with a session.
+const maxUserAgentLength = 256
+
+// issueToken starts a session of user on the client of the request, from
+// the trusted device with deviceID if any, and returns a JWT bound to it.
+func issueToken(c *gin.Context, user *users.User, deviceID string) (string, error) {
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	userAgent := c.Request.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+
+	now := time.Now().UTC()
+	session := users.Session{
+		ID:         hex.EncodeToString(b),
+		UserAgent:  userAgent,
+		IP:         c.ClientIP(),
+		DeviceID:   deviceID,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(cfg.JWT.TTL),
+	}
+	if err := usersRepo.CreateSession(c.Request.Context(), user.ID.Hex(), session); err != nil {
+		return "", err
+	}
+
+	return generateJWT(c.Request.Context(), user, session)
+}
+
+// @Summary		List sessions
+// @Description	List where the authenticated user is logged in, oldest first. current_session_id is the session of the request's token.
+// @Tags			Me
+// @Produce		json
+// @Security		BearerAuth
+// @Success		200	{object}	object{sessions=[]users.Session,current_session_id=string}
+// @Failure		401	{object}	problem.Problem
+// @Failure		500	{object}	problem.Problem
+// @Router			/me/sessions [get]
+func listSessions(c *gin.Context) {
+	sessions, err := usersRepo.Sessions(c.Request.Context(), currentUser(c).ID.Hex())
+	if err != nil {
+		respondError(c, err, "fetch sessions")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions, "current_session_id": currentSessionID(c)})
+}
+
+// @Summary		Log out a session
+// @Description	Log out one session of the authenticated user, which may be the current one. Its tokens stop working.
+// @Tags			Me
+// @Security		BearerAuth
+// @Param			id	path	string	true	"Session ID"
+// @Success		204
+// @Failure		401	{object}	problem.Problem
+// @Failure		404	{object}	problem.Problem
+// @Failure		500	{object}	problem.Problem
+// @Router			/me/sessions/{id} [delete]
+func deleteSession(c *gin.Context) {
+	err := usersRepo.DeleteSession(c.Request.Context(), currentUser(c).ID.Hex(), c.Param("id"))
+	if errors.Is(err, users.ErrConflict) {
+		problem.Abort(c, problem.New(http.StatusNotFound, problem.CodeSessionNotFound, "session not found"))
+		return
+	}
+	if err != nil {
+		respondError(c, err, "log out session")
+		return
+	}
+	logging.FromContext(c.Request.Context()).Info("session logged out", "session_id", c.Param("id"))
+
+	c.Status(http.StatusNoContent)
+}
+
+// @Summary		Log out everywhere
+// @Description	Log out every session of the authenticated user, including the current one
+// @Tags			Me
+// @Security		BearerAuth
+// @Success		204
+// @Failure		401	{object}	problem.Problem
+// @Failure		500	{object}	problem.Problem
+// @Router			/me/sessions [delete]
+func deleteSessions(c *gin.Context) {
+	if err := usersRepo.DeleteSessions(c.Request.Context(), currentUser(c).ID.Hex()); err != nil {
+		respondError(c, err, "log out sessions")
+		return
+	}
+	logging.FromContext(c.Request.Context()).Info("logged out everywhere")
+
+	c.Status(http.StatusNoContent)
+}
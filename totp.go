@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/epicmet/dekamond-task/internal/logging"
+	"github.com/epicmet/dekamond-task/internal/otp"
+	"github.com/epicmet/dekamond-task/internal/problem"
+	"github.com/epicmet/dekamond-task/internal/users"
+	"github.com/gin-gonic/gin"
+)
+
+// mfaChallenges holds the second factor challenges of users who verified
+// their phone number and have TOTP enabled. The subject of a challenge is a
+// JSON encoded mfaSubject.
+var mfaChallenges *otp.Challenges
+
+// mfaSubject is who logs in, and from where, while their second factor is
+// pending.
+type mfaSubject struct {
+	UserID string `json:"user_id"`
+	// DeviceID is the device the user logs in from, if any.
+	DeviceID string `json:"device_id,omitempty"`
+	// Device is the device to trust once the login completes, if any.
+	Device *deviceRequest `json:"device,omitempty"`
+}
+
+func totpOptions() otp.TOTPOptions {
+	return otp.TOTPOptions{
+		Issuer: cfg.TOTP.Issuer,
+		Digits: 6,
+		Period: 30 * time.Second,
+		Skew:   cfg.TOTP.Skew,
+	}
+}
+
+// @Summary		Enroll TOTP
+// @Description	Start enrolling an authenticator app as second factor. The returned secret, otpauth:// URI or QR code PNG (base64) is added to the app, then confirmed with POST /me/totp/confirm. Enrolling again before confirming replaces the secret.
+// @Tags			Me
+// @Produce		json
+// @Security		BearerAuth
+// @Success		200	{object}	object{secret=string,otpauth_uri=string,qr_png=string}
+// @Failure		401	{object}	problem.Problem
+// @Failure		409	{object}	problem.Problem
+// @Failure		500	{object}	problem.Problem
+// @Router			/me/totp/enroll [post]
+func enrollTOTP(c *gin.Context) {
+	user := currentUser(c)
+	if user.TOTPEnabled() {
+		problem.Abort(c, problem.New(http.StatusConflict, problem.CodeTOTPAlreadyEnabled, "totp is already enabled"))
+		return
+	}
+
+	secret := otp.NewTOTPSecret()
+	uri := totpOptions().URI(user.PhoneNumber, secret)
+	png, err := otp.QRCodePNG(uri)
+	if err != nil {
+		respondError(c, err, "render qr code")
+		return
+	}
+
+	if _, err := usersRepo.SetTOTP(c.Request.Context(), user.ID.Hex(), &users.TOTP{Secret: secret}, nil); err != nil {
+		respondError(c, err, "enroll totp")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"secret": secret, "otpauth_uri": uri, "qr_png": png})
+}
+
+// @Summary		Confirm TOTP
+// @Description	Enable the enrolled authenticator app with a code it generated. The returned recovery codes can each be used once instead of a code and are never shown again.
+// @Tags			Me
+// @Accept			json
+// @Produce		json
+// @Security		BearerAuth
+// @Param			request	body		object{code=string}	true	"Code from the authenticator app"
+// @Success		200		{object}	object{recovery_codes=[]string}
+// @Failure		400		{object}	problem.Problem
+// @Failure		401		{object}	problem.Problem
+// @Failure		409		{object}	problem.Problem
+// @Failure		500		{object}	problem.Problem
+// @Router			/me/totp/confirm [post]
+func confirmTOTP(c *gin.Context) {
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Abort(c, problem.New(http.StatusBadRequest, problem.CodeInvalidRequest, "invalid request body"))
+		return
+	}
+
+	user := currentUser(c)
+	switch {
+	case user.TOTPEnabled():
+		problem.Abort(c, problem.New(http.StatusConflict, problem.CodeTOTPAlreadyEnabled, "totp is already enabled"))
+		return
+	case user.TOTP == nil:
+		problem.Abort(c, problem.New(http.StatusConflict, problem.CodeTOTPNotEnrolled, "enroll totp first"))
+		return
+	}
+
+	now := time.Now()
+	step, err := totpOptions().Validate(user.TOTP.Secret, req.Code, now, 0)
+	if errors.Is(err, otp.ErrOTPMismatch) {
+		problem.Abort(c, problem.New(http.StatusBadRequest, problem.CodeOTPInvalid, "invalid code"))
+		return
+	}
+	if err != nil {
+		respondError(c, err, "confirm totp")
+		return
+	}
+
+	recoveryCodes := otp.NewRecoveryCodes(cfg.TOTP.RecoveryCodes)
+	hashes := make([]string, len(recoveryCodes))
+	for i, code := range recoveryCodes {
+		hashes[i] = otp.HashRecoveryCode(code)
+	}
+
+	totp := &users.TOTP{Secret: user.TOTP.Secret, ConfirmedAt: &now, LastStep: step}
+	if _, err := usersRepo.SetTOTP(c.Request.Context(), user.ID.Hex(), totp, hashes); err != nil {
+		respondError(c, err, "confirm totp")
+		return
+	}
+	logging.FromContext(c.Request.Context()).Info("totp enabled")
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": recoveryCodes})
+}
+
+// @Summary		Verify TOTP
+// @Description	Complete a login with a code from the authenticator app, or one of the recovery codes, once /verify-otp returned an mfa_token. A device sent to /verify-otp is trusted now.
+// @Tags			OTP
+// @Accept			json
+// @Produce		json
+// @Param			request	body		object{mfa_token=string,code=string,recovery_code=string}	true	"MFA token and either a code or a recovery code"
+// @Success		200		{object}	object{message=string,token=string,device_trusted_until=string}
+// @Failure		400		{object}	problem.Problem
+// @Failure		403		{object}	problem.Problem
+// @Failure		429		{object}	problem.Problem
+// @Failure		500		{object}	problem.Problem
+// @Router			/verify-totp [post]
+func verifyTOTP(c *gin.Context) {
+	var req struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.MFAToken == "" {
+		problem.Abort(c, problem.New(http.StatusBadRequest, problem.CodeInvalidRequest, "invalid request body"))
+		return
+	}
+	if (req.Code == "") == (req.RecoveryCode == "") {
+		problem.Abort(c, problem.New(http.StatusBadRequest, problem.CodeValidationFailed, "exactly one of code and recovery_code is required").With("field", "code"))
+		return
+	}
+
+	ctx := c.Request.Context()
+	var user *users.User
+	var login mfaSubject
+	_, err := mfaChallenges.Pass(ctx, req.MFAToken, func(subject string) error {
+		if err := json.Unmarshal([]byte(subject), &login); err != nil {
+			return err
+		}
+		userID := login.UserID
+
+		var err error
+		user, err = usersRepo.FindByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if !user.TOTPEnabled() {
+			return otp.ErrOTPMismatch
+		}
+
+		if req.RecoveryCode != "" {
+			err := usersRepo.UseRecoveryCode(ctx, userID, otp.HashRecoveryCode(req.RecoveryCode))
+			if errors.Is(err, users.ErrConflict) {
+				return otp.ErrOTPMismatch
+			}
+			return err
+		}
+
+		step, err := totpOptions().Validate(user.TOTP.Secret, req.Code, time.Now(), user.TOTP.LastStep)
+		if err != nil {
+			return err
+		}
+		// Only one of concurrent requests with the same code gets through.
+		err = usersRepo.UseTOTPStep(ctx, userID, step)
+		if errors.Is(err, users.ErrConflict) {
+			return otp.ErrOTPReused
+		}
+		return err
+	})
+	if err != nil {
+		respondOTPError(c, "", err)
+		return
+	}
+
+	if user.IsBlocked(time.Now()) {
+		respondBlocked(c, user)
+		return
+	}
+
+	body := completeLogin(c, user, login, "otp verified successfully")
+	if body == nil {
+		return
+	}
+	c.JSON(http.StatusOK, body)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/epicmet/dekamond-task/internal/logging"
+	"github.com/epicmet/dekamond-task/internal/otp"
+	"github.com/epicmet/dekamond-task/internal/passkey"
+	"github.com/gin-gonic/gin"
+)
+
+var passkeys *passkey.Passkeys
+
+// newPasskeys keeps the ceremonies in sm, so they expire with OTPs.
+func newPasskeys(sm otp.OTPStateManager) (*passkey.Passkeys, error) {
+	return passkey.New(usersRepo, sm, passkey.Options{
+		RPID:          cfg.WebAuthn.RPID,
+		RPDisplayName: cfg.WebAuthn.RPName,
+		RPOrigins:     cfg.WebAuthn.RPOrigins,
+		Timeout:       cfg.OTP.TTL,
+	})
+}
+
+// @Summary		Begin passkey registration
+// @Description	Start registering a passkey for the authenticated user. The response is passed to navigator.credentials.create() and its result to /webauthn/register/finish.
+// @Tags			WebAuthn
+// @Produce		json
+// @Security		BearerAuth
+// @Success		200	{object}	object{publicKey=object}
+// @Failure		401	{object}	problem.Problem
+// @Failure		500	{object}	problem.Problem
+// @Router			/webauthn/register/begin [post]
+func beginPasskeyRegistration(c *gin.Context) {
+	creation, err := passkeys.BeginRegistration(c.Request.Context(), currentUser(c))
+	if err != nil {
+		respondError(c, err, "begin passkey registration")
+		return
+	}
+
+	c.JSON(http.StatusOK, creation)
+}
+
+// @Summary		Finish passkey registration
+// @Description	Register the passkey created by navigator.credentials.create()
+// @Tags			WebAuthn
+// @Accept			json
+// @Produce		json
+// @Security		BearerAuth
+// @Param			request	body		object	true	"PublicKeyCredential returned by navigator.credentials.create()"
+// @Success		201		{object}	object{message=string}
+// @Failure		400		{object}	problem.Problem
+// @Failure		401		{object}	problem.Problem
+// @Failure		409		{object}	problem.Problem
+// @Failure		500		{object}	problem.Problem
+// @Router			/webauthn/register/finish [post]
+func finishPasskeyRegistration(c *gin.Context) {
+	if _, err := passkeys.FinishRegistration(c.Request.Context(), currentUser(c), c.Request.Body); err != nil {
+		respondPasskeyError(c, err, "register passkey")
+		return
+	}
+	logging.FromContext(c.Request.Context()).Info("passkey registered")
+
+	c.JSON(http.StatusCreated, gin.H{"message": "passkey registered"})
+}
+
+// @Summary		Begin passkey login
+// @Description	Start logging in with a passkey. The response is passed to navigator.credentials.get() and its result to /webauthn/login/finish.
+// @Tags			WebAuthn
+// @Produce		json
+// @Success		200	{object}	object{publicKey=object}
+// @Failure		500	{object}	problem.Problem
+// @Router			/webauthn/login/begin [post]
+func beginPasskeyLogin(c *gin.Context) {
+	assertion, err := passkeys.BeginLogin(c.Request.Context())
+	if err != nil {
+		respondError(c, err, "begin passkey login")
+		return
+	}
+
+	c.JSON(http.StatusOK, assertion)
+}
+
+// @Summary		Finish passkey login
+// @Description	Log in with the assertion returned by navigator.credentials.get() and get a JWT token, or an mfa_token for /verify-totp if the user has TOTP enabled
+// @Tags			WebAuthn
+// @Accept			json
+// @Produce		json
+// @Param			request	body		object	true	"PublicKeyCredential returned by navigator.credentials.get()"
+// @Success		200		{object}	object{message=string,token=string,mfa_required=bool,mfa_token=string}
+// @Failure		400		{object}	problem.Problem
+// @Failure		403		{object}	problem.Problem
+// @Failure		500		{object}	problem.Problem
+// @Router			/webauthn/login/finish [post]
+func finishPasskeyLogin(c *gin.Context) {
+	user, err := passkeys.FinishLogin(c.Request.Context(), c.Request.Body)
+	if err != nil {
+		respondPasskeyError(c, err, "log in with passkey")
+		return
+	}
+
+	_, body := loginUser(c, user, mfaSubject{}, "passkey verified successfully")
+	if body == nil {
+		return
+	}
+	c.JSON(http.StatusOK, body)
+}